@@ -0,0 +1,275 @@
+package historian
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+	"github.com/golang/glog"
+)
+
+// Hierarchical name of a stream, like plane_1.flight_controller.state.
+// Field references match their stream globs against this.
+func StreamPath(data *dbproto.Stream) string {
+	parts := make([]string, 0, 3)
+	for _, part := range []string{data.DeviceHostname, data.ComponentName, data.StateName} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func referenceMatches(ref *dbproto.FieldReference, data *dbproto.Stream) bool {
+	if ref == nil || ref.Stream == "" {
+		return false
+	}
+	matched, _ := path.Match(ref.Stream, StreamPath(data))
+	return matched
+}
+
+// Time of a reading from a payload field, unix seconds or RFC3339.
+func readingTime(val interface{}) (time.Time, bool) {
+	if _, ok := val.(string); ok {
+		return parsePayloadTime(val, dbproto.PayloadTimestamp_RFC3339)
+	}
+	return parsePayloadTime(val, dbproto.PayloadTimestamp_SECONDS)
+}
+
+// Keeps an aggregate stream up to date with its source streams.
+type aggregator struct {
+	h    *Historian
+	data *dbproto.Stream
+	str  *Stream
+
+	mtx     sync.Mutex
+	sources map[string]*aggregateSource
+	fields  map[string]*aggregateField
+	// Fields changed by the change being applied, written as a mutation
+	changes stream.StateData
+}
+
+type aggregateSource struct {
+	str    *Stream
+	cancel func()
+}
+
+func (h *Historian) newAggregator(data *dbproto.Stream) (*aggregator, error) {
+	str, err := h.GetStream(data.Id)
+	if err != nil {
+		return nil, err
+	}
+	a := &aggregator{
+		h:       h,
+		data:    data,
+		str:     str,
+		sources: make(map[string]*aggregateSource),
+		fields:  make(map[string]*aggregateField),
+	}
+	return a, nil
+}
+
+// Flag the aggregators for a resync with the known streams.
+func (h *Historian) markAggregatesDirty() {
+	select {
+	case h.aggregatesDirty <- true:
+	default:
+	}
+}
+
+func (h *Historian) aggregateThread() {
+	for {
+		select {
+		case <-h.dispose:
+			for id, a := range h.aggregators {
+				a.stop()
+				delete(h.aggregators, id)
+			}
+			return
+		case <-h.aggregatesDirty:
+			h.syncAggregates()
+		}
+	}
+}
+
+// Start, stop and re-subscribe aggregators to match the known streams.
+func (h *Historian) syncAggregates() {
+	known := h.GetKnownStreams()
+
+	defined := make(map[string]*dbproto.Stream)
+	for _, data := range known {
+		if data.Source == dbproto.Stream_AGGREGATE {
+			defined[data.Id] = data
+		}
+	}
+
+	for id, a := range h.aggregators {
+		if data, ok := defined[id]; !ok || data != a.data {
+			glog.Infof("Stopping aggregation into %s.", id)
+			a.stop()
+			delete(h.aggregators, id)
+		}
+	}
+
+	for id, data := range defined {
+		a, ok := h.aggregators[id]
+		if !ok {
+			var err error
+			a, err = h.newAggregator(data)
+			if err != nil {
+				glog.Warningf("Unable to start aggregation into %s, %v", id, err)
+				continue
+			}
+			glog.Infof("Starting aggregation into %s.", id)
+			h.aggregators[id] = a
+		}
+		a.sync(known)
+	}
+}
+
+// Subscribe to the streams matching the field bindings.
+func (a *aggregator) sync(known []*dbproto.Stream) {
+	matched := make(map[string]bool)
+	for _, data := range known {
+		if data.Id == a.data.Id {
+			continue
+		}
+		for _, binding := range a.data.Fields {
+			if referenceMatches(binding.GetReference(), data) {
+				matched[data.Id] = true
+				break
+			}
+		}
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	for id, src := range a.sources {
+		if !matched[id] {
+			src.cancel()
+			delete(a.sources, id)
+		}
+	}
+
+	for id := range matched {
+		str, err := a.h.GetStream(id)
+		if err != nil {
+			glog.Warningf("Unable to load %s for aggregation into %s, %v", id, a.data.Id, err)
+			continue
+		}
+		if src, ok := a.sources[id]; ok {
+			if src.str == str {
+				continue
+			}
+			// The stream was reloaded.
+			src.cancel()
+		}
+		a.sources[id] = &aggregateSource{
+			str:    str,
+			cancel: str.OnStateChange(a.handleSourceChange),
+		}
+	}
+}
+
+func (a *aggregator) stop() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	for id, src := range a.sources {
+		src.cancel()
+		delete(a.sources, id)
+	}
+}
+
+// Apply a change to a source stream. Changes are aggregated by the
+// historian instance that ingests them.
+func (a *aggregator) handleSourceChange(change *StateChange) {
+	if !change.Local {
+		return
+	}
+
+	a.mtx.Lock()
+	a.changes = stream.StateData{}
+	for target, binding := range a.data.Fields {
+		ref := binding.GetReference()
+		if !referenceMatches(ref, change.Stream.Data) {
+			continue
+		}
+		value, ok := lookupPath(change.State, ref.Field)
+		if !ok {
+			continue
+		}
+		timestamp := change.Timestamp
+		if ref.Timestamp != "" {
+			if val, ok := lookupPath(change.State, ref.Timestamp); ok {
+				if ts, ok := readingTime(val); ok {
+					timestamp = ts
+				}
+			}
+		}
+
+		reading := &fieldReading{
+			source:    StreamPath(change.Stream.Data),
+			value:     value,
+			timestamp: timestamp,
+		}
+		a.resolve(target, binding, reading)
+	}
+	changes := a.changes
+	a.changes = nil
+	a.mtx.Unlock()
+
+	if len(changes) == 0 {
+		return
+	}
+	// Written unlocked, the handlers of the aggregate stream may feed back
+	// into this aggregator.
+	if err := a.write(change.Timestamp, changes); err != nil {
+		glog.Warningf("Error writing aggregate %s, %v", a.data.Id, err)
+	}
+}
+
+// Write changed fields to the aggregate stream as a mutation, so they merge
+// with the fields written by other historian instances. The entry goes after
+// the latest one in the stream, retrying once if another write takes its
+// timestamp first.
+func (a *aggregator) write(timestamp time.Time, changes stream.StateData) (err error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		entry := &stream.StreamEntry{
+			Type:      stream.StreamEntryMutation,
+			Data:      changes,
+			Timestamp: timestamp,
+		}
+		var latest time.Time
+		if latest, err = a.latestWrite(); err != nil {
+			return err
+		}
+		if latest.IsZero() {
+			entry.Type = stream.StreamEntrySnapshot
+		} else if !entry.Timestamp.After(latest) {
+			entry.Timestamp = latest.Add(time.Millisecond)
+		}
+		if err = a.str.WriteEntry(entry); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Timestamp of the live state of the aggregate stream, zero if empty.
+func (a *aggregator) latestWrite() (time.Time, error) {
+	writeCursor, err := a.str.StateStream.WriteCursor()
+	if err != nil || writeCursor == nil {
+		return time.Time{}, err
+	}
+	var latest time.Time
+	err = writeCursor.WriteGuard(func() error {
+		latest = writeCursor.ComputedTimestamp()
+		return nil
+	})
+	return latest, err
+}
@@ -0,0 +1,161 @@
+package historian_test
+
+import (
+	"testing"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/memory"
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+)
+
+func sensorStream(hostname string) *dbproto.Stream {
+	return &dbproto.Stream{Id: hostname + "_sensor_rx_sensor_233", DeviceHostname: hostname, ComponentName: "sensor_rx", StateName: "sensor_233"}
+}
+
+func sensorAggregate(glob string, maxRate uint64) *dbproto.Stream {
+	return &dbproto.Stream{
+		Id:            "sensors_sensor_233",
+		ComponentName: "sensors",
+		StateName:     "sensor_233",
+		Source:        dbproto.Stream_AGGREGATE,
+		Fields: map[string]*dbproto.FieldBinding{
+			"temperature": {Reference: &dbproto.FieldReference{
+				Stream:    glob,
+				Timestamp: "rx_timestamp",
+				Field:     "temperature",
+				MaxRate:   maxRate,
+			}},
+		},
+	}
+}
+
+// Writes readings to source streams at increasing entry timestamps.
+type sensorWriter struct {
+	t   *testing.T
+	h   *historian.Historian
+	sec int
+}
+
+func (w *sensorWriter) stream(id string) *historian.Stream {
+	waitFor(w.t, id+" to load", func() bool { return knownStream(w.h, id) != nil })
+	str, err := w.h.GetStream(id)
+	if err != nil {
+		w.t.Fatal(err)
+	}
+	return str
+}
+
+func (w *sensorWriter) write(str *historian.Stream, rx int, temperature float64) {
+	w.sec++
+	err := str.WriteEntry(&stream.StreamEntry{
+		Type:      stream.StreamEntrySnapshot,
+		Data:      stream.StateData{"rx_timestamp": float64(at(rx).Unix()), "temperature": temperature},
+		Timestamp: at(w.sec),
+	})
+	if err != nil {
+		w.t.Fatal(err)
+	}
+}
+
+// The aggregated temperature, nil if none.
+func (w *sensorWriter) temperature(str *historian.Stream) interface{} {
+	cursor := str.StateStream.BuildCursor(stream.ReadForwardCursor)
+	if err := cursor.Init(at(1000)); err != nil {
+		w.t.Fatal(err)
+	}
+	state, err := cursor.State()
+	if err != nil {
+		w.t.Fatal(err)
+	}
+	return state["temperature"]
+}
+
+func (w *sensorWriter) expect(what string, agg *historian.Stream, expected interface{}) {
+	if temperature := w.temperature(agg); temperature != expected {
+		w.t.Fatalf("%s: expected temperature %v, got %v", what, expected, temperature)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	b := memory.NewBackend()
+	for _, hostname := range []string{"plane_1", "plane_2"} {
+		b.PutStream(sensorStream(hostname))
+	}
+	other := &dbproto.Stream{Id: "plane_1_fc_state", DeviceHostname: "plane_1", ComponentName: "fc", StateName: "state"}
+	b.PutStream(other)
+	b.PutStream(sensorAggregate("*.sensor_rx.sensor_233", 20000))
+	h := historian.NewHistorian(b)
+	if err := h.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Dispose()
+
+	w := &sensorWriter{t: t, h: h}
+	agg := w.stream("sensors_sensor_233")
+	plane1 := w.stream("plane_1_sensor_rx_sensor_233")
+	plane2 := w.stream("plane_2_sensor_rx_sensor_233")
+	fc := w.stream(other.Id)
+	waitFor(t, "the sources to be bound", func() bool {
+		w.write(plane1, 100, 30)
+		return w.temperature(agg) == 30.0
+	})
+
+	w.write(fc, 200, 99)
+	w.expect("a stream outside the glob", agg, 30.0)
+	w.write(plane2, 110, 31)
+	w.expect("a reading within max_rate", agg, 30.0)
+	w.write(plane2, 130, 32)
+	w.expect("a reading after max_rate", agg, 32.0)
+
+	// A removed source is unbound. Once a source added after it is bound the
+	// removal has been applied too.
+	b.DeleteStream(plane2.Data.Id)
+	b.PutStream(sensorStream("plane_3"))
+	plane3 := w.stream("plane_3_sensor_rx_sensor_233")
+	rx := 150
+	waitFor(t, "the added source to be bound", func() bool {
+		rx += 30
+		w.write(plane3, rx, float64(rx))
+		return w.temperature(agg) == float64(rx)
+	})
+	w.write(plane2, rx+30, 40)
+	w.expect("a removed source", agg, float64(rx))
+
+	// Changing the reference rebinds the field.
+	b.PutStream(sensorAggregate("*.fc.state", 0))
+	waitFor(t, "the new reference to be bound", func() bool {
+		rx += 30
+		w.write(fc, rx, float64(rx))
+		return w.temperature(agg) == float64(rx)
+	})
+	w.write(plane1, rx+30, 50)
+	w.expect("a source of the old reference", agg, float64(rx))
+}
+
+func TestAggregateDispose(t *testing.T) {
+	b := memory.NewBackend()
+	b.PutStream(sensorStream("plane_1"))
+	b.PutStream(sensorAggregate("*.sensor_rx.sensor_233", 0))
+	h := historian.NewHistorian(b)
+	if err := h.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &sensorWriter{t: t, h: h}
+	agg := w.stream("sensors_sensor_233")
+	plane1 := w.stream("plane_1_sensor_rx_sensor_233")
+	waitFor(t, "the source to be bound", func() bool {
+		w.write(plane1, 100, 30)
+		return w.temperature(agg) == 30.0
+	})
+
+	// The aggregate thread stops its aggregators when disposed.
+	h.Dispose()
+	value := 40.0
+	waitFor(t, "the aggregators to stop", func() bool {
+		value++
+		w.write(plane1, 200+w.sec, value)
+		return w.temperature(agg) != value
+	})
+}
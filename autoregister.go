@@ -0,0 +1,102 @@
+package historian
+
+import (
+	"errors"
+	"path"
+
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+)
+
+// Policy for registering unknown streams when a device first pushes to them.
+type AutoRegisterPolicy struct {
+	// Patterns of device hostnames allowed to register streams, see
+	// path.Match.
+	Hostnames []string
+	// Definition new streams start from, for example with a default record
+	// rate. The names and ID are filled in.
+	Template *dbproto.Stream
+}
+
+// Whether a device may register streams.
+func (p *AutoRegisterPolicy) Allows(hostname string) bool {
+	for _, pattern := range p.Hostnames {
+		if matched, _ := path.Match(pattern, hostname); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the stream a device pushes to, registering it first if it isn't
+// known and matches a pattern in the device's template, or the AutoRegister
+// policy allows it. Streams matching a pattern are recorded on the device,
+// so they follow later changes to the template.
+func (h *Historian) GetPushStream(hostname, componentName, stateName string) (*Stream, error) {
+	id := StreamTableName(hostname, componentName, stateName)
+	str, err := h.GetStream(id)
+	if err == nil {
+		return str, nil
+	}
+
+	h.mtx.Lock()
+	// Failing to open a known stream is no reason to register it again.
+	_, known := h.KnownStreams[id]
+	var template *dbproto.Stream
+	if !known {
+		template = h.matchStreamPattern(hostname, componentName, stateName)
+	}
+	h.mtx.Unlock()
+	if known {
+		return nil, err
+	}
+	if componentName == "" || stateName == "" {
+		return nil, errors.New("Component and state must be specified.")
+	}
+	if template != nil {
+		return h.registerPatternStream(hostname, componentName, stateName)
+	}
+	if h.AutoRegister == nil || !h.AutoRegister.Allows(hostname) {
+		return nil, err
+	}
+	template = h.AutoRegister.Template
+
+	data := &dbproto.Stream{}
+	if template != nil {
+		data = proto.Clone(template).(*dbproto.Stream)
+	}
+	data.Id = id
+	data.DeviceHostname = hostname
+	data.ComponentName = componentName
+	data.StateName = stateName
+	data.Source = dbproto.Stream_PUSH
+
+	inserted, err := h.backend.InsertStream(data)
+	if err != nil {
+		return nil, err
+	}
+	if inserted {
+		glog.Infof("Registered stream %s on first push.", id)
+		// Don't wait for the change to come back from the backend.
+		h.handleChange(&StreamChange{NewValue: data})
+	}
+	return h.GetStream(id)
+}
+
+// Register a stream matching a pattern of the device's template on the
+// device record.
+func (h *Historian) registerPatternStream(hostname, componentName, stateName string) (*Stream, error) {
+	reg := &dbproto.DeviceStream{ComponentName: componentName, StateName: stateName}
+	added, err := h.backend.AddDeviceStream(hostname, reg)
+	if err != nil {
+		return nil, err
+	}
+	if added {
+		glog.Infof("Registered stream %s on device %s on first push.", StreamTableName(hostname, componentName, stateName), hostname)
+	}
+	// Don't wait for the change to come back from the backend. Another
+	// historian may have registered it first, the record is the same.
+	h.registerDeviceStream(hostname, reg)
+	return h.GetStream(StreamTableName(hostname, componentName, stateName))
+}
@@ -0,0 +1,118 @@
+package historian_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/memory"
+	"github.com/fuserobotics/historian/dbproto"
+)
+
+// Memory backend counting stream inserts, which can fail to open streams or
+// lose insert races.
+type registerBackend struct {
+	*memory.Backend
+
+	mtx        sync.Mutex
+	inserts    int
+	failOpen   bool
+	loseInsert bool
+}
+
+func (b *registerBackend) InsertStream(data *dbproto.Stream) (bool, error) {
+	b.mtx.Lock()
+	b.inserts++
+	lose := b.loseInsert
+	b.mtx.Unlock()
+	if lose {
+		return false, nil
+	}
+	return b.Backend.InsertStream(data)
+}
+
+func (b *registerBackend) OpenStream(data *dbproto.Stream) (historian.StreamBackend, error) {
+	b.mtx.Lock()
+	fail := b.failOpen
+	b.mtx.Unlock()
+	if fail {
+		return nil, errors.New("Open failed.")
+	}
+	return b.Backend.OpenStream(data)
+}
+
+func (b *registerBackend) insertCount() int {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.inserts
+}
+
+func newRegisterHistorian(t *testing.T, b *registerBackend) *historian.Historian {
+	h := historian.NewHistorian(b)
+	h.AutoRegister = &historian.AutoRegisterPolicy{
+		Hostnames: []string{"plane_*"},
+		Template:  &dbproto.Stream{LatenessWindow: 500},
+	}
+	if err := h.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestAutoRegister(t *testing.T) {
+	b := &registerBackend{Backend: memory.NewBackend()}
+	h := newRegisterHistorian(t, b)
+	defer h.Dispose()
+
+	if _, err := h.GetPushStream("plane_1", "fc", "state"); err != nil {
+		t.Fatal(err)
+	}
+	data := knownStream(h, "plane_1_fc_state")
+	if data == nil || data.LatenessWindow != 500 || data.Source != dbproto.Stream_PUSH {
+		t.Fatalf("expected the stream to be registered from the template, got %v", data)
+	}
+	if _, err := h.GetPushStream("car_1", "fc", "state"); err == nil {
+		t.Fatal("expected a hostname outside the policy to be rejected")
+	}
+}
+
+func TestAutoRegisterKnownStream(t *testing.T) {
+	b := &registerBackend{Backend: memory.NewBackend()}
+	b.PutStream(&dbproto.Stream{Id: "plane_1_fc_state", DeviceHostname: "plane_1", ComponentName: "fc", StateName: "state", LatenessWindow: 1000})
+	h := newRegisterHistorian(t, b)
+	defer h.Dispose()
+	waitFor(t, "the stream to load", func() bool { return knownStream(h, "plane_1_fc_state") != nil })
+
+	// A known stream that fails to open is not registered over.
+	b.mtx.Lock()
+	b.failOpen = true
+	b.mtx.Unlock()
+	if _, err := h.GetPushStream("plane_1", "fc", "state"); err == nil {
+		t.Fatal("expected the open error")
+	}
+	if inserts := b.insertCount(); inserts != 0 {
+		t.Fatalf("expected no insert, got %d", inserts)
+	}
+	if data := knownStream(h, "plane_1_fc_state"); data == nil || data.LatenessWindow != 1000 {
+		t.Fatalf("expected the definition to be kept, got %v", data)
+	}
+}
+
+func TestAutoRegisterLostInsert(t *testing.T) {
+	b := &registerBackend{Backend: memory.NewBackend(), loseInsert: true}
+	h := newRegisterHistorian(t, b)
+	defer h.Dispose()
+
+	// Another historian registered the stream first: its definition arrives
+	// through the feed, the template is not applied locally.
+	if _, err := h.GetPushStream("plane_1", "fc", "state"); err == nil {
+		t.Fatal("expected the stream to be unknown until the feed announces it")
+	}
+	if inserts := b.insertCount(); inserts != 1 {
+		t.Fatalf("expected one insert, got %d", inserts)
+	}
+	if data := knownStream(h, "plane_1_fc_state"); data != nil {
+		t.Fatalf("expected no local definition, got %v", data)
+	}
+}
@@ -0,0 +1,176 @@
+package historian
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+	"github.com/golang/protobuf/proto"
+)
+
+// Backend stores stream definitions and the entries of each stream.
+type Backend interface {
+	// Load all known stream definitions and open a feed of later changes.
+	WatchStreams() ([]*dbproto.Stream, StreamChangeFeed, error)
+	// Load all stream templates and device records and open a feed of later
+	// changes to either.
+	WatchDevices() ([]*dbproto.StreamTemplate, []*dbproto.Device, DeviceChangeFeed, error)
+	// Open the entry storage for a stream.
+	OpenStream(data *dbproto.Stream) (StreamBackend, error)
+	// Store a new stream definition, doing nothing if one with the same ID
+	// exists, as several historians may register a stream at once.
+	// Returns whether it was stored.
+	InsertStream(data *dbproto.Stream) (bool, error)
+	// Record a stream registered on a device, doing nothing if it is already
+	// recorded or the device has no record. Returns whether it was recorded.
+	AddDeviceStream(deviceId string, stream *dbproto.DeviceStream) (bool, error)
+	// Create the entry storage for a new stream, if it doesn't exist.
+	CreateStream(data *dbproto.Stream) error
+	// Dispose of the entry storage of a deleted stream.
+	// Must succeed if the storage is already gone.
+	DropStream(data *dbproto.Stream, policy TeardownPolicy) error
+	// Store entries in any number of streams in as few round trips as possible.
+	// Returns one error per write, nil for those stored.
+	SaveEntries(writes []*EntryWrite) []error
+	// Amend the entry at oldTimestamp in a stream opened from the same
+	// backend, storing the writer of the metadata with it.
+	AmendEntry(write *EntryWrite, oldTimestamp time.Time) error
+}
+
+// An entry to store in a stream opened from the same backend.
+type EntryWrite struct {
+	Storage StreamBackend
+	Entry   *stream.StreamEntry
+	// Stored alongside the entry if set.
+	Meta *EntryMeta
+}
+
+// Data stored with an entry, in the same transaction, but kept out of its
+// state.
+type EntryMeta struct {
+	// Sequence number of the pushed entry, see Stream.IngestSequenced.
+	// The stream's sequence is raised to it with a compare-and-set, and the
+	// write fails with ErrDuplicateSequence if it is not greater.
+	// 0 leaves the sequence alone.
+	Sequence uint64
+	// ID of the historian instance writing the entry, announced with it in
+	// entry feeds, see StreamEntryChange.
+	Writer string
+}
+
+// The writer of the entry, "" if m is nil.
+func (m *EntryMeta) GetWriter() string {
+	if m == nil {
+		return ""
+	}
+	return m.Writer
+}
+
+// Returned for a write whose sequence number was already committed.
+var ErrDuplicateSequence = errors.New("Sequence number already committed.")
+
+// A stream definition ready to store: a copy with the ID filled in from the
+// names if it was left empty. Backends store definitions through this so
+// they all agree on the ID.
+func StreamWithId(data *dbproto.Stream) (*dbproto.Stream, error) {
+	data = proto.Clone(data).(*dbproto.Stream)
+	if data.Id == "" {
+		data.Id = DbStreamTableName(data)
+	}
+	if data.Id == "" {
+		return nil, errors.New("Stream id must be specified.")
+	}
+	return data, nil
+}
+
+// What to do with the entries of a deleted stream.
+type TeardownPolicy int
+
+const (
+	// Leave the entries where they are.
+	TeardownKeep TeardownPolicy = iota
+	// Delete the entries.
+	TeardownDrop
+	// Move the entries aside, see ArchiveStreamTableName.
+	TeardownArchive
+)
+
+// Name of the archived entry table of a stream deleted at time t.
+func ArchiveStreamTableName(stream *dbproto.Stream, t time.Time) string {
+	return DbStreamTableName(stream) + "_archive_" + t.UTC().Format("20060102150405")
+}
+
+// StreamBackend stores the entries of a single stream.
+type StreamBackend interface {
+	// Retrieve the latest snapshot before timestamp. Return nil for no data.
+	GetSnapshotBefore(timestamp time.Time) (*stream.StreamEntry, error)
+	// Retrieve the earliest entry after timestamp. Return nil for no data.
+	GetEntryAfter(timestamp time.Time, filterType stream.StreamEntryType) (*stream.StreamEntry, error)
+	// Retrieve up to limit entries after timestamp and at or before until,
+	// in timestamp order.
+	GetEntriesAfter(timestamp, until time.Time, filterType stream.StreamEntryType, limit int) ([]*stream.StreamEntry, error)
+	// Retrieve up to limit entries before timestamp, newest first.
+	GetEntriesBefore(timestamp time.Time, limit int) ([]*stream.StreamEntry, error)
+	// Store a stream entry.
+	SaveEntry(entry *stream.StreamEntry) error
+	// Amend an old entry
+	AmendEntry(entry *stream.StreamEntry, oldTimestamp time.Time) error
+	// Delete the entries after one timestamp and before another.
+	// Entry feeds need not announce deletions.
+	DeleteEntries(after, before time.Time) error
+	// Open a feed of entries written to the stream from now on.
+	WatchEntries() (StreamEntryChangeFeed, error)
+	// Retrieve the highest sequence number committed with an entry, 0 if none.
+	GetSequence() (uint64, error)
+}
+
+// A change to a stream definition.
+type StreamChange struct {
+	NewValue *dbproto.Stream
+	OldValue *dbproto.Stream
+}
+
+// A change to an entry in a stream.
+type StreamEntryChange struct {
+	NewValue *stream.StreamEntry
+	OldValue *stream.StreamEntry
+	// ID of the historian instance that wrote NewValue, "" if unknown.
+	Writer string
+}
+
+// Feed of changes to stream definitions.
+type StreamChangeFeed interface {
+	// Channel of changes, closed when the feed ends.
+	Changes() <-chan *StreamChange
+	// Error that ended the feed, if any.
+	Err() error
+	Close() error
+}
+
+// A change to a stream template or a device record. Either the template or
+// the device values are set.
+type DeviceChange struct {
+	NewTemplate *dbproto.StreamTemplate
+	OldTemplate *dbproto.StreamTemplate
+	NewDevice   *dbproto.Device
+	OldDevice   *dbproto.Device
+}
+
+// Feed of changes to stream templates and device records.
+type DeviceChangeFeed interface {
+	// Channel of changes, closed when the feed ends.
+	Changes() <-chan *DeviceChange
+	// Error that ended the feed, if any.
+	Err() error
+	Close() error
+}
+
+// Feed of changes to entries in a stream.
+type StreamEntryChangeFeed interface {
+	// Channel of changes, closed when the feed ends.
+	Changes() <-chan *StreamEntryChange
+	// Error that ended the feed, if any.
+	Err() error
+	Close() error
+}
@@ -0,0 +1,527 @@
+// Package backendtest checks the behavior every historian.Backend shares.
+// Backend packages run it from their own tests.
+package backendtest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+)
+
+// Open a backend on empty storage, returning a func to clean up after it.
+type OpenFunc func(t *testing.T) (historian.Backend, func())
+
+// How long to wait for a feed to announce a change.
+const feedTimeout = 5 * time.Second
+
+// Run the shared tests, each against a backend of its own.
+func Run(t *testing.T, open OpenFunc) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, b historian.Backend)
+	}{
+		{"InsertStream", testInsertStream},
+		{"Entries", testEntries},
+		{"AmendEntry", testAmendEntry},
+		{"DeleteEntries", testDeleteEntries},
+		{"WatchEntries", testWatchEntries},
+		{"SaveEntries", testSaveEntries},
+		{"EntryWriter", testEntryWriter},
+		{"Sequence", testSequence},
+		{"DropStream", testDropStream},
+	}
+	for _, test := range tests {
+		run := test.run
+		t.Run(test.name, func(t *testing.T) {
+			b, cleanup := open(t)
+			defer cleanup()
+			run(t, b)
+		})
+	}
+}
+
+// A stream definition no earlier run has used, for backends on shared
+// storage.
+func testStream(state string) *dbproto.Stream {
+	return &dbproto.Stream{
+		DeviceHostname: fmt.Sprintf("test_%d", time.Now().UnixNano()),
+		ComponentName:  "fc",
+		StateName:      state,
+	}
+}
+
+var base = time.Unix(1000, 0).UTC()
+
+func at(sec int) time.Time {
+	return base.Add(time.Duration(sec) * time.Second)
+}
+
+func entry(sec int, entryType stream.StreamEntryType, altitude float64) *stream.StreamEntry {
+	return &stream.StreamEntry{
+		Type:      entryType,
+		Data:      stream.StateData{"altitude": altitude},
+		Timestamp: at(sec),
+	}
+}
+
+// Open the storage of a new stream holding entries.
+func openStream(t *testing.T, b historian.Backend, entries ...*stream.StreamEntry) (*dbproto.Stream, historian.StreamBackend) {
+	data := testStream("state")
+	if err := b.CreateStream(data); err != nil {
+		t.Fatal(err)
+	}
+	storage, err := b.OpenStream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := storage.SaveEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return data, storage
+}
+
+// Check a list of entries against the seconds of their timestamps.
+func expectTimes(t *testing.T, what string, entries []*stream.StreamEntry, secs ...int) {
+	if len(entries) != len(secs) {
+		t.Fatalf("%s: expected %d entries, got %v", what, len(secs), entries)
+	}
+	for i, entry := range entries {
+		if !entry.Timestamp.Equal(at(secs[i])) {
+			t.Fatalf("%s: expected the entry at %v, got %v", what, at(secs[i]), entry.Timestamp)
+		}
+	}
+}
+
+func expectTime(t *testing.T, what string, entry *stream.StreamEntry, sec int) {
+	if sec < 0 {
+		if entry != nil {
+			t.Fatalf("%s: expected no entry, got %v", what, entry.Timestamp)
+		}
+		return
+	}
+	if entry == nil {
+		t.Fatalf("%s: expected the entry at %v, got none", what, at(sec))
+	}
+	expectTimes(t, what, []*stream.StreamEntry{entry}, sec)
+}
+
+func testInsertStream(t *testing.T, b historian.Backend) {
+	data := testStream("state")
+	id := historian.DbStreamTableName(data)
+
+	_, feed, err := b.WatchStreams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Close()
+
+	inserted, err := b.InsertStream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inserted {
+		t.Fatal("expected the first insert to store the stream")
+	}
+	select {
+	case cha := <-feed.Changes():
+		if cha.NewValue == nil || cha.NewValue.Id != id {
+			t.Fatalf("expected the insert of %s, got %v", id, cha)
+		}
+	case <-time.After(feedTimeout):
+		t.Fatal("insert was not announced")
+	}
+
+	// A second insert leaves the first definition in place.
+	changed := testStream("state")
+	changed.DeviceHostname = data.DeviceHostname
+	changed.LatenessWindow = 1000
+	inserted, err = b.InsertStream(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted {
+		t.Fatal("expected the second insert to report nothing stored")
+	}
+	if _, err := b.InsertStream(&dbproto.Stream{}); err == nil {
+		t.Fatal("expected an error inserting a stream without id or names")
+	}
+
+	streams, feed2, err := b.WatchStreams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed2.Close()
+	var found *dbproto.Stream
+	for _, str := range streams {
+		if str.Id == id {
+			found = str
+		}
+	}
+	if found == nil {
+		t.Fatalf("expected %s to be listed, got %v", id, streams)
+	}
+	if found.LatenessWindow != 0 {
+		t.Fatal("expected the second insert to be ignored")
+	}
+}
+
+func testEntries(t *testing.T, b historian.Backend) {
+	_, storage := openStream(t, b,
+		entry(1, stream.StreamEntrySnapshot, 1),
+		entry(2, stream.StreamEntryMutation, 2),
+		entry(3, stream.StreamEntrySnapshot, 3),
+		entry(4, stream.StreamEntryMutation, 4),
+	)
+
+	snapshots := []struct {
+		before   int
+		expected int
+	}{
+		{1, -1},
+		{2, 1},
+		{3, 1},
+		{5, 3},
+	}
+	for _, test := range snapshots {
+		res, err := storage.GetSnapshotBefore(at(test.before))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectTime(t, fmt.Sprintf("snapshot before %d", test.before), res, test.expected)
+	}
+
+	after := []struct {
+		after      int
+		filterType stream.StreamEntryType
+		expected   int
+	}{
+		{0, stream.StreamEntryAny, 1},
+		{1, stream.StreamEntryAny, 2},
+		{1, stream.StreamEntrySnapshot, 3},
+		{3, stream.StreamEntrySnapshot, -1},
+		{2, stream.StreamEntryMutation, 4},
+		{4, stream.StreamEntryAny, -1},
+	}
+	for _, test := range after {
+		res, err := storage.GetEntryAfter(at(test.after), test.filterType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectTime(t, fmt.Sprintf("entry after %d of type %d", test.after, test.filterType), res, test.expected)
+	}
+
+	ranges := []struct {
+		after, until int
+		filterType   stream.StreamEntryType
+		limit        int
+		expected     []int
+	}{
+		{0, 4, stream.StreamEntryAny, 10, []int{1, 2, 3, 4}},
+		{1, 3, stream.StreamEntryAny, 10, []int{2, 3}},
+		{0, 4, stream.StreamEntryAny, 2, []int{1, 2}},
+		{0, 4, stream.StreamEntrySnapshot, 10, []int{1, 3}},
+		{4, 10, stream.StreamEntryAny, 10, nil},
+	}
+	for _, test := range ranges {
+		res, err := storage.GetEntriesAfter(at(test.after), at(test.until), test.filterType, test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectTimes(t, fmt.Sprintf("entries in (%d, %d]", test.after, test.until), res, test.expected...)
+	}
+
+	before := []struct {
+		before   int
+		limit    int
+		expected []int
+	}{
+		{5, 10, []int{4, 3, 2, 1}},
+		{4, 2, []int{3, 2}},
+		{1, 10, nil},
+	}
+	for _, test := range before {
+		res, err := storage.GetEntriesBefore(at(test.before), test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectTimes(t, fmt.Sprintf("entries before %d", test.before), res, test.expected...)
+	}
+
+	res, err := storage.GetEntryAfter(at(1), stream.StreamEntryAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Type != stream.StreamEntryMutation || res.Data["altitude"] != 2.0 {
+		t.Fatalf("expected a mutation to altitude 2, got %v", res)
+	}
+}
+
+func testAmendEntry(t *testing.T, b historian.Backend) {
+	_, storage := openStream(t, b,
+		entry(1, stream.StreamEntrySnapshot, 1),
+		entry(2, stream.StreamEntryMutation, 2),
+	)
+	if err := storage.AmendEntry(entry(2, stream.StreamEntrySnapshot, 20), at(2)); err != nil {
+		t.Fatal(err)
+	}
+	res, err := storage.GetEntryAfter(at(1), stream.StreamEntryAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil || res.Type != stream.StreamEntrySnapshot || res.Data["altitude"] != 20.0 {
+		t.Fatalf("expected the amended snapshot, got %v", res)
+	}
+	if err := storage.AmendEntry(entry(5, stream.StreamEntrySnapshot, 5), at(5)); err == nil {
+		t.Fatal("expected an error amending a missing entry")
+	}
+}
+
+func testDeleteEntries(t *testing.T, b historian.Backend) {
+	_, storage := openStream(t, b,
+		entry(1, stream.StreamEntrySnapshot, 1),
+		entry(2, stream.StreamEntryMutation, 2),
+		entry(3, stream.StreamEntryMutation, 3),
+		entry(4, stream.StreamEntrySnapshot, 4),
+	)
+	// Both ends are excluded.
+	if err := storage.DeleteEntries(at(1), at(4)); err != nil {
+		t.Fatal(err)
+	}
+	res, err := storage.GetEntriesAfter(at(0), at(10), stream.StreamEntryAny, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTimes(t, "entries left", res, 1, 4)
+}
+
+func testWatchEntries(t *testing.T, b historian.Backend) {
+	_, storage := openStream(t, b)
+	feed, err := storage.WatchEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Close()
+
+	for sec := 1; sec <= 3; sec++ {
+		if err := storage.SaveEntry(entry(sec, stream.StreamEntrySnapshot, float64(sec))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for sec := 1; sec <= 3; sec++ {
+		select {
+		case cha := <-feed.Changes():
+			if cha.NewValue == nil {
+				t.Fatalf("expected the entry at %v, got %v", at(sec), cha)
+			}
+			expectTime(t, "announced entry", cha.NewValue, sec)
+		case <-time.After(feedTimeout):
+			t.Fatalf("entry at %v was not announced", at(sec))
+		}
+	}
+
+	if err := feed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range feed.Changes() {
+	}
+}
+
+func testSaveEntries(t *testing.T, b historian.Backend) {
+	_, first := openStream(t, b)
+	_, second := openStream(t, b)
+
+	writes := []*historian.EntryWrite{
+		{Storage: first, Entry: entry(1, stream.StreamEntrySnapshot, 1)},
+		{Storage: second, Entry: entry(1, stream.StreamEntrySnapshot, 10)},
+		{Storage: first, Entry: entry(2, stream.StreamEntryMutation, 2)},
+	}
+	for i, err := range b.SaveEntries(writes) {
+		if err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+
+	res, err := first.GetEntriesAfter(at(0), at(10), stream.StreamEntryAny, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTimes(t, "first stream", res, 1, 2)
+	res, err = second.GetEntriesAfter(at(0), at(10), stream.StreamEntryAny, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTimes(t, "second stream", res, 1)
+	if res[0].Data["altitude"] != 10.0 {
+		t.Fatalf("expected altitude 10, got %v", res[0].Data)
+	}
+}
+
+// Wait for the feed to announce the entry at sec, written by writer.
+func expectWriter(t *testing.T, what string, feed historian.StreamEntryChangeFeed, sec int, writer string) {
+	select {
+	case cha := <-feed.Changes():
+		if cha.NewValue == nil {
+			t.Fatalf("%s: expected the entry at %v, got %v", what, at(sec), cha)
+		}
+		expectTime(t, what, cha.NewValue, sec)
+		if cha.Writer != writer {
+			t.Fatalf("%s: expected writer %q, got %q", what, writer, cha.Writer)
+		}
+	case <-time.After(feedTimeout):
+		t.Fatalf("%s: entry at %v was not announced", what, at(sec))
+	}
+}
+
+func testEntryWriter(t *testing.T, b historian.Backend) {
+	_, storage := openStream(t, b)
+	feed, err := storage.WatchEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Close()
+
+	meta := &historian.EntryMeta{Writer: "instance-1"}
+	writes := []*historian.EntryWrite{
+		{Storage: storage, Entry: entry(1, stream.StreamEntrySnapshot, 1), Meta: meta},
+		{Storage: storage, Entry: entry(2, stream.StreamEntryMutation, 2)},
+	}
+	for i, err := range b.SaveEntries(writes) {
+		if err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	expectWriter(t, "saved entry", feed, 1, "instance-1")
+	expectWriter(t, "entry without meta", feed, 2, "")
+
+	amended := &historian.EntryWrite{
+		Storage: storage,
+		Entry:   entry(2, stream.StreamEntrySnapshot, 20),
+		Meta:    &historian.EntryMeta{Writer: "instance-2"},
+	}
+	if err := b.AmendEntry(amended, at(2)); err != nil {
+		t.Fatal(err)
+	}
+	expectWriter(t, "amended entry", feed, 2, "instance-2")
+
+	// The writer is kept out of the state.
+	res, err := storage.GetEntriesAfter(at(0), at(10), stream.StreamEntryAny, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTimes(t, "entries", res, 1, 2)
+	for _, entry := range res {
+		if len(entry.Data) != 1 {
+			t.Fatalf("expected only the altitude, got %v", entry.Data)
+		}
+	}
+}
+
+func expectSequence(t *testing.T, what string, storage historian.StreamBackend, expected uint64) {
+	seq, err := storage.GetSequence()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	if seq != expected {
+		t.Fatalf("%s: expected sequence %d, got %d", what, expected, seq)
+	}
+}
+
+func testSequence(t *testing.T, b historian.Backend) {
+	data, storage := openStream(t, b)
+	expectSequence(t, "new stream", storage, 0)
+
+	tests := []struct {
+		name      string
+		sec       int
+		seq       uint64
+		err       bool
+		duplicate bool
+		expected  uint64
+	}{
+		{"first", 1, 5, false, false, 5},
+		{"same sequence", 2, 5, true, true, 5},
+		{"lower sequence", 3, 3, true, true, 5},
+		{"taken timestamp", 1, 7, true, false, 5},
+		{"unsequenced", 4, 0, false, false, 5},
+		{"beyond float precision", 5, 1<<63 + 1, false, false, 1<<63 + 1},
+	}
+	for _, test := range tests {
+		err := b.SaveEntries([]*historian.EntryWrite{{
+			Storage: storage,
+			Entry:   entry(test.sec, stream.StreamEntryMutation, 1),
+			Meta:    &historian.EntryMeta{Sequence: test.seq},
+		}})[0]
+		if (err != nil) != test.err {
+			t.Fatalf("%s: expected error %v, got %v", test.name, test.err, err)
+		}
+		if test.duplicate && err != historian.ErrDuplicateSequence {
+			t.Fatalf("%s: expected %v, got %v", test.name, historian.ErrDuplicateSequence, err)
+		}
+		expectSequence(t, test.name, storage, test.expected)
+	}
+
+	// Rejected entries are not stored.
+	res, err := storage.GetEntriesAfter(at(0), at(10), stream.StreamEntryAny, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTimes(t, "stored entries", res, 1, 4, 5)
+
+	if err := b.DropStream(data, historian.TeardownDrop); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CreateStream(data); err != nil {
+		t.Fatal(err)
+	}
+	storage, err = b.OpenStream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSequence(t, "recreated stream", storage, 0)
+	b.DropStream(data, historian.TeardownDrop)
+}
+
+func testDropStream(t *testing.T, b historian.Backend) {
+	data, _ := openStream(t, b, entry(1, stream.StreamEntrySnapshot, 1))
+
+	if err := b.DropStream(data, historian.TeardownKeep); err != nil {
+		t.Fatal(err)
+	}
+	storage, err := b.OpenStream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := storage.GetEntryAfter(at(0), stream.StreamEntryAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTime(t, "entry kept", res, 1)
+
+	if err := b.DropStream(data, historian.TeardownDrop); err != nil {
+		t.Fatal(err)
+	}
+	// Dropping storage that is gone succeeds.
+	if err := b.DropStream(data, historian.TeardownDrop); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.CreateStream(data); err != nil {
+		t.Fatal(err)
+	}
+	storage, err = b.OpenStream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = storage.GetEntryAfter(at(0), stream.StreamEntryAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTime(t, "entry after drop", res, -1)
+	b.DropStream(data, historian.TeardownDrop)
+}
@@ -0,0 +1,283 @@
+package bolt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/feed"
+	"github.com/fuserobotics/historian/dbproto"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	streamsBucket   = []byte("streams")
+	entriesBucket   = []byte("entries")
+	templatesBucket = []byte("templates")
+	devicesBucket   = []byte("devices")
+	sequencesBucket = []byte("sequences")
+)
+
+// Embedded single-node storage backend on a bbolt database file.
+// Stream definitions live in the "streams" bucket, and each stream gets a
+// bucket under "entries" keyed by timestamp. The committed sequence of each
+// stream lives in the "sequences" bucket, keyed by the same name. Stream
+// templates and device records live in the "templates" and "devices" buckets.
+type Backend struct {
+	db *bolt.DB
+
+	// Serializes stream definition writes with WatchStreams, and template
+	// and device writes with WatchDevices.
+	mtx       sync.Mutex
+	streamHub feed.Hub
+	deviceHub feed.Hub
+
+	tablesMtx sync.Mutex
+	tables    map[string]*table
+}
+
+// Wrap an open database, creating the top level buckets if necessary.
+func NewBackend(db *bolt.DB) (*Backend, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{streamsBucket, entriesBucket, templatesBucket, devicesBucket, sequencesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Backend{
+		db:     db,
+		tables: make(map[string]*table),
+	}, nil
+}
+
+// Insert or replace a stream definition, notifying watchers.
+func (b *Backend) PutStream(data *dbproto.Stream) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	data, err := historian.StreamWithId(data)
+	if err != nil {
+		return err
+	}
+	val, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	change := &historian.StreamChange{NewValue: data}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(streamsBucket)
+		if old := bkt.Get([]byte(data.Id)); old != nil {
+			change.OldValue = &dbproto.Stream{}
+			if err := json.Unmarshal(old, change.OldValue); err != nil {
+				return err
+			}
+		}
+		return bkt.Put([]byte(data.Id), val)
+	})
+	if err != nil {
+		return err
+	}
+	b.streamHub.Publish(change)
+	return nil
+}
+
+// Store a stream definition unless one with the same ID exists.
+func (b *Backend) InsertStream(data *dbproto.Stream) (bool, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	data, err := historian.StreamWithId(data)
+	if err != nil {
+		return false, err
+	}
+	val, err := json.Marshal(data)
+	if err != nil {
+		return false, err
+	}
+
+	inserted := false
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(streamsBucket)
+		if bkt.Get([]byte(data.Id)) != nil {
+			return nil
+		}
+		inserted = true
+		return bkt.Put([]byte(data.Id), val)
+	})
+	if err != nil || !inserted {
+		return false, err
+	}
+	b.streamHub.Publish(&historian.StreamChange{NewValue: data})
+	return true, nil
+}
+
+// Delete a stream definition, notifying watchers.
+func (b *Backend) DeleteStream(id string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	change := &historian.StreamChange{}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(streamsBucket)
+		old := bkt.Get([]byte(id))
+		if old == nil {
+			return nil
+		}
+		change.OldValue = &dbproto.Stream{}
+		if err := json.Unmarshal(old, change.OldValue); err != nil {
+			return err
+		}
+		return bkt.Delete([]byte(id))
+	})
+	if err != nil || change.OldValue == nil {
+		return err
+	}
+	b.streamHub.Publish(change)
+	return nil
+}
+
+// Loads all streams, ordered by ID, and a feed of later changes.
+func (b *Backend) WatchStreams() ([]*dbproto.Stream, historian.StreamChangeFeed, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	var streams []*dbproto.Stream
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(streamsBucket).ForEach(func(k, v []byte) error {
+			str := &dbproto.Stream{}
+			if err := json.Unmarshal(v, str); err != nil {
+				return err
+			}
+			streams = append(streams, str)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return streams, b.streamHub.WatchStreams(), nil
+}
+
+// Open the entry bucket for a stream, creating it if necessary.
+func (b *Backend) OpenStream(data *dbproto.Stream) (historian.StreamBackend, error) {
+	name := historian.DbStreamTableName(data)
+
+	b.tablesMtx.Lock()
+	defer b.tablesMtx.Unlock()
+
+	if t, ok := b.tables[name]; ok {
+		return t, nil
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket(entriesBucket).CreateBucketIfNotExists([]byte(name))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	t := &table{b: b, name: []byte(name)}
+	b.tables[name] = t
+	return t, nil
+}
+
+// Store entries in any number of streams in a single transaction.
+func (b *Backend) SaveEntries(writes []*historian.EntryWrite) []error {
+	errs := make([]error, len(writes))
+	tables := make([]*table, len(writes))
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		for i, write := range writes {
+			t, ok := write.Storage.(*table)
+			if !ok || t.b != b {
+				errs[i] = errors.New("Stream storage not opened from this backend.")
+				continue
+			}
+			tables[i] = t
+			errs[i] = t.put(tx, write.Entry, write.Meta)
+		}
+		return nil
+	})
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	for i, write := range writes {
+		if errs[i] == nil {
+			tables[i].hub.Publish(&historian.StreamEntryChange{NewValue: write.Entry, Writer: write.Meta.GetWriter()})
+		}
+	}
+	return errs
+}
+
+// Amend an entry, announcing the writer with it.
+func (b *Backend) AmendEntry(write *historian.EntryWrite, oldTimestamp time.Time) error {
+	t, ok := write.Storage.(*table)
+	if !ok || t.b != b {
+		return errors.New("Stream storage not opened from this backend.")
+	}
+	return t.amendEntry(write.Entry, oldTimestamp, write.Meta)
+}
+
+// Create the entry bucket for a stream.
+func (b *Backend) CreateStream(data *dbproto.Stream) error {
+	_, err := b.OpenStream(data)
+	return err
+}
+
+// Delete the entry bucket of a deleted stream, or move it out of the way.
+func (b *Backend) DropStream(data *dbproto.Stream, policy historian.TeardownPolicy) error {
+	if policy == historian.TeardownKeep {
+		return nil
+	}
+
+	name := historian.DbStreamTableName(data)
+
+	b.tablesMtx.Lock()
+	defer b.tablesMtx.Unlock()
+	delete(b.tables, name)
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(sequencesBucket).Delete([]byte(name)); err != nil {
+			return err
+		}
+		entries := tx.Bucket(entriesBucket)
+		bkt := entries.Bucket([]byte(name))
+		if bkt == nil {
+			return nil
+		}
+		if policy == historian.TeardownArchive {
+			archive, err := createArchiveBucket(entries, historian.ArchiveStreamTableName(data, time.Now()))
+			if err != nil {
+				return err
+			}
+			err = bkt.ForEach(func(k, v []byte) error {
+				return archive.Put(k, v)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return entries.DeleteBucket([]byte(name))
+	})
+}
+
+// Create the bucket of an archive, suffixing the name if an archive of the
+// same stream took it within the same second.
+func createArchiveBucket(entries *bolt.Bucket, name string) (*bolt.Bucket, error) {
+	key := name
+	for i := 2; entries.Bucket([]byte(key)) != nil; i++ {
+		key = fmt.Sprintf("%s_%d", name, i)
+	}
+	return entries.CreateBucket([]byte(key))
+}
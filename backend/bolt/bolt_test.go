@@ -0,0 +1,181 @@
+package bolt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/backendtest"
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Open a backend on a fresh database, removed by the returned func.
+func openTestBackend(t *testing.T) (*Backend, func()) {
+	dir, err := ioutil.TempDir("", "historian-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "historian.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+	b, err := NewBackend(db)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return b, cleanup
+}
+
+func TestBackend(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) (historian.Backend, func()) {
+		return openTestBackend(t)
+	})
+}
+
+func TestPutStreamDefaultsId(t *testing.T) {
+	b, cleanup := openTestBackend(t)
+	defer cleanup()
+	if err := b.PutStream(&dbproto.Stream{DeviceHostname: "plane_1", ComponentName: "fc", StateName: "state"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.PutStream(&dbproto.Stream{}); err == nil {
+		t.Fatal("expected an error for a stream without id or names")
+	}
+
+	streams, feed, err := b.WatchStreams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.Close()
+	if len(streams) != 1 || streams[0].Id != "plane_1_fc_state" {
+		t.Fatalf("expected the id to default to the table name, got %v", streams)
+	}
+}
+
+func TestDroppedStream(t *testing.T) {
+	b, cleanup := openTestBackend(t)
+	defer cleanup()
+	data := &dbproto.Stream{Id: "plane_1_fc_state", DeviceHostname: "plane_1", ComponentName: "fc", StateName: "state"}
+	storage, err := b.OpenStream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &stream.StreamEntry{
+		Type:      stream.StreamEntrySnapshot,
+		Data:      stream.StateData{"altitude": 10.0},
+		Timestamp: time.Unix(100, 0),
+	}
+	if err := storage.SaveEntry(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DropStream(data, historian.TeardownDrop); err != nil {
+		t.Fatal(err)
+	}
+
+	// Storage opened before the drop fails instead of panicking.
+	if _, err := storage.GetEntryAfter(time.Time{}, stream.StreamEntryAny); err == nil {
+		t.Fatal("expected an error reading a dropped stream")
+	}
+	if err := storage.SaveEntry(entry); err == nil {
+		t.Fatal("expected an error writing a dropped stream")
+	}
+	if err := storage.DeleteEntries(time.Time{}, time.Unix(200, 0)); err == nil {
+		t.Fatal("expected an error deleting from a dropped stream")
+	}
+}
+
+func TestArchiveTwice(t *testing.T) {
+	b, cleanup := openTestBackend(t)
+	defer cleanup()
+	data := &dbproto.Stream{Id: "plane_1_fc_state", DeviceHostname: "plane_1", ComponentName: "fc", StateName: "state"}
+
+	// Archived twice within the same second.
+	for i := 0; i < 2; i++ {
+		storage, err := b.OpenStream(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := &stream.StreamEntry{
+			Type:      stream.StreamEntrySnapshot,
+			Data:      stream.StateData{"altitude": float64(i)},
+			Timestamp: time.Unix(100, 0),
+		}
+		if err := storage.SaveEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.DropStream(data, historian.TeardownArchive); err != nil {
+			t.Fatalf("archive %d: %v", i, err)
+		}
+	}
+
+	var archives []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(k, v []byte) error {
+			if strings.HasPrefix(string(k), "plane_1_fc_state_archive_") {
+				archives = append(archives, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 2 {
+		t.Fatalf("expected two archives, got %v", archives)
+	}
+}
+
+func TestAddDeviceStream(t *testing.T) {
+	b, cleanup := openTestBackend(t)
+	defer cleanup()
+	reg := &dbproto.DeviceStream{ComponentName: "sensor_rx", StateName: "sensor_233"}
+	if added, err := b.AddDeviceStream("plane_1", reg); err != nil || added {
+		t.Fatalf("expected nothing recorded without a device, got %v, %v", added, err)
+	}
+	if err := b.PutDevice(&dbproto.Device{Id: "plane_1", Template: "fixed-wing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, feed, err := b.WatchDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Close()
+	for i, expected := range []bool{true, false} {
+		added, err := b.AddDeviceStream("plane_1", reg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != expected {
+			t.Fatalf("add %d: expected %v, got %v", i, expected, added)
+		}
+	}
+	select {
+	case cha := <-feed.Changes():
+		if cha.NewDevice == nil || len(cha.NewDevice.Registered) != 1 || cha.OldDevice == nil || len(cha.OldDevice.Registered) != 0 {
+			t.Fatalf("expected the registration to be announced, got %v", cha)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("registration was not announced")
+	}
+
+	_, devices, feed2, err := b.WatchDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed2.Close()
+	if len(devices) != 1 || !historian.HasDeviceStream(devices[0], reg) || devices[0].Template != "fixed-wing" {
+		t.Fatalf("expected the registration to be stored, got %v", devices)
+	}
+}
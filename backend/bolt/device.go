@@ -0,0 +1,189 @@
+package bolt
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/dbproto"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store a JSON record under id, decoding the one it replaces into old.
+// Returns false if there was none.
+func (b *Backend) putRecord(bucket []byte, id string, val, old interface{}) (bool, error) {
+	if id == "" {
+		return false, errors.New("Id must be specified.")
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return false, err
+	}
+	replaced := false
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(bucket)
+		if prev := bkt.Get([]byte(id)); prev != nil {
+			replaced = true
+			if err := json.Unmarshal(prev, old); err != nil {
+				return err
+			}
+		}
+		return bkt.Put([]byte(id), data)
+	})
+	return replaced, err
+}
+
+// Delete the JSON record under id, decoding it into old.
+// Returns false if there was none.
+func (b *Backend) deleteRecord(bucket []byte, id string, old interface{}) (bool, error) {
+	deleted := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(bucket)
+		prev := bkt.Get([]byte(id))
+		if prev == nil {
+			return nil
+		}
+		deleted = true
+		if err := json.Unmarshal(prev, old); err != nil {
+			return err
+		}
+		return bkt.Delete([]byte(id))
+	})
+	return deleted, err
+}
+
+// Insert or replace a stream template, notifying watchers.
+func (b *Backend) PutTemplate(data *dbproto.StreamTemplate) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	change := &historian.DeviceChange{NewTemplate: data}
+	old := &dbproto.StreamTemplate{}
+	replaced, err := b.putRecord(templatesBucket, data.Id, data, old)
+	if err != nil {
+		return err
+	}
+	if replaced {
+		change.OldTemplate = old
+	}
+	b.deviceHub.Publish(change)
+	return nil
+}
+
+// Delete a stream template, notifying watchers.
+func (b *Backend) DeleteTemplate(id string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	old := &dbproto.StreamTemplate{}
+	deleted, err := b.deleteRecord(templatesBucket, id, old)
+	if err != nil || !deleted {
+		return err
+	}
+	b.deviceHub.Publish(&historian.DeviceChange{OldTemplate: old})
+	return nil
+}
+
+// Insert or replace a device record, notifying watchers.
+func (b *Backend) PutDevice(data *dbproto.Device) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	change := &historian.DeviceChange{NewDevice: data}
+	old := &dbproto.Device{}
+	replaced, err := b.putRecord(devicesBucket, data.Id, data, old)
+	if err != nil {
+		return err
+	}
+	if replaced {
+		change.OldDevice = old
+	}
+	b.deviceHub.Publish(change)
+	return nil
+}
+
+// Record a stream registered on a device, notifying watchers.
+func (b *Backend) AddDeviceStream(deviceId string, stream *dbproto.DeviceStream) (bool, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	old := &dbproto.Device{}
+	data := &dbproto.Device{}
+	added := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(devicesBucket)
+		prev := bkt.Get([]byte(deviceId))
+		if prev == nil {
+			return nil
+		}
+		if err := json.Unmarshal(prev, old); err != nil {
+			return err
+		}
+		if historian.HasDeviceStream(old, stream) {
+			return nil
+		}
+		if err := json.Unmarshal(prev, data); err != nil {
+			return err
+		}
+		data.Registered = append(data.Registered, stream)
+		val, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		added = true
+		return bkt.Put([]byte(deviceId), val)
+	})
+	if err != nil || !added {
+		return false, err
+	}
+	b.deviceHub.Publish(&historian.DeviceChange{NewDevice: data, OldDevice: old})
+	return true, nil
+}
+
+// Delete a device record, notifying watchers.
+func (b *Backend) DeleteDevice(id string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	old := &dbproto.Device{}
+	deleted, err := b.deleteRecord(devicesBucket, id, old)
+	if err != nil || !deleted {
+		return err
+	}
+	b.deviceHub.Publish(&historian.DeviceChange{OldDevice: old})
+	return nil
+}
+
+// Loads all templates and devices, and a feed of later changes.
+func (b *Backend) WatchDevices() ([]*dbproto.StreamTemplate, []*dbproto.Device, historian.DeviceChangeFeed, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	var templates []*dbproto.StreamTemplate
+	var devices []*dbproto.Device
+	err := b.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(templatesBucket).ForEach(func(k, v []byte) error {
+			tmpl := &dbproto.StreamTemplate{}
+			if err := json.Unmarshal(v, tmpl); err != nil {
+				return err
+			}
+			templates = append(templates, tmpl)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(devicesBucket).ForEach(func(k, v []byte) error {
+			dev := &dbproto.Device{}
+			if err := json.Unmarshal(v, dev); err != nil {
+				return err
+			}
+			devices = append(devices, dev)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return templates, devices, b.deviceHub.WatchDevices(), nil
+}
@@ -0,0 +1,303 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/feed"
+	"github.com/fuserobotics/statestream"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Entries of a single stream.
+// Keys are timestamps encoded so byte order is time order.
+// Values are the entry type followed by the JSON encoded entry.
+type table struct {
+	b    *Backend
+	name []byte
+	hub  feed.Hub
+}
+
+// Encode a timestamp as an order-preserving key.
+// Times outside the range of UnixNano are clamped.
+func timestampKey(timestamp time.Time) []byte {
+	var nanos int64
+	switch {
+	case timestamp.Before(time.Unix(0, math.MinInt64)):
+		nanos = math.MinInt64
+	case timestamp.After(time.Unix(0, math.MaxInt64)):
+		nanos = math.MaxInt64
+	default:
+		nanos = timestamp.UnixNano()
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(nanos)^(1<<63))
+	return key
+}
+
+func encodeEntry(entry *stream.StreamEntry) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(entry.Type)}, data...), nil
+}
+
+func decodeEntry(val []byte) (*stream.StreamEntry, error) {
+	if len(val) < 1 {
+		return nil, errors.New("Invalid stored entry.")
+	}
+	entry := &stream.StreamEntry{}
+	if err := json.Unmarshal(val[1:], entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func entryTypeMatches(val []byte, filterType stream.StreamEntryType) bool {
+	return filterType == stream.StreamEntryAny || (len(val) > 0 && stream.StreamEntryType(val[0]) == filterType)
+}
+
+// The entry bucket of the table, an error if the stream was dropped.
+func (t *table) bucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	bkt := tx.Bucket(entriesBucket).Bucket(t.name)
+	if bkt == nil {
+		return nil, fmt.Errorf("Entries of stream %s have been dropped.", t.name)
+	}
+	return bkt, nil
+}
+
+// Retrieve the latest snapshot before timestamp. Return nil for no data.
+func (t *table) GetSnapshotBefore(timestamp time.Time) (entry *stream.StreamEntry, err error) {
+	err = t.b.db.View(func(tx *bolt.Tx) error {
+		bkt, err := t.bucket(tx)
+		if err != nil {
+			return err
+		}
+		c := bkt.Cursor()
+		k, v := c.Seek(timestampKey(timestamp))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil; k, v = c.Prev() {
+			if entryTypeMatches(v, stream.StreamEntrySnapshot) {
+				entry, err = decodeEntry(v)
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// Retrieve the earliest entry after timestamp. Return nil for no data.
+func (t *table) GetEntryAfter(timestamp time.Time, filterType stream.StreamEntryType) (entry *stream.StreamEntry, err error) {
+	err = t.b.db.View(func(tx *bolt.Tx) error {
+		bkt, err := t.bucket(tx)
+		if err != nil {
+			return err
+		}
+		c := bkt.Cursor()
+		key := timestampKey(timestamp)
+		k, v := c.Seek(key)
+		if k != nil && bytes.Equal(k, key) {
+			k, v = c.Next()
+		}
+		for ; k != nil; k, v = c.Next() {
+			if entryTypeMatches(v, filterType) {
+				entry, err = decodeEntry(v)
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// Retrieve up to limit entries in (timestamp, until], in timestamp order.
+func (t *table) GetEntriesAfter(timestamp, until time.Time, filterType stream.StreamEntryType, limit int) (entries []*stream.StreamEntry, err error) {
+	err = t.b.db.View(func(tx *bolt.Tx) error {
+		bkt, err := t.bucket(tx)
+		if err != nil {
+			return err
+		}
+		c := bkt.Cursor()
+		key := timestampKey(timestamp)
+		untilKey := timestampKey(until)
+		k, v := c.Seek(key)
+		if k != nil && bytes.Equal(k, key) {
+			k, v = c.Next()
+		}
+		for ; k != nil && bytes.Compare(k, untilKey) <= 0 && len(entries) < limit; k, v = c.Next() {
+			if !entryTypeMatches(v, filterType) {
+				continue
+			}
+			entry, err := decodeEntry(v)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return
+}
+
+// Retrieve up to limit entries before timestamp, newest first.
+func (t *table) GetEntriesBefore(timestamp time.Time, limit int) (entries []*stream.StreamEntry, err error) {
+	err = t.b.db.View(func(tx *bolt.Tx) error {
+		bkt, err := t.bucket(tx)
+		if err != nil {
+			return err
+		}
+		c := bkt.Cursor()
+		k, v := c.Seek(timestampKey(timestamp))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil && len(entries) < limit; k, v = c.Prev() {
+			entry, err := decodeEntry(v)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return
+}
+
+// Store a stream entry. Timestamps are unique, like a primary key.
+func (t *table) SaveEntry(entry *stream.StreamEntry) error {
+	return t.b.SaveEntries([]*historian.EntryWrite{{Storage: t, Entry: entry}})[0]
+}
+
+// Put an entry in the table within tx, raising the sequence with it if meta
+// is set. Nothing is written if an error is returned.
+func (t *table) put(tx *bolt.Tx, entry *stream.StreamEntry, meta *historian.EntryMeta) error {
+	val, err := encodeEntry(entry)
+	if err != nil {
+		return err
+	}
+	bkt, err := t.bucket(tx)
+	if err != nil {
+		return err
+	}
+	key := timestampKey(entry.Timestamp)
+	if bkt.Get(key) != nil {
+		return errors.New("Duplicate entry timestamp.")
+	}
+	var seq []byte
+	if meta != nil && meta.Sequence != 0 {
+		if meta.Sequence <= t.sequence(tx) {
+			return historian.ErrDuplicateSequence
+		}
+		seq = make([]byte, 8)
+		binary.BigEndian.PutUint64(seq, meta.Sequence)
+	}
+	if err := bkt.Put(key, val); err != nil {
+		return err
+	}
+	if seq == nil {
+		return nil
+	}
+	return tx.Bucket(sequencesBucket).Put(t.name, seq)
+}
+
+// The committed sequence of the table within tx, 0 if none.
+func (t *table) sequence(tx *bolt.Tx) uint64 {
+	val := tx.Bucket(sequencesBucket).Get(t.name)
+	if len(val) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(val)
+}
+
+// Amend an old entry
+func (t *table) AmendEntry(entry *stream.StreamEntry, oldTimestamp time.Time) error {
+	return t.amendEntry(entry, oldTimestamp, nil)
+}
+
+// Amend an old entry, announcing the writer if meta is set.
+func (t *table) amendEntry(entry *stream.StreamEntry, oldTimestamp time.Time, meta *historian.EntryMeta) error {
+	if !entry.Timestamp.Equal(oldTimestamp) {
+		return errors.New("Cannot change the timestamp of an entry.")
+	}
+	val, err := encodeEntry(entry)
+	if err != nil {
+		return err
+	}
+	key := timestampKey(oldTimestamp)
+	var old *stream.StreamEntry
+	err = t.b.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := t.bucket(tx)
+		if err != nil {
+			return err
+		}
+		oldVal := bkt.Get(key)
+		if oldVal == nil {
+			return errors.New("Entry to amend not found.")
+		}
+		if old, err = decodeEntry(oldVal); err != nil {
+			return err
+		}
+		return bkt.Put(key, val)
+	})
+	if err != nil {
+		return err
+	}
+	t.hub.Publish(&historian.StreamEntryChange{NewValue: entry, OldValue: old, Writer: meta.GetWriter()})
+	return nil
+}
+
+// Delete the entries in (after, before).
+func (t *table) DeleteEntries(after, before time.Time) error {
+	return t.b.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := t.bucket(tx)
+		if err != nil {
+			return err
+		}
+		c := bkt.Cursor()
+		key := timestampKey(after)
+		beforeKey := timestampKey(before)
+
+		var keys [][]byte
+		k, _ := c.Seek(key)
+		if k != nil && bytes.Equal(k, key) {
+			k, _ = c.Next()
+		}
+		for ; k != nil && bytes.Compare(k, beforeKey) < 0; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := bkt.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Watch for entries written through this backend from now on.
+func (t *table) WatchEntries() (historian.StreamEntryChangeFeed, error) {
+	return t.hub.WatchEntries(), nil
+}
+
+// Retrieve the highest sequence number committed with an entry, 0 if none.
+func (t *table) GetSequence() (seq uint64, err error) {
+	err = t.b.db.View(func(tx *bolt.Tx) error {
+		seq = t.sequence(tx)
+		return nil
+	})
+	return
+}
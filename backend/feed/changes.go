@@ -0,0 +1,77 @@
+package feed
+
+import (
+	"github.com/fuserobotics/historian"
+)
+
+// Feed of stream definition changes.
+type StreamChangeFeed struct {
+	*Feed
+	changes chan *historian.StreamChange
+}
+
+func (f *StreamChangeFeed) Changes() <-chan *historian.StreamChange {
+	return f.changes
+}
+
+// Open a feed of the stream definition changes published from now on.
+func (h *Hub) WatchStreams() *StreamChangeFeed {
+	f := &StreamChangeFeed{changes: make(chan *historian.StreamChange)}
+	f.Feed = h.watch(func(change interface{}, done <-chan bool) bool {
+		select {
+		case f.changes <- change.(*historian.StreamChange):
+			return true
+		case <-done:
+			return false
+		}
+	}, func() { close(f.changes) })
+	return f
+}
+
+// Feed of stream entry changes.
+type StreamEntryChangeFeed struct {
+	*Feed
+	changes chan *historian.StreamEntryChange
+}
+
+func (f *StreamEntryChangeFeed) Changes() <-chan *historian.StreamEntryChange {
+	return f.changes
+}
+
+// Open a feed of the stream entry changes published from now on.
+func (h *Hub) WatchEntries() *StreamEntryChangeFeed {
+	f := &StreamEntryChangeFeed{changes: make(chan *historian.StreamEntryChange)}
+	f.Feed = h.watch(func(change interface{}, done <-chan bool) bool {
+		select {
+		case f.changes <- change.(*historian.StreamEntryChange):
+			return true
+		case <-done:
+			return false
+		}
+	}, func() { close(f.changes) })
+	return f
+}
+
+// Feed of template and device changes.
+type DeviceChangeFeed struct {
+	*Feed
+	changes chan *historian.DeviceChange
+}
+
+func (f *DeviceChangeFeed) Changes() <-chan *historian.DeviceChange {
+	return f.changes
+}
+
+// Open a feed of the template and device changes published from now on.
+func (h *Hub) WatchDevices() *DeviceChangeFeed {
+	f := &DeviceChangeFeed{changes: make(chan *historian.DeviceChange)}
+	f.Feed = h.watch(func(change interface{}, done <-chan bool) bool {
+		select {
+		case f.changes <- change.(*historian.DeviceChange):
+			return true
+		case <-done:
+			return false
+		}
+	}, func() { close(f.changes) })
+	return f
+}
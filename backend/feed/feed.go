@@ -0,0 +1,142 @@
+// In-process change feeds for storage backends that can't rely on the
+// database to notify them of changes.
+package feed
+
+import (
+	"sync"
+)
+
+// Fans changes out to any number of feeds, each with an unbounded, ordered
+// queue so publishers never block on slow readers. One hub carries one kind
+// of change. The zero value is ready to use.
+type Hub struct {
+	mtx   sync.Mutex
+	feeds []*Feed
+}
+
+// Queue of changes published through a Hub, delivered in order until the
+// feed is closed.
+type Feed struct {
+	hub     *Hub
+	mtx     sync.Mutex
+	pending []interface{}
+	closed  bool
+	err     error
+	wake    chan bool
+	done    chan bool
+}
+
+// Open a feed of changes published from now on. deliver is called with each
+// change in order, and returns false if done closed first. end is called
+// once delivery stops.
+func (h *Hub) watch(deliver func(change interface{}, done <-chan bool) bool, end func()) *Feed {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	f := &Feed{
+		hub:  h,
+		wake: make(chan bool, 1),
+		done: make(chan bool),
+	}
+	h.feeds = append(h.feeds, f)
+	go func() {
+		defer end()
+		f.pump(deliver)
+	}()
+	return f
+}
+
+// Publish a change to all open feeds. Never blocks.
+func (h *Hub) Publish(change interface{}) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	for _, f := range h.feeds {
+		f.push(change)
+	}
+}
+
+// End all open feeds with err.
+func (h *Hub) Fail(err error) {
+	h.mtx.Lock()
+	feeds := h.feeds
+	h.feeds = nil
+	h.mtx.Unlock()
+
+	for _, f := range feeds {
+		f.close(err)
+	}
+}
+
+func (h *Hub) remove(feed *Feed) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	for i, f := range h.feeds {
+		if f == feed {
+			h.feeds = append(h.feeds[:i], h.feeds[i+1:]...)
+			return
+		}
+	}
+}
+
+func (f *Feed) push(change interface{}) {
+	f.mtx.Lock()
+	if !f.closed {
+		f.pending = append(f.pending, change)
+	}
+	f.mtx.Unlock()
+
+	select {
+	case f.wake <- true:
+	default:
+	}
+}
+
+// Deliver queued changes in order until closed.
+func (f *Feed) pump(deliver func(change interface{}, done <-chan bool) bool) {
+	for {
+		f.mtx.Lock()
+		pending := f.pending
+		f.pending = nil
+		f.mtx.Unlock()
+
+		for _, change := range pending {
+			if !deliver(change, f.done) {
+				return
+			}
+		}
+
+		select {
+		case <-f.wake:
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// Stop delivering changes, recording err as the reason.
+func (f *Feed) close(err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.closed {
+		return
+	}
+	f.closed = true
+	f.err = err
+	f.pending = nil
+	close(f.done)
+}
+
+// Error that ended the feed, if any.
+func (f *Feed) Err() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.err
+}
+
+func (f *Feed) Close() error {
+	f.hub.remove(f)
+	f.close(nil)
+	return nil
+}
@@ -0,0 +1,78 @@
+package feed
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/dbproto"
+)
+
+func TestHubDeliversInOrder(t *testing.T) {
+	var hub Hub
+	feeds := []*StreamChangeFeed{hub.WatchStreams(), hub.WatchStreams()}
+
+	// Nobody reads until everything is published.
+	for i := 0; i < 100; i++ {
+		hub.Publish(&historian.StreamChange{NewValue: &dbproto.Stream{Id: string(rune('a' + i%26))}})
+	}
+	for _, f := range feeds {
+		for i := 0; i < 100; i++ {
+			select {
+			case cha := <-f.Changes():
+				if expected := string(rune('a' + i%26)); cha.NewValue.Id != expected {
+					t.Fatalf("change %d: expected %s, got %s", i, expected, cha.NewValue.Id)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("change %d was not delivered", i)
+			}
+		}
+		f.Close()
+	}
+}
+
+func TestHubFail(t *testing.T) {
+	var hub Hub
+	f := hub.WatchEntries()
+	failure := errors.New("Connection lost.")
+	hub.Fail(failure)
+
+	select {
+	case _, ok := <-f.Changes():
+		if ok {
+			t.Fatal("expected no changes after the hub failed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("feed was not closed")
+	}
+	if f.Err() != failure {
+		t.Fatalf("expected %v, got %v", failure, f.Err())
+	}
+
+	// Later feeds are unaffected.
+	g := hub.WatchEntries()
+	defer g.Close()
+	hub.Publish(&historian.StreamEntryChange{})
+	select {
+	case <-g.Changes():
+	case <-time.After(time.Second):
+		t.Fatal("change was not delivered")
+	}
+}
+
+func TestFeedClose(t *testing.T) {
+	var hub Hub
+	f := hub.WatchDevices()
+	hub.Publish(&historian.DeviceChange{})
+	f.Close()
+
+	if len(hub.feeds) != 0 {
+		t.Fatalf("expected the closed feed to be removed, %d remain", len(hub.feeds))
+	}
+	if f.Err() != nil {
+		t.Fatalf("expected no error, got %v", f.Err())
+	}
+	// Publishing after close never blocks.
+	hub.Publish(&historian.DeviceChange{})
+}
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/golang/protobuf/proto"
+)
+
+// Insert or replace a stream template, notifying watchers.
+func (b *Backend) PutTemplate(data *dbproto.StreamTemplate) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	data = proto.Clone(data).(*dbproto.StreamTemplate)
+	change := &historian.DeviceChange{
+		NewTemplate: data,
+		OldTemplate: b.templates[data.Id],
+	}
+	b.templates[data.Id] = data
+	b.deviceHub.Publish(change)
+}
+
+// Delete a stream template, notifying watchers.
+func (b *Backend) DeleteTemplate(id string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	old, ok := b.templates[id]
+	if !ok {
+		return
+	}
+	delete(b.templates, id)
+	b.deviceHub.Publish(&historian.DeviceChange{OldTemplate: old})
+}
+
+// Insert or replace a device record, notifying watchers.
+func (b *Backend) PutDevice(data *dbproto.Device) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	data = proto.Clone(data).(*dbproto.Device)
+	change := &historian.DeviceChange{
+		NewDevice: data,
+		OldDevice: b.devices[data.Id],
+	}
+	b.devices[data.Id] = data
+	b.deviceHub.Publish(change)
+}
+
+// Record a stream registered on a device, notifying watchers.
+func (b *Backend) AddDeviceStream(deviceId string, stream *dbproto.DeviceStream) (bool, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	old, ok := b.devices[deviceId]
+	if !ok || historian.HasDeviceStream(old, stream) {
+		return false, nil
+	}
+	data := proto.Clone(old).(*dbproto.Device)
+	data.Registered = append(data.Registered, proto.Clone(stream).(*dbproto.DeviceStream))
+	b.devices[deviceId] = data
+	b.deviceHub.Publish(&historian.DeviceChange{NewDevice: data, OldDevice: old})
+	return true, nil
+}
+
+// Delete a device record, notifying watchers.
+func (b *Backend) DeleteDevice(id string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	old, ok := b.devices[id]
+	if !ok {
+		return
+	}
+	delete(b.devices, id)
+	b.deviceHub.Publish(&historian.DeviceChange{OldDevice: old})
+}
+
+// Returns all templates and devices, and a feed of changes.
+func (b *Backend) WatchDevices() ([]*dbproto.StreamTemplate, []*dbproto.Device, historian.DeviceChangeFeed, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	templates := make([]*dbproto.StreamTemplate, 0, len(b.templates))
+	for _, tmpl := range b.templates {
+		templates = append(templates, tmpl)
+	}
+	devices := make([]*dbproto.Device, 0, len(b.devices))
+	for _, dev := range b.devices {
+		devices = append(devices, dev)
+	}
+	return templates, devices, b.deviceHub.WatchDevices(), nil
+}
@@ -0,0 +1,165 @@
+package memory
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/feed"
+	"github.com/fuserobotics/historian/dbproto"
+)
+
+// In-memory storage backend, for tests and simulators.
+// Safe for concurrent use. Nothing is persisted.
+type Backend struct {
+	mtx       sync.Mutex
+	streams   map[string]*dbproto.Stream
+	streamHub feed.Hub
+	tables    map[string]*table
+
+	templates map[string]*dbproto.StreamTemplate
+	devices   map[string]*dbproto.Device
+	deviceHub feed.Hub
+}
+
+func NewBackend() *Backend {
+	return &Backend{
+		streams:   make(map[string]*dbproto.Stream),
+		tables:    make(map[string]*table),
+		templates: make(map[string]*dbproto.StreamTemplate),
+		devices:   make(map[string]*dbproto.Device),
+	}
+}
+
+// Insert or replace a stream definition, notifying watchers.
+func (b *Backend) PutStream(data *dbproto.Stream) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	data, err := historian.StreamWithId(data)
+	if err != nil {
+		return err
+	}
+	change := &historian.StreamChange{
+		NewValue: data,
+		OldValue: b.streams[data.Id],
+	}
+	b.streams[data.Id] = data
+	b.streamHub.Publish(change)
+	return nil
+}
+
+// Store a stream definition unless one with the same ID exists.
+func (b *Backend) InsertStream(data *dbproto.Stream) (bool, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	data, err := historian.StreamWithId(data)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := b.streams[data.Id]; ok {
+		return false, nil
+	}
+	b.streams[data.Id] = data
+	b.streamHub.Publish(&historian.StreamChange{NewValue: data})
+	return true, nil
+}
+
+// Delete a stream definition, notifying watchers.
+func (b *Backend) DeleteStream(id string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	old, ok := b.streams[id]
+	if !ok {
+		return
+	}
+	delete(b.streams, id)
+	b.streamHub.Publish(&historian.StreamChange{OldValue: old})
+}
+
+// Returns all known streams, sorted by ID, and a feed of changes.
+func (b *Backend) WatchStreams() ([]*dbproto.Stream, historian.StreamChangeFeed, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	streams := make([]*dbproto.Stream, 0, len(b.streams))
+	for _, str := range b.streams {
+		streams = append(streams, str)
+	}
+	sort.Sort(streamsById(streams))
+
+	return streams, b.streamHub.WatchStreams(), nil
+}
+
+// Open the entry table for a stream, creating it if necessary.
+func (b *Backend) OpenStream(data *dbproto.Stream) (historian.StreamBackend, error) {
+	return b.table(historian.DbStreamTableName(data)), nil
+}
+
+// Create the entry table for a stream.
+func (b *Backend) CreateStream(data *dbproto.Stream) error {
+	b.table(historian.DbStreamTableName(data))
+	return nil
+}
+
+// Drop the entry table of a deleted stream, or rename it out of the way.
+func (b *Backend) DropStream(data *dbproto.Stream, policy historian.TeardownPolicy) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	name := historian.DbStreamTableName(data)
+	t, ok := b.tables[name]
+	if !ok || policy == historian.TeardownKeep {
+		return nil
+	}
+	delete(b.tables, name)
+	if policy == historian.TeardownArchive {
+		b.tables[historian.ArchiveStreamTableName(data, time.Now())] = t
+	}
+	return nil
+}
+
+// Store entries one at a time, there are no round trips to save.
+func (b *Backend) SaveEntries(writes []*historian.EntryWrite) []error {
+	errs := make([]error, len(writes))
+	for i, write := range writes {
+		t, ok := write.Storage.(*table)
+		if !ok {
+			errs[i] = errors.New("Stream storage not opened from this backend.")
+			continue
+		}
+		errs[i] = t.saveEntry(write.Entry, write.Meta)
+	}
+	return errs
+}
+
+// Amend an entry, announcing the writer with it.
+func (b *Backend) AmendEntry(write *historian.EntryWrite, oldTimestamp time.Time) error {
+	t, ok := write.Storage.(*table)
+	if !ok {
+		return errors.New("Stream storage not opened from this backend.")
+	}
+	return t.amendEntry(write.Entry, oldTimestamp, write.Meta)
+}
+
+func (b *Backend) table(name string) *table {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	t, ok := b.tables[name]
+	if !ok {
+		t = &table{}
+		b.tables[name] = t
+	}
+	return t
+}
+
+type streamsById []*dbproto.Stream
+
+func (s streamsById) Len() int           { return len(s) }
+func (s streamsById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s streamsById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/backendtest"
+)
+
+func TestBackend(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) (historian.Backend, func()) {
+		return NewBackend(), func() {}
+	})
+}
@@ -0,0 +1,202 @@
+package memory
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/feed"
+	"github.com/fuserobotics/statestream"
+)
+
+// Entries of a single stream, ordered by timestamp.
+type table struct {
+	mtx     sync.Mutex
+	entries []*stream.StreamEntry
+	seq     uint64
+	hub     feed.Hub
+}
+
+// Index of the first entry at or after timestamp.
+func (t *table) search(timestamp time.Time) int {
+	return sort.Search(len(t.entries), func(i int) bool {
+		return !t.entries[i].Timestamp.Before(timestamp)
+	})
+}
+
+// Retrieve the latest snapshot before timestamp. Return nil for no data.
+func (t *table) GetSnapshotBefore(timestamp time.Time) (*stream.StreamEntry, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for i := t.search(timestamp) - 1; i >= 0; i-- {
+		if t.entries[i].Type == stream.StreamEntrySnapshot {
+			return copyEntry(t.entries[i]), nil
+		}
+	}
+	return nil, nil
+}
+
+// Retrieve the earliest entry after timestamp. Return nil for no data.
+func (t *table) GetEntryAfter(timestamp time.Time, filterType stream.StreamEntryType) (*stream.StreamEntry, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for i := t.search(timestamp); i < len(t.entries); i++ {
+		entry := t.entries[i]
+		if !entry.Timestamp.After(timestamp) {
+			continue
+		}
+		if filterType == stream.StreamEntryAny || entry.Type == filterType {
+			return copyEntry(entry), nil
+		}
+	}
+	return nil, nil
+}
+
+// Retrieve up to limit entries in (timestamp, until], in timestamp order.
+func (t *table) GetEntriesAfter(timestamp, until time.Time, filterType stream.StreamEntryType, limit int) ([]*stream.StreamEntry, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	var res []*stream.StreamEntry
+	for i := t.search(timestamp); i < len(t.entries) && len(res) < limit; i++ {
+		entry := t.entries[i]
+		if entry.Timestamp.After(until) {
+			break
+		}
+		if !entry.Timestamp.After(timestamp) {
+			continue
+		}
+		if filterType == stream.StreamEntryAny || entry.Type == filterType {
+			res = append(res, copyEntry(entry))
+		}
+	}
+	return res, nil
+}
+
+// Retrieve up to limit entries before timestamp, newest first.
+func (t *table) GetEntriesBefore(timestamp time.Time, limit int) ([]*stream.StreamEntry, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	var res []*stream.StreamEntry
+	for i := t.search(timestamp) - 1; i >= 0 && len(res) < limit; i-- {
+		res = append(res, copyEntry(t.entries[i]))
+	}
+	return res, nil
+}
+
+// Store a stream entry. Timestamps are unique, like a primary key.
+func (t *table) SaveEntry(entry *stream.StreamEntry) error {
+	return t.saveEntry(entry, nil)
+}
+
+// Store a stream entry, raising the sequence with it and announcing the writer
+// if meta is set.
+func (t *table) saveEntry(entry *stream.StreamEntry, meta *historian.EntryMeta) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	entry = copyEntry(entry)
+	idx := t.search(entry.Timestamp)
+	if idx < len(t.entries) && t.entries[idx].Timestamp.Equal(entry.Timestamp) {
+		return errors.New("Duplicate entry timestamp.")
+	}
+	if meta != nil && meta.Sequence != 0 {
+		if meta.Sequence <= t.seq {
+			return historian.ErrDuplicateSequence
+		}
+		t.seq = meta.Sequence
+	}
+	t.entries = append(t.entries, nil)
+	copy(t.entries[idx+1:], t.entries[idx:])
+	t.entries[idx] = entry
+	t.hub.Publish(&historian.StreamEntryChange{NewValue: copyEntry(entry), Writer: meta.GetWriter()})
+	return nil
+}
+
+// Amend an old entry
+func (t *table) AmendEntry(entry *stream.StreamEntry, oldTimestamp time.Time) error {
+	return t.amendEntry(entry, oldTimestamp, nil)
+}
+
+// Amend an old entry, announcing the writer if meta is set.
+func (t *table) amendEntry(entry *stream.StreamEntry, oldTimestamp time.Time, meta *historian.EntryMeta) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	idx := t.search(oldTimestamp)
+	if idx >= len(t.entries) || !t.entries[idx].Timestamp.Equal(oldTimestamp) {
+		return errors.New("Entry to amend not found.")
+	}
+	if !entry.Timestamp.Equal(oldTimestamp) {
+		return errors.New("Cannot change the timestamp of an entry.")
+	}
+	old := t.entries[idx]
+	t.entries[idx] = copyEntry(entry)
+	t.hub.Publish(&historian.StreamEntryChange{NewValue: copyEntry(entry), OldValue: old, Writer: meta.GetWriter()})
+	return nil
+}
+
+// Delete the entries in (after, before).
+func (t *table) DeleteEntries(after, before time.Time) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	start := t.search(after)
+	if start < len(t.entries) && t.entries[start].Timestamp.Equal(after) {
+		start++
+	}
+	end := t.search(before)
+	if start < end {
+		t.entries = append(t.entries[:start], t.entries[end:]...)
+	}
+	return nil
+}
+
+// Watch for entries written from now on.
+func (t *table) WatchEntries() (historian.StreamEntryChangeFeed, error) {
+	return t.hub.WatchEntries(), nil
+}
+
+// Retrieve the highest sequence number committed with an entry, 0 if none.
+func (t *table) GetSequence() (uint64, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return t.seq, nil
+}
+
+// Copy an entry so callers can't modify stored data.
+func copyEntry(entry *stream.StreamEntry) *stream.StreamEntry {
+	res := *entry
+	res.Data = copyValue(map[string]interface{}(entry.Data)).(map[string]interface{})
+	return &res
+}
+
+func copyValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		res := make(map[string]interface{}, len(v))
+		for k, iv := range v {
+			res[k] = copyValue(iv)
+		}
+		return res
+	case stream.StateData:
+		return stream.StateData(copyValue(map[string]interface{}(v)).(map[string]interface{}))
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, iv := range v {
+			res[i] = copyValue(iv)
+		}
+		return res
+	default:
+		return v
+	}
+}
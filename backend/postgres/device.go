@@ -0,0 +1,196 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/dbproto"
+)
+
+const (
+	templatesTableName = "templates"
+	devicesTableName   = "devices"
+)
+
+// Insert or replace a JSON record in the templates or devices table.
+func (b *Backend) putRecord(table, id string, val interface{}) error {
+	if id == "" {
+		return errors.New("Id must be specified.")
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec(fmt.Sprintf(`INSERT INTO %s (id, data) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data`, table), id, string(data))
+	return err
+}
+
+// Decode the JSON record under id into dst. Returns false if there is none.
+func (b *Backend) loadRecord(table, id string, dst interface{}) (bool, error) {
+	var val []byte
+	err := b.db.QueryRow(fmt.Sprintf(`SELECT data FROM %s WHERE id = $1`, table), id).Scan(&val)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(val, dst)
+}
+
+// Insert or replace a stream template.
+func (b *Backend) PutTemplate(data *dbproto.StreamTemplate) error {
+	return b.putRecord(templatesTableName, data.Id, data)
+}
+
+// Delete a stream template.
+func (b *Backend) DeleteTemplate(id string) error {
+	_, err := b.db.Exec(`DELETE FROM templates WHERE id = $1`, id)
+	return err
+}
+
+// Insert or replace a device record.
+func (b *Backend) PutDevice(data *dbproto.Device) error {
+	return b.putRecord(devicesTableName, data.Id, data)
+}
+
+// Record a stream registered on a device. The devices trigger announces the
+// change.
+func (b *Backend) AddDeviceStream(deviceId string, stream *dbproto.DeviceStream) (bool, error) {
+	val, err := json.Marshal([]*dbproto.DeviceStream{stream})
+	if err != nil {
+		return false, err
+	}
+	res, err := b.db.Exec(`UPDATE devices
+		SET data = jsonb_set(data, '{registered}', COALESCE(data->'registered', '[]'::jsonb) || $2::jsonb)
+		WHERE id = $1 AND NOT COALESCE(data->'registered', '[]'::jsonb) @> $2::jsonb`, deviceId, string(val))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Delete a device record.
+func (b *Backend) DeleteDevice(id string) error {
+	_, err := b.db.Exec(`DELETE FROM devices WHERE id = $1`, id)
+	return err
+}
+
+// Loads all templates and devices, and a feed of later changes.
+func (b *Backend) WatchDevices() ([]*dbproto.StreamTemplate, []*dbproto.Device, historian.DeviceChangeFeed, error) {
+	b.devicesMtx.Lock()
+	defer b.devicesMtx.Unlock()
+
+	templates := make(map[string]*dbproto.StreamTemplate)
+	var templateList []*dbproto.StreamTemplate
+	err := b.loadAll(templatesTableName, func(val []byte) error {
+		tmpl := &dbproto.StreamTemplate{}
+		if err := json.Unmarshal(val, tmpl); err != nil {
+			return err
+		}
+		templates[tmpl.Id] = tmpl
+		templateList = append(templateList, tmpl)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	devices := make(map[string]*dbproto.Device)
+	var deviceList []*dbproto.Device
+	err = b.loadAll(devicesTableName, func(val []byte) error {
+		dev := &dbproto.Device{}
+		if err := json.Unmarshal(val, dev); err != nil {
+			return err
+		}
+		devices[dev.Id] = dev
+		deviceList = append(deviceList, dev)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	b.templates = templates
+	b.devices = devices
+	return templateList, deviceList, b.deviceHub.WatchDevices(), nil
+}
+
+// Call fn with the data of every row of a table, ordered by ID.
+func (b *Backend) loadAll(table string, fn func(val []byte) error) error {
+	rows, err := b.db.Query(fmt.Sprintf(`SELECT data FROM %s ORDER BY id`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var val []byte
+		if err := rows.Scan(&val); err != nil {
+			return err
+		}
+		if err := fn(val); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (b *Backend) handleDeviceNotification(noti *notification) {
+	b.devicesMtx.Lock()
+	defer b.devicesMtx.Unlock()
+
+	change := &historian.DeviceChange{}
+	switch noti.Table {
+	case templatesTableName:
+		change.OldTemplate = b.templates[noti.Id]
+		if noti.Op != "DELETE" {
+			tmpl := &dbproto.StreamTemplate{}
+			found, err := b.loadRecord(templatesTableName, noti.Id, tmpl)
+			if err != nil {
+				b.fail(err)
+				return
+			}
+			if found {
+				change.NewTemplate = tmpl
+			}
+		}
+		if change.NewTemplate == nil && change.OldTemplate == nil {
+			return
+		}
+		if change.NewTemplate != nil {
+			b.templates[noti.Id] = change.NewTemplate
+		} else {
+			delete(b.templates, noti.Id)
+		}
+	case devicesTableName:
+		change.OldDevice = b.devices[noti.Id]
+		if noti.Op != "DELETE" {
+			dev := &dbproto.Device{}
+			found, err := b.loadRecord(devicesTableName, noti.Id, dev)
+			if err != nil {
+				b.fail(err)
+				return
+			}
+			if found {
+				change.NewDevice = dev
+			}
+		}
+		if change.NewDevice == nil && change.OldDevice == nil {
+			return
+		}
+		if change.NewDevice != nil {
+			b.devices[noti.Id] = change.NewDevice
+		} else {
+			delete(b.devices, noti.Id)
+		}
+	default:
+		return
+	}
+	b.deviceHub.Publish(change)
+}
@@ -0,0 +1,394 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/feed"
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/golang/glog"
+	"github.com/lib/pq"
+)
+
+// PostgreSQL storage backend.
+// Stream definitions are stored as JSONB in the streams table and each
+// stream gets its own table of entries. Stream templates and device records
+// are stored the same way in the templates and devices tables. Triggers on
+// those tables announce changes with NOTIFY, replacing RethinkDB changefeeds.
+type Backend struct {
+	db       *sql.DB
+	listener *pq.Listener
+
+	// Create stream tables as TimescaleDB hypertables.
+	Hypertables bool
+	// Writer ID of the local historian instance. Changes to entries it wrote
+	// are not announced, it applied them already.
+	InstanceId string
+
+	// Serializes stream notifications with WatchStreams.
+	mtx sync.Mutex
+	// Last seen definitions, to fill in old values of changes.
+	streams   map[string]*dbproto.Stream
+	streamHub feed.Hub
+
+	// Serializes template and device notifications with WatchDevices.
+	devicesMtx sync.Mutex
+	// Last seen templates and devices, to fill in old values of changes.
+	templates map[string]*dbproto.StreamTemplate
+	devices   map[string]*dbproto.Device
+	deviceHub feed.Hub
+
+	tablesMtx sync.Mutex
+	tables    map[string]*table
+}
+
+// Connect to dataSource, a lib/pq connection string, and create the schema.
+func Open(dataSource string) (*Backend, error) {
+	db, err := sql.Open("postgres", dataSource)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	b := &Backend{
+		db:        db,
+		streams:   make(map[string]*dbproto.Stream),
+		tables:    make(map[string]*table),
+		templates: make(map[string]*dbproto.StreamTemplate),
+		devices:   make(map[string]*dbproto.Device),
+	}
+	if err := b.exec(schemaStatements); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	b.listener = pq.NewListener(dataSource, time.Second, time.Minute, b.listenerEvent)
+	for _, channel := range []string{streamsChannel, entriesChannel, devicesChannel} {
+		if err := b.listener.Listen(channel); err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+	go b.listen()
+	return b, nil
+}
+
+func (b *Backend) Close() error {
+	if b.listener != nil {
+		b.listener.Close()
+	}
+	return b.db.Close()
+}
+
+func (b *Backend) exec(statements []string) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Insert or replace a stream definition.
+func (b *Backend) PutStream(data *dbproto.Stream) error {
+	data, err := historian.StreamWithId(data)
+	if err != nil {
+		return err
+	}
+	val, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec(`INSERT INTO streams (id, data) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data`, data.Id, string(val))
+	return err
+}
+
+// Store a stream definition unless one with the same ID exists.
+func (b *Backend) InsertStream(data *dbproto.Stream) (bool, error) {
+	data, err := historian.StreamWithId(data)
+	if err != nil {
+		return false, err
+	}
+	val, err := json.Marshal(data)
+	if err != nil {
+		return false, err
+	}
+	res, err := b.db.Exec(`INSERT INTO streams (id, data) VALUES ($1, $2)
+		ON CONFLICT (id) DO NOTHING`, data.Id, string(val))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Delete a stream definition.
+func (b *Backend) DeleteStream(id string) error {
+	_, err := b.db.Exec(`DELETE FROM streams WHERE id = $1`, id)
+	return err
+}
+
+func (b *Backend) loadStream(id string) (*dbproto.Stream, error) {
+	var val []byte
+	err := b.db.QueryRow(`SELECT data FROM streams WHERE id = $1`, id).Scan(&val)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	str := &dbproto.Stream{}
+	if err := json.Unmarshal(val, str); err != nil {
+		return nil, err
+	}
+	return str, nil
+}
+
+// Loads all streams, ordered by ID, and a feed of later changes.
+func (b *Backend) WatchStreams() ([]*dbproto.Stream, historian.StreamChangeFeed, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	rows, err := b.db.Query(`SELECT data FROM streams ORDER BY id`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	known := make(map[string]*dbproto.Stream)
+	var streams []*dbproto.Stream
+	for rows.Next() {
+		var val []byte
+		if err := rows.Scan(&val); err != nil {
+			return nil, nil, err
+		}
+		str := &dbproto.Stream{}
+		if err := json.Unmarshal(val, str); err != nil {
+			return nil, nil, err
+		}
+		known[str.Id] = str
+		streams = append(streams, str)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	b.streams = known
+	return streams, b.streamHub.WatchStreams(), nil
+}
+
+// Open the entry table for a stream, creating it if necessary.
+func (b *Backend) OpenStream(data *dbproto.Stream) (historian.StreamBackend, error) {
+	name := tableName(data)
+
+	b.tablesMtx.Lock()
+	defer b.tablesMtx.Unlock()
+
+	if t, ok := b.tables[name]; ok {
+		return t, nil
+	}
+	if err := b.exec(streamTableStatements(name, b.Hypertables)); err != nil {
+		return nil, err
+	}
+	t := newTable(b, name)
+	b.tables[name] = t
+	return t, nil
+}
+
+// Store entries in any number of streams in a single transaction.
+func (b *Backend) SaveEntries(writes []*historian.EntryWrite) []error {
+	errs := make([]error, len(writes))
+
+	var tables []*table
+	indexes := make(map[*table][]int)
+	entries := make(map[*table][]*historian.EntryWrite)
+	// Writes raising a sequence, inserted one at a time.
+	var sequenced []int
+	for i, write := range writes {
+		t, ok := write.Storage.(*table)
+		if !ok || t.b != b {
+			errs[i] = errors.New("Stream storage not opened from this backend.")
+			continue
+		}
+		if write.Meta != nil && write.Meta.Sequence != 0 {
+			sequenced = append(sequenced, i)
+			continue
+		}
+		if _, ok := indexes[t]; !ok {
+			tables = append(tables, t)
+		}
+		indexes[t] = append(indexes[t], i)
+		entries[t] = append(entries[t], write)
+	}
+
+	fail := func(err error) []error {
+		for _, t := range tables {
+			for _, idx := range indexes[t] {
+				errs[idx] = err
+			}
+		}
+		for _, idx := range sequenced {
+			errs[idx] = err
+		}
+		return errs
+	}
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		return fail(err)
+	}
+	for _, t := range tables {
+		terrs, err := t.insert(tx, entries[t])
+		if err != nil {
+			tx.Rollback()
+			return fail(err)
+		}
+		for j, idx := range indexes[t] {
+			errs[idx] = terrs[j]
+		}
+	}
+	for _, idx := range sequenced {
+		write := writes[idx]
+		werr, err := write.Storage.(*table).insertSequenced(tx, write)
+		if err != nil {
+			tx.Rollback()
+			return fail(err)
+		}
+		errs[idx] = werr
+	}
+	if err := tx.Commit(); err != nil {
+		return fail(err)
+	}
+	return errs
+}
+
+// Amend an entry, storing the writer with it.
+func (b *Backend) AmendEntry(write *historian.EntryWrite, oldTimestamp time.Time) error {
+	t, ok := write.Storage.(*table)
+	if !ok || t.b != b {
+		return errors.New("Stream storage not opened from this backend.")
+	}
+	return t.amendEntry(write.Entry, oldTimestamp, write.Meta)
+}
+
+// Create the entry table for a stream.
+func (b *Backend) CreateStream(data *dbproto.Stream) error {
+	_, err := b.OpenStream(data)
+	return err
+}
+
+// Drop the entry table of a deleted stream, or rename it out of the way.
+func (b *Backend) DropStream(data *dbproto.Stream, policy historian.TeardownPolicy) error {
+	if policy == historian.TeardownKeep {
+		return nil
+	}
+
+	name := tableName(data)
+
+	b.tablesMtx.Lock()
+	defer b.tablesMtx.Unlock()
+	delete(b.tables, name)
+
+	return b.exec(dropStreamTableStatements(name, policy, historian.ArchiveStreamTableName(data, time.Now())))
+}
+
+// Name of the entry table of a stream.
+func tableName(data *dbproto.Stream) string {
+	return identifier(historian.DbStreamTableName(data))
+}
+
+type notification struct {
+	Op        string    `json:"op"`
+	Id        string    `json:"id"`
+	Table     string    `json:"table"`
+	Timestamp time.Time `json:"timestamp"`
+	// Writer of an inserted or updated entry, if any.
+	Writer string `json:"writer"`
+}
+
+func (b *Backend) listenerEvent(ev pq.ListenerEventType, err error) {
+	if err != nil {
+		glog.Warningf("PostgreSQL listener event %d: %v", ev, err)
+	}
+}
+
+// Dispatch notifications to feeds until the listener is closed.
+func (b *Backend) listen() {
+	for n := range b.listener.Notify {
+		if n == nil {
+			// Reconnected, notifications may have been missed.
+			b.fail(errors.New("Lost connection to PostgreSQL notifications."))
+			continue
+		}
+
+		noti := &notification{}
+		if err := json.Unmarshal([]byte(n.Extra), noti); err != nil {
+			glog.Warningf("Invalid notification on %s: %v", n.Channel, err)
+			continue
+		}
+		switch n.Channel {
+		case streamsChannel:
+			b.handleStreamNotification(noti)
+		case devicesChannel:
+			b.handleDeviceNotification(noti)
+		case entriesChannel:
+			b.tablesMtx.Lock()
+			t, ok := b.tables[noti.Table]
+			b.tablesMtx.Unlock()
+			if ok {
+				t.handleNotification(noti)
+			}
+		}
+	}
+	b.fail(errors.New("PostgreSQL listener closed."))
+}
+
+func (b *Backend) handleStreamNotification(noti *notification) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	change := &historian.StreamChange{OldValue: b.streams[noti.Id]}
+	if noti.Op != "DELETE" {
+		str, err := b.loadStream(noti.Id)
+		if err != nil {
+			b.fail(err)
+			return
+		}
+		change.NewValue = str
+	}
+	if change.NewValue == nil && change.OldValue == nil {
+		return
+	}
+
+	if change.NewValue != nil {
+		b.streams[noti.Id] = change.NewValue
+	} else {
+		delete(b.streams, noti.Id)
+	}
+	b.streamHub.Publish(change)
+}
+
+// End all feeds, so watchers reload.
+func (b *Backend) fail(err error) {
+	b.streamHub.Fail(err)
+	b.deviceHub.Fail(err)
+
+	b.tablesMtx.Lock()
+	defer b.tablesMtx.Unlock()
+	for _, t := range b.tables {
+		t.hub.Fail(err)
+	}
+}
@@ -0,0 +1,316 @@
+package postgres
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/backendtest"
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+)
+
+// Connection string of a scratch database to run the tests against, for
+// example postgres://historian@localhost/historian_test?sslmode=disable.
+const testDataSourceEnv = "HISTORIAN_TEST_PG"
+
+func openTestBackend(t *testing.T) *Backend {
+	dataSource := os.Getenv(testDataSourceEnv)
+	if dataSource == "" {
+		t.Skipf("%s not set", testDataSourceEnv)
+	}
+	b, err := Open(dataSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// A stream definition no earlier run has used.
+func testStream() *dbproto.Stream {
+	return &dbproto.Stream{
+		DeviceHostname: fmt.Sprintf("test_%d", time.Now().UnixNano()),
+		ComponentName:  "fc",
+		StateName:      "state",
+	}
+}
+
+func TestBackend(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) (historian.Backend, func()) {
+		b := openTestBackend(t)
+		return b, func() { b.Close() }
+	})
+}
+
+func TestStreamNotifications(t *testing.T) {
+	b := openTestBackend(t)
+	defer b.Close()
+
+	_, feed, err := b.WatchStreams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Close()
+
+	data := testStream()
+	if err := b.PutStream(data); err != nil {
+		t.Fatal(err)
+	}
+	id := historian.DbStreamTableName(data)
+	defer b.DeleteStream(id)
+
+	select {
+	case cha := <-feed.Changes():
+		if cha.NewValue == nil || cha.NewValue.Id != id || cha.OldValue != nil {
+			t.Fatalf("expected the insert of %s, got %v", id, cha)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("insert was not announced")
+	}
+
+	if err := b.DeleteStream(id); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cha := <-feed.Changes():
+		if cha.NewValue != nil || cha.OldValue == nil || cha.OldValue.Id != id {
+			t.Fatalf("expected the delete of %s, got %v", id, cha)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("delete was not announced")
+	}
+}
+
+func TestEntries(t *testing.T) {
+	b := openTestBackend(t)
+	defer b.Close()
+
+	data := testStream()
+	storage, err := b.OpenStream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.DropStream(data, historian.TeardownDrop)
+
+	feed, err := storage.WatchEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Close()
+
+	base := time.Unix(1000, 0).UTC()
+	entries := []*stream.StreamEntry{
+		{Type: stream.StreamEntrySnapshot, Data: stream.StateData{"altitude": 1.0}, Timestamp: base},
+		{Type: stream.StreamEntryMutation, Data: stream.StateData{"altitude": 2.0}, Timestamp: base.Add(time.Second)},
+		{Type: stream.StreamEntrySnapshot, Data: stream.StateData{"altitude": 3.0}, Timestamp: base.Add(2 * time.Second)},
+	}
+	for _, entry := range entries {
+		if err := storage.SaveEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case cha := <-feed.Changes():
+			if cha.NewValue == nil || !cha.NewValue.Timestamp.Equal(entry.Timestamp) {
+				t.Fatalf("expected the entry at %v, got %v", entry.Timestamp, cha.NewValue)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("entry at %v was not announced", entry.Timestamp)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		after      time.Time
+		filterType stream.StreamEntryType
+		expected   int
+	}{
+		{"any after start", base, stream.StreamEntryAny, 1},
+		{"snapshot after start", base, stream.StreamEntrySnapshot, 2},
+		{"any before start", base.Add(-time.Second), stream.StreamEntryAny, 0},
+		{"any after end", base.Add(2 * time.Second), stream.StreamEntryAny, -1},
+	}
+	for _, test := range tests {
+		entry, err := storage.GetEntryAfter(test.after, test.filterType)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if test.expected < 0 {
+			if entry != nil {
+				t.Fatalf("%s: expected no entry, got %v", test.name, entry)
+			}
+			continue
+		}
+		if entry == nil || !entry.Timestamp.Equal(entries[test.expected].Timestamp) {
+			t.Fatalf("%s: expected entry %d, got %v", test.name, test.expected, entry)
+		}
+	}
+
+	before, err := storage.GetEntriesBefore(base.Add(2*time.Second), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 2 || !before[0].Timestamp.Equal(entries[1].Timestamp) || !before[1].Timestamp.Equal(entries[0].Timestamp) {
+		t.Fatalf("expected the first two entries newest first, got %v", before)
+	}
+	if before[0].Data["altitude"] != 2.0 {
+		t.Fatalf("expected altitude 2, got %v", before[0].Data)
+	}
+
+	if err := storage.DeleteEntries(base, base.Add(2*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cha := <-feed.Changes():
+		if cha.NewValue != nil || cha.OldValue == nil || !cha.OldValue.Timestamp.Equal(entries[1].Timestamp) {
+			t.Fatalf("expected the delete of the entry at %v, got %v", entries[1].Timestamp, cha)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("delete was not announced")
+	}
+}
+
+func TestLocalWrites(t *testing.T) {
+	b := openTestBackend(t)
+	defer b.Close()
+	b.InstanceId = "local"
+
+	data := testStream()
+	storage, err := b.OpenStream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.DropStream(data, historian.TeardownDrop)
+
+	feed, err := storage.WatchEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Close()
+
+	base := time.Unix(1000, 0).UTC()
+	writes := []*historian.EntryWrite{
+		{Storage: storage, Entry: &stream.StreamEntry{Type: stream.StreamEntrySnapshot, Data: stream.StateData{"altitude": 1.0}, Timestamp: base}, Meta: &historian.EntryMeta{Writer: "local"}},
+		{Storage: storage, Entry: &stream.StreamEntry{Type: stream.StreamEntryMutation, Data: stream.StateData{"altitude": 2.0}, Timestamp: base.Add(time.Second)}, Meta: &historian.EntryMeta{Writer: "remote"}},
+	}
+	for i, err := range b.SaveEntries(writes) {
+		if err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+
+	// Only the entry written elsewhere is announced.
+	select {
+	case cha := <-feed.Changes():
+		if cha.NewValue == nil || !cha.NewValue.Timestamp.Equal(writes[1].Entry.Timestamp) || cha.Writer != "remote" {
+			t.Fatalf("expected the remote entry, got %v", cha)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("remote entry was not announced")
+	}
+}
+
+func TestIdentifier(t *testing.T) {
+	short := "plane_1_fc_state"
+	if res := identifier(short); res != short {
+		t.Fatalf("expected %s unchanged, got %s", short, res)
+	}
+	long := strings.Repeat("plane_1_", 8) + "fc_state"
+	names := []string{
+		identifier(long),
+		identifier(long + "_type_timestamp"),
+		identifier(long + "_archive_20161002201640"),
+		identifier(strings.Repeat("é", 40)),
+	}
+	for i, name := range names {
+		if len(name) > maxIdentifierLength || !utf8.ValidString(name) {
+			t.Fatalf("expected a valid identifier of at most %d bytes, got %q", maxIdentifierLength, name)
+		}
+		for _, other := range names[:i] {
+			if name == other {
+				t.Fatalf("expected distinct identifiers, got %s twice", name)
+			}
+		}
+	}
+}
+
+func TestTimeBounds(t *testing.T) {
+	aligned := time.Unix(1000, 1000)
+	fine := aligned.Add(500)
+	if !storedTime(fine).Equal(aligned) || !storedTime(aligned).Equal(aligned) {
+		t.Fatalf("expected times truncated to %v", aligned)
+	}
+	if !upperBound(fine).Equal(aligned.Add(time.Microsecond)) || !upperBound(aligned).Equal(aligned) {
+		t.Fatalf("expected bounds rounded up from %v", fine)
+	}
+}
+
+func TestNanosecondTimes(t *testing.T) {
+	b := openTestBackend(t)
+	defer b.Close()
+
+	data := testStream()
+	storage, err := b.OpenStream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.DropStream(data, historian.TeardownDrop)
+
+	// Found again with the usual nanosecond bounds.
+	ts := time.Unix(1000, 1500)
+	if err := storage.SaveEntry(&stream.StreamEntry{Type: stream.StreamEntrySnapshot, Data: stream.StateData{"altitude": 1.0}, Timestamp: ts}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := storage.GetEntriesBefore(ts.Add(time.Nanosecond), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 1 || !before[0].Timestamp.Equal(storedTime(ts)) {
+		t.Fatalf("expected the entry at %v, got %v", storedTime(ts), before)
+	}
+	after, err := storage.GetEntryAfter(ts.Add(-time.Nanosecond), stream.StreamEntryAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after == nil {
+		t.Fatal("expected the entry after its own timestamp")
+	}
+	if err := storage.AmendEntry(&stream.StreamEntry{Type: stream.StreamEntrySnapshot, Data: stream.StateData{"altitude": 2.0}, Timestamp: ts}, ts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLongStreamName(t *testing.T) {
+	b := openTestBackend(t)
+	defer b.Close()
+
+	data := testStream()
+	data.StateName = strings.Repeat("state_", 12)
+	storage, err := b.OpenStream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.DropStream(data, historian.TeardownDrop)
+
+	feed, err := storage.WatchEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Close()
+
+	entry := &stream.StreamEntry{Type: stream.StreamEntrySnapshot, Data: stream.StateData{"altitude": 1.0}, Timestamp: time.Unix(1000, 0)}
+	if err := storage.SaveEntry(entry); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cha := <-feed.Changes():
+		if cha.NewValue == nil || !cha.NewValue.Timestamp.Equal(entry.Timestamp) {
+			t.Fatalf("expected the entry at %v, got %v", entry.Timestamp, cha)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("entry was not announced")
+	}
+}
@@ -0,0 +1,139 @@
+package postgres
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/fuserobotics/historian"
+	"github.com/lib/pq"
+)
+
+const (
+	streamsTableName = "streams"
+
+	// Notification channels, see the triggers below.
+	streamsChannel = "historian_streams"
+	entriesChannel = "historian_entries"
+	devicesChannel = "historian_devices"
+
+	// Longer identifiers are truncated by PostgreSQL.
+	maxIdentifierLength = 63
+)
+
+// Shorten a name to fit in an identifier. Long names are cut and suffixed
+// with a hash of the full name, so that they stay distinct.
+func identifier(name string) string {
+	if len(name) <= maxIdentifierLength {
+		return name
+	}
+	sum := sha1.Sum([]byte(name))
+	suffix := "_" + hex.EncodeToString(sum[:4])
+	cut := maxIdentifierLength - len(suffix)
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+	return name[:cut] + suffix
+}
+
+// Creates the streams, stream_sequences, templates and devices tables and the
+// notification functions.
+var schemaStatements = []string{
+	`CREATE TABLE IF NOT EXISTS streams (
+		id TEXT PRIMARY KEY,
+		data JSONB NOT NULL
+	)`,
+	// Committed sequence of each stream, see historian.EntryMeta.
+	`CREATE TABLE IF NOT EXISTS stream_sequences (
+		stream TEXT PRIMARY KEY,
+		seq NUMERIC(20) NOT NULL
+	)`,
+	`CREATE OR REPLACE FUNCTION historian_notify_stream() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			PERFORM pg_notify('historian_streams', json_build_object('op', TG_OP, 'id', OLD.id)::text);
+			RETURN OLD;
+		END IF;
+		PERFORM pg_notify('historian_streams', json_build_object('op', TG_OP, 'id', NEW.id)::text);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE FUNCTION historian_notify_entry() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			PERFORM pg_notify('historian_entries', json_build_object('op', TG_OP, 'table', TG_TABLE_NAME, 'timestamp', OLD.timestamp)::text);
+			RETURN OLD;
+		END IF;
+		PERFORM pg_notify('historian_entries', json_build_object('op', TG_OP, 'table', TG_TABLE_NAME, 'timestamp', NEW.timestamp, 'writer', NEW.writer)::text);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS historian_notify ON streams`,
+	`CREATE TRIGGER historian_notify AFTER INSERT OR UPDATE OR DELETE ON streams
+		FOR EACH ROW EXECUTE PROCEDURE historian_notify_stream()`,
+	`CREATE TABLE IF NOT EXISTS templates (
+		id TEXT PRIMARY KEY,
+		data JSONB NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS devices (
+		id TEXT PRIMARY KEY,
+		data JSONB NOT NULL
+	)`,
+	`CREATE OR REPLACE FUNCTION historian_notify_device() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			PERFORM pg_notify('historian_devices', json_build_object('op', TG_OP, 'table', TG_TABLE_NAME, 'id', OLD.id)::text);
+			RETURN OLD;
+		END IF;
+		PERFORM pg_notify('historian_devices', json_build_object('op', TG_OP, 'table', TG_TABLE_NAME, 'id', NEW.id)::text);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS historian_notify ON templates`,
+	`CREATE TRIGGER historian_notify AFTER INSERT OR UPDATE OR DELETE ON templates
+		FOR EACH ROW EXECUTE PROCEDURE historian_notify_device()`,
+	`DROP TRIGGER IF EXISTS historian_notify ON devices`,
+	`CREATE TRIGGER historian_notify AFTER INSERT OR UPDATE OR DELETE ON devices
+		FOR EACH ROW EXECUTE PROCEDURE historian_notify_device()`,
+}
+
+// Statements creating the entry table for a stream.
+// Entries are keyed by timestamp; data holds the JSON state, and writer the
+// historian instance that wrote it.
+func streamTableStatements(name string, hypertable bool) []string {
+	table := pq.QuoteIdentifier(name)
+	res := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			timestamp TIMESTAMPTZ PRIMARY KEY,
+			type SMALLINT NOT NULL,
+			data JSONB
+		)`, table),
+		// Added after the first release, see historian.EntryMeta.
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS writer TEXT`, table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (type, timestamp)`, pq.QuoteIdentifier(identifier(name+"_type_timestamp")), table),
+		fmt.Sprintf(`DROP TRIGGER IF EXISTS historian_notify ON %s`, table),
+		fmt.Sprintf(`CREATE TRIGGER historian_notify AFTER INSERT OR UPDATE OR DELETE ON %s
+			FOR EACH ROW EXECUTE PROCEDURE historian_notify_entry()`, table),
+	}
+	if hypertable {
+		res = append(res, fmt.Sprintf(`SELECT create_hypertable(%s, 'timestamp', if_not_exists => TRUE)`, pq.QuoteLiteral(name)))
+	}
+	return res
+}
+
+// Statements dropping or archiving the entry table of a deleted stream.
+// Archived tables no longer send notifications.
+func dropStreamTableStatements(name string, policy historian.TeardownPolicy, archiveName string) []string {
+	table := pq.QuoteIdentifier(name)
+	res := []string{
+		fmt.Sprintf(`DELETE FROM stream_sequences WHERE stream = %s`, pq.QuoteLiteral(name)),
+	}
+	if policy == historian.TeardownArchive {
+		return append(res,
+			fmt.Sprintf(`DROP TRIGGER IF EXISTS historian_notify ON %s`, table),
+			fmt.Sprintf(`ALTER TABLE IF EXISTS %s RENAME TO %s`, table, pq.QuoteIdentifier(identifier(archiveName))),
+		)
+	}
+	return append(res, fmt.Sprintf(`DROP TABLE IF EXISTS %s`, table))
+}
@@ -0,0 +1,316 @@
+package postgres
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/feed"
+	"github.com/fuserobotics/statestream"
+	"github.com/lib/pq"
+)
+
+// Entries of a single stream.
+type table struct {
+	b    *Backend
+	name string
+	hub  feed.Hub
+
+	// Quoted table name for queries.
+	ident string
+}
+
+func newTable(b *Backend, name string) *table {
+	return &table{
+		b:     b,
+		name:  name,
+		ident: pq.QuoteIdentifier(name),
+	}
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Scan an entry, followed by any extra columns into extra.
+func scanEntry(row rowScanner, extra ...interface{}) (*stream.StreamEntry, error) {
+	var data []byte
+	entry := &stream.StreamEntry{}
+	dest := append([]interface{}{&entry.Timestamp, &entry.Type, &data}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &entry.Data); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+// Scan and close rows of entries.
+func scanEntries(rows *sql.Rows) ([]*stream.StreamEntry, error) {
+	defer rows.Close()
+
+	var entries []*stream.StreamEntry
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// PostgreSQL stores times with microsecond precision, rounding finer ones.
+// Times written are truncated instead, and query bounds are adjusted so that
+// comparisons hold as they would at full precision.
+func storedTime(ts time.Time) time.Time {
+	return ts.Truncate(time.Microsecond)
+}
+
+// The earliest stored time not before ts, for exclusive upper bounds.
+func upperBound(ts time.Time) time.Time {
+	if stored := storedTime(ts); !stored.Equal(ts) {
+		return stored.Add(time.Microsecond)
+	}
+	return ts
+}
+
+func (t *table) queryEntry(query string, args ...interface{}) (*stream.StreamEntry, error) {
+	entry, err := scanEntry(t.b.db.QueryRow(fmt.Sprintf(query, t.ident), args...))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return entry, err
+}
+
+// Retrieve the latest snapshot before timestamp. Return nil for no data.
+func (t *table) GetSnapshotBefore(timestamp time.Time) (*stream.StreamEntry, error) {
+	return t.queryEntry(`SELECT timestamp, type, data FROM %s
+		WHERE type = $1 AND timestamp < $2 ORDER BY timestamp DESC LIMIT 1`,
+		int(stream.StreamEntrySnapshot), upperBound(timestamp))
+}
+
+// Retrieve the earliest entry after timestamp. Return nil for no data.
+func (t *table) GetEntryAfter(timestamp time.Time, filterType stream.StreamEntryType) (*stream.StreamEntry, error) {
+	if filterType == stream.StreamEntryAny {
+		return t.queryEntry(`SELECT timestamp, type, data FROM %s
+			WHERE timestamp > $1 ORDER BY timestamp ASC LIMIT 1`, storedTime(timestamp))
+	}
+	return t.queryEntry(`SELECT timestamp, type, data FROM %s
+		WHERE type = $1 AND timestamp > $2 ORDER BY timestamp ASC LIMIT 1`,
+		int(filterType), storedTime(timestamp))
+}
+
+// Retrieve up to limit entries in (timestamp, until], in timestamp order.
+func (t *table) GetEntriesAfter(timestamp, until time.Time, filterType stream.StreamEntryType, limit int) ([]*stream.StreamEntry, error) {
+	var rows *sql.Rows
+	var err error
+	if filterType == stream.StreamEntryAny {
+		rows, err = t.b.db.Query(fmt.Sprintf(`SELECT timestamp, type, data FROM %s
+			WHERE timestamp > $1 AND timestamp <= $2 ORDER BY timestamp ASC LIMIT $3`, t.ident),
+			storedTime(timestamp), storedTime(until), limit)
+	} else {
+		rows, err = t.b.db.Query(fmt.Sprintf(`SELECT timestamp, type, data FROM %s
+			WHERE type = $1 AND timestamp > $2 AND timestamp <= $3 ORDER BY timestamp ASC LIMIT $4`, t.ident),
+			int(filterType), storedTime(timestamp), storedTime(until), limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+// Retrieve up to limit entries before timestamp, newest first.
+func (t *table) GetEntriesBefore(timestamp time.Time, limit int) ([]*stream.StreamEntry, error) {
+	rows, err := t.b.db.Query(fmt.Sprintf(`SELECT timestamp, type, data FROM %s
+		WHERE timestamp < $1 ORDER BY timestamp DESC LIMIT $2`, t.ident), upperBound(timestamp), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+// Store a stream entry.
+func (t *table) SaveEntry(entry *stream.StreamEntry) error {
+	data, err := json.Marshal(entry.Data)
+	if err != nil {
+		return err
+	}
+	_, err = t.b.db.Exec(fmt.Sprintf(`INSERT INTO %s (timestamp, type, data) VALUES ($1, $2, $3)`, t.ident),
+		storedTime(entry.Timestamp), int(entry.Type), string(data))
+	return err
+}
+
+// The writer of an entry as stored, NULL if unknown.
+func writerColumn(meta *historian.EntryMeta) interface{} {
+	if writer := meta.GetWriter(); writer != "" {
+		return writer
+	}
+	return nil
+}
+
+// Insert entries within tx, skipping any whose timestamp is taken.
+// Returns one error per entry.
+func (t *table) insert(tx *sql.Tx, writes []*historian.EntryWrite) ([]error, error) {
+	var values bytes.Buffer
+	args := make([]interface{}, 0, len(writes)*4)
+	for i, write := range writes {
+		entry := write.Entry
+		data, err := json.Marshal(entry.Data)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			values.WriteString(", ")
+		}
+		fmt.Fprintf(&values, "($%d, $%d, $%d, $%d)", len(args)+1, len(args)+2, len(args)+3, len(args)+4)
+		args = append(args, storedTime(entry.Timestamp), int(entry.Type), string(data), writerColumn(write.Meta))
+	}
+
+	rows, err := tx.Query(fmt.Sprintf(`INSERT INTO %s (timestamp, type, data, writer) VALUES %s
+		ON CONFLICT (timestamp) DO NOTHING RETURNING timestamp`, t.ident, values.String()), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	inserted := make(map[int64]int)
+	for rows.Next() {
+		var ts time.Time
+		if err := rows.Scan(&ts); err != nil {
+			return nil, err
+		}
+		inserted[storedTime(ts).UnixNano()]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(writes))
+	for i, write := range writes {
+		key := storedTime(write.Entry.Timestamp).UnixNano()
+		if inserted[key] > 0 {
+			inserted[key]--
+		} else {
+			errs[i] = errors.New("Duplicate entry timestamp.")
+		}
+	}
+	return errs, nil
+}
+
+// Insert an entry within tx and raise the stream's sequence to that of the
+// write, leaving neither written if the sequence is not lower. Returns the
+// error of the write, and any error that aborts tx.
+func (t *table) insertSequenced(tx *sql.Tx, write *historian.EntryWrite) (error, error) {
+	seq := write.Meta.Sequence
+	if _, err := tx.Exec(`SAVEPOINT sequenced`); err != nil {
+		return nil, err
+	}
+	errs, err := t.insert(tx, []*historian.EntryWrite{write})
+	if err != nil {
+		return nil, err
+	}
+	if errs[0] != nil {
+		return errs[0], nil
+	}
+	// Concurrent writers block on the row and re-check the condition.
+	res, err := tx.Exec(`INSERT INTO stream_sequences (stream, seq) VALUES ($1, $2::numeric)
+		ON CONFLICT (stream) DO UPDATE SET seq = EXCLUDED.seq
+		WHERE stream_sequences.seq < EXCLUDED.seq`, t.name, strconv.FormatUint(seq, 10))
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT sequenced`); err != nil {
+			return nil, err
+		}
+		return historian.ErrDuplicateSequence, nil
+	}
+	_, err = tx.Exec(`RELEASE SAVEPOINT sequenced`)
+	return nil, err
+}
+
+// Retrieve the highest sequence number committed with an entry, 0 if none.
+func (t *table) GetSequence() (uint64, error) {
+	var seq string
+	err := t.b.db.QueryRow(`SELECT seq::text FROM stream_sequences WHERE stream = $1`, t.name).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(seq, 10, 64)
+}
+
+// Amend an old entry
+func (t *table) AmendEntry(entry *stream.StreamEntry, oldTimestamp time.Time) error {
+	return t.amendEntry(entry, oldTimestamp, nil)
+}
+
+// Amend an old entry, storing the writer if meta is set.
+func (t *table) amendEntry(entry *stream.StreamEntry, oldTimestamp time.Time, meta *historian.EntryMeta) error {
+	data, err := json.Marshal(entry.Data)
+	if err != nil {
+		return err
+	}
+	res, err := t.b.db.Exec(fmt.Sprintf(`UPDATE %s SET timestamp = $1, type = $2, data = $3, writer = $4 WHERE timestamp = $5`, t.ident),
+		storedTime(entry.Timestamp), int(entry.Type), string(data), writerColumn(meta), storedTime(oldTimestamp))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("Entry to amend not found.")
+	}
+	return nil
+}
+
+// Delete the entries in (after, before).
+func (t *table) DeleteEntries(after, before time.Time) error {
+	_, err := t.b.db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE timestamp > $1 AND timestamp < $2`, t.ident), storedTime(after), upperBound(before))
+	return err
+}
+
+// Watch for entries written by anyone from now on.
+func (t *table) WatchEntries() (historian.StreamEntryChangeFeed, error) {
+	return t.hub.WatchEntries(), nil
+}
+
+// Load the row a notification refers to and publish it.
+// Old values aren't sent with notifications, so amended and deleted entries
+// carry an old value with just the timestamp. Rows written by the local
+// instance are skipped without loading them.
+func (t *table) handleNotification(noti *notification) {
+	if noti.Op != "DELETE" && noti.Writer != "" && noti.Writer == t.b.InstanceId {
+		return
+	}
+	change := &historian.StreamEntryChange{}
+	if noti.Op != "INSERT" {
+		change.OldValue = &stream.StreamEntry{Timestamp: noti.Timestamp}
+	}
+	if noti.Op == "DELETE" {
+		t.hub.Publish(change)
+		return
+	}
+	row := t.b.db.QueryRow(fmt.Sprintf(`SELECT timestamp, type, data, COALESCE(writer, '') FROM %s WHERE timestamp = $1`, t.ident), noti.Timestamp)
+	entry, err := scanEntry(row, &change.Writer)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		t.hub.Fail(err)
+		return
+	}
+	change.NewValue = entry
+	t.hub.Publish(change)
+}
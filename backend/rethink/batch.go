@@ -0,0 +1,114 @@
+package rethink
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	r "gopkg.in/dancannon/gorethink.v2"
+)
+
+// Result of an insert with return_changes.
+type insertResponse struct {
+	Errors     int    `gorethink:"errors"`
+	FirstError string `gorethink:"first_error"`
+	Changes    []struct {
+		NewValue *storedEntry `gorethink:"new_val"`
+	} `gorethink:"changes"`
+}
+
+type insertGroup struct {
+	table   *streamBackend
+	indexes []int
+	entries []*storedEntry
+}
+
+// Insert entries into any number of stream tables in one query.
+func (b *Backend) SaveEntries(writes []*historian.EntryWrite) []error {
+	errs := make([]error, len(writes))
+
+	var groups []*insertGroup
+	byTable := make(map[*streamBackend]*insertGroup)
+	for i, write := range writes {
+		table, ok := write.Storage.(*streamBackend)
+		if !ok || table.b != b {
+			errs[i] = errors.New("Stream storage not opened from this backend.")
+			continue
+		}
+		// Sequenced writes can't share a query, see insertSequenced.
+		if write.Meta != nil && write.Meta.Sequence != 0 {
+			errs[i] = table.insertSequenced(write)
+			continue
+		}
+		group, ok := byTable[table]
+		if !ok {
+			group = &insertGroup{table: table}
+			byTable[table] = group
+			groups = append(groups, group)
+		}
+		group.indexes = append(group.indexes, i)
+		group.entries = append(group.entries, newStoredEntry(write.Entry, write.Meta))
+	}
+	if len(groups) == 0 {
+		return errs
+	}
+
+	queries := make([]interface{}, len(groups))
+	for i, group := range groups {
+		queries[i] = group.table.dataTable.Insert(group.entries, r.InsertOpts{ReturnChanges: true})
+	}
+
+	var responses []*insertResponse
+	cursor, err := r.Expr(queries).Run(b.rctx)
+	if err == nil {
+		err = cursor.All(&responses)
+		cursor.Close()
+	}
+	if err == nil && len(responses) != len(groups) {
+		err = errors.New("Unexpected number of insert responses.")
+	}
+	if err != nil {
+		for _, group := range groups {
+			for _, idx := range group.indexes {
+				errs[idx] = err
+			}
+		}
+		return errs
+	}
+
+	for i, group := range groups {
+		res := responses[i]
+		if res.Errors == 0 {
+			continue
+		}
+		// Entries without a change in the response failed.
+		for j, entry := range group.entries {
+			inserted := false
+			for _, change := range res.Changes {
+				if change.NewValue != nil && sameTime(change.NewValue.Timestamp, entry.Timestamp) {
+					inserted = true
+					break
+				}
+			}
+			if !inserted {
+				errs[group.indexes[j]] = errors.New(res.FirstError)
+			}
+		}
+	}
+	return errs
+}
+
+// Amend an entry, storing the writer with it.
+func (b *Backend) AmendEntry(write *historian.EntryWrite, oldTimestamp time.Time) error {
+	table, ok := write.Storage.(*streamBackend)
+	if !ok || table.b != b {
+		return errors.New("Stream storage not opened from this backend.")
+	}
+	return table.amendEntry(write.Entry, oldTimestamp, write.Meta)
+}
+
+// RethinkDB stores times with millisecond precision.
+func sameTime(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d < time.Millisecond && d > -time.Millisecond
+}
@@ -0,0 +1,151 @@
+package rethink
+
+import (
+	"strings"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/dbproto"
+	r "gopkg.in/dancannon/gorethink.v2"
+)
+
+const (
+	templateTableName string = "templates"
+	deviceTableName   string = "devices"
+)
+
+// Wrapper for a change to the templates or devices table, see deviceChanges.
+type deviceChange struct {
+	NewTemplate *dbproto.StreamTemplate `gorethink:"new_template,omitempty"`
+	OldTemplate *dbproto.StreamTemplate `gorethink:"old_template,omitempty"`
+	NewDevice   *dbproto.Device         `gorethink:"new_device,omitempty"`
+	OldDevice   *dbproto.Device         `gorethink:"old_device,omitempty"`
+	State       string                  `gorethink:"state,omitempty"`
+}
+
+// Insert or replace a stream template.
+func (b *Backend) PutTemplate(data *dbproto.StreamTemplate) error {
+	_, err := b.TemplatesTable.Insert(data, r.InsertOpts{Conflict: "replace"}).RunWrite(b.rctx)
+	return err
+}
+
+// Delete a stream template.
+func (b *Backend) DeleteTemplate(id string) error {
+	_, err := b.TemplatesTable.Get(id).Delete().RunWrite(b.rctx)
+	return err
+}
+
+// Insert or replace a device record.
+func (b *Backend) PutDevice(data *dbproto.Device) error {
+	_, err := b.DevicesTable.Insert(data, r.InsertOpts{Conflict: "replace"}).RunWrite(b.rctx)
+	return err
+}
+
+// Record a stream registered on a device, atomically within its document.
+func (b *Backend) AddDeviceStream(deviceId string, stream *dbproto.DeviceStream) (bool, error) {
+	res, err := b.DevicesTable.Get(deviceId).Update(func(dev r.Term) interface{} {
+		registered := dev.Field("registered").Default([]interface{}{})
+		return r.Branch(
+			registered.Contains(stream),
+			map[string]interface{}{},
+			map[string]interface{}{"registered": registered.Append(stream)},
+		)
+	}).RunWrite(b.rctx)
+	if err != nil {
+		return false, err
+	}
+	return res.Replaced > 0, nil
+}
+
+// Delete a device record.
+func (b *Backend) DeleteDevice(id string) error {
+	_, err := b.DevicesTable.Get(id).Delete().RunWrite(b.rctx)
+	return err
+}
+
+func (b *Backend) ensureTable(name string) error {
+	exists, err := b.tableExists(name)
+	if err != nil || exists {
+		return err
+	}
+	_, err = r.TableCreate(name).RunWrite(b.rctx)
+	// Another historian may have beaten us to it.
+	if err != nil && !strings.Contains(err.Error(), "already exists") {
+		return err
+	}
+	return nil
+}
+
+// Changefeed on a table, renaming new_val and old_val to new_<kind> and
+// old_<kind> so changes to both tables decode into a deviceChange.
+func deviceChanges(table r.Term, kind string) r.Term {
+	return table.Changes(r.ChangesOpts{
+		IncludeInitial: true,
+		IncludeStates:  true,
+	}).Map(func(cha r.Term) interface{} {
+		return map[string]interface{}{
+			"new_" + kind: cha.Field("new_val").Default(nil),
+			"old_" + kind: cha.Field("old_val").Default(nil),
+			"state":       cha.Field("state").Default(""),
+		}
+	})
+}
+
+// Loads all templates and devices and keeps listening for changes,
+// creating the tables if necessary.
+func (b *Backend) WatchDevices() (templates []*dbproto.StreamTemplate, devices []*dbproto.Device, feed historian.DeviceChangeFeed, loadError error) {
+	for _, name := range []string{templateTableName, deviceTableName} {
+		if err := b.ensureTable(name); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	cursor, err := deviceChanges(b.TemplatesTable, "template").
+		Union(deviceChanges(b.DevicesTable, "device")).
+		Run(b.rctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer func() {
+		if loadError != nil {
+			cursor.Close()
+		}
+	}()
+
+	initialTemplates := make(map[string]*dbproto.StreamTemplate)
+	initialDevices := make(map[string]*dbproto.Device)
+	// Each of the two feeds reports when it is ready.
+	ready := 0
+	cha := &deviceChange{}
+	for ready < 2 && cursor.Next(cha) {
+		switch cha.State {
+		case "ready":
+			ready++
+		case "":
+			if cha.OldTemplate != nil {
+				delete(initialTemplates, cha.OldTemplate.Id)
+			}
+			if cha.NewTemplate != nil {
+				initialTemplates[cha.NewTemplate.Id] = cha.NewTemplate
+			}
+			if cha.OldDevice != nil {
+				delete(initialDevices, cha.OldDevice.Id)
+			}
+			if cha.NewDevice != nil {
+				initialDevices[cha.NewDevice.Id] = cha.NewDevice
+			}
+		}
+		cha = &deviceChange{}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	for _, tmpl := range initialTemplates {
+		templates = append(templates, tmpl)
+	}
+	for _, dev := range initialDevices {
+		devices = append(devices, dev)
+	}
+	return templates, devices, newDeviceChangeFeed(cursor), nil
+}
@@ -0,0 +1,152 @@
+package rethink
+
+import (
+	"github.com/fuserobotics/historian"
+	r "gopkg.in/dancannon/gorethink.v2"
+)
+
+// Adapts a RethinkDB changefeed on the streams table.
+type streamChangeFeed struct {
+	cursor  *r.Cursor
+	changes chan *historian.StreamChange
+	done    chan bool
+}
+
+func newStreamChangeFeed(cursor *r.Cursor) *streamChangeFeed {
+	f := &streamChangeFeed{
+		cursor:  cursor,
+		changes: make(chan *historian.StreamChange),
+		done:    make(chan bool),
+	}
+	go f.listen()
+	return f
+}
+
+func (f *streamChangeFeed) listen() {
+	defer close(f.changes)
+
+	changesChan := make(chan streamChange)
+	f.cursor.Listen(changesChan)
+	for cha := range changesChan {
+		if cha.State != "" {
+			continue
+		}
+		select {
+		case f.changes <- &historian.StreamChange{NewValue: cha.NewValue, OldValue: cha.OldValue}:
+		case <-f.done:
+			return
+		}
+	}
+}
+
+func (f *streamChangeFeed) Changes() <-chan *historian.StreamChange {
+	return f.changes
+}
+
+func (f *streamChangeFeed) Err() error {
+	return f.cursor.Err()
+}
+
+func (f *streamChangeFeed) Close() error {
+	close(f.done)
+	return f.cursor.Close()
+}
+
+// Adapts a RethinkDB changefeed on a stream entry table.
+type streamEntryChangeFeed struct {
+	cursor  *r.Cursor
+	changes chan *historian.StreamEntryChange
+	done    chan bool
+}
+
+func newStreamEntryChangeFeed(cursor *r.Cursor) *streamEntryChangeFeed {
+	f := &streamEntryChangeFeed{
+		cursor:  cursor,
+		changes: make(chan *historian.StreamEntryChange),
+		done:    make(chan bool),
+	}
+	go f.listen()
+	return f
+}
+
+func (f *streamEntryChangeFeed) listen() {
+	defer close(f.changes)
+
+	changesChan := make(chan streamEntryChange)
+	f.cursor.Listen(changesChan)
+	for cha := range changesChan {
+		if cha.State != "" {
+			continue
+		}
+		select {
+		case f.changes <- cha.streamEntryChange():
+		case <-f.done:
+			return
+		}
+	}
+}
+
+func (f *streamEntryChangeFeed) Changes() <-chan *historian.StreamEntryChange {
+	return f.changes
+}
+
+func (f *streamEntryChangeFeed) Err() error {
+	return f.cursor.Err()
+}
+
+func (f *streamEntryChangeFeed) Close() error {
+	close(f.done)
+	return f.cursor.Close()
+}
+
+// Adapts the RethinkDB changefeeds on the templates and devices tables.
+type deviceChangeFeed struct {
+	cursor  *r.Cursor
+	changes chan *historian.DeviceChange
+	done    chan bool
+}
+
+func newDeviceChangeFeed(cursor *r.Cursor) *deviceChangeFeed {
+	f := &deviceChangeFeed{
+		cursor:  cursor,
+		changes: make(chan *historian.DeviceChange),
+		done:    make(chan bool),
+	}
+	go f.listen()
+	return f
+}
+
+func (f *deviceChangeFeed) listen() {
+	defer close(f.changes)
+
+	changesChan := make(chan deviceChange)
+	f.cursor.Listen(changesChan)
+	for cha := range changesChan {
+		if cha.State != "" {
+			continue
+		}
+		select {
+		case f.changes <- &historian.DeviceChange{
+			NewTemplate: cha.NewTemplate,
+			OldTemplate: cha.OldTemplate,
+			NewDevice:   cha.NewDevice,
+			OldDevice:   cha.OldDevice,
+		}:
+		case <-f.done:
+			return
+		}
+	}
+}
+
+func (f *deviceChangeFeed) Changes() <-chan *historian.DeviceChange {
+	return f.changes
+}
+
+func (f *deviceChangeFeed) Err() error {
+	return f.cursor.Err()
+}
+
+func (f *deviceChangeFeed) Close() error {
+	close(f.done)
+	return f.cursor.Close()
+}
@@ -0,0 +1,107 @@
+package rethink
+
+import (
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/golang/glog"
+	r "gopkg.in/dancannon/gorethink.v2"
+)
+
+const (
+	// Stream tables are keyed by timestamp, so the primary index serves
+	// ordered range queries over all entries.
+	timestampIndex = "timestamp"
+	// Compound secondary index on [type, timestamp].
+	typeTimestampIndex = "type_timestamp"
+)
+
+// Secondary indexes every stream table needs.
+var streamTableIndexes = map[string]func(row r.Term) interface{}{
+	typeTimestampIndex: func(row r.Term) interface{} {
+		return []interface{}{row.Field("type"), row.Field("timestamp")}
+	},
+}
+
+// Secondary indexes earlier versions created that are no longer used.
+var obsoleteStreamIndexes = []string{"entry_timestamp"}
+
+// Create any missing indexes on a stream table and wait for them to be
+// ready, and drop obsolete ones.
+func (b *Backend) EnsureStreamIndexes(data *dbproto.Stream) error {
+	table := r.Table(historian.DbStreamTableName(data))
+
+	var existing []string
+	cursor, err := table.IndexList().Run(b.rctx)
+	if err != nil {
+		return err
+	}
+	err = cursor.All(&existing)
+	cursor.Close()
+	if err != nil {
+		return err
+	}
+
+	have := make(map[string]bool)
+	for _, name := range existing {
+		have[name] = true
+	}
+
+	for _, name := range obsoleteStreamIndexes {
+		if !have[name] {
+			continue
+		}
+		glog.Infof("Dropping index %s on %s.", name, historian.DbStreamTableName(data))
+		if _, err := table.IndexDrop(name).RunWrite(b.rctx); err != nil {
+			return err
+		}
+	}
+
+	created := false
+	for name, fn := range streamTableIndexes {
+		if have[name] {
+			continue
+		}
+		glog.Infof("Creating index %s on %s.", name, historian.DbStreamTableName(data))
+		if _, err := table.IndexCreateFunc(name, fn).RunWrite(b.rctx); err != nil {
+			return err
+		}
+		created = true
+	}
+
+	if created {
+		if _, err := table.IndexWait().Run(b.rctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Migrate the database: create the indexes required by the queries in this
+// package on every existing stream table. Streams whose table hasn't been
+// created yet are skipped, CreateStream indexes them.
+func (b *Backend) Migrate() error {
+	cursor, err := b.StreamsTable.Run(b.rctx)
+	if err != nil {
+		return err
+	}
+	var streams []*dbproto.Stream
+	err = cursor.All(&streams)
+	cursor.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, data := range streams {
+		exists, err := b.tableExists(historian.DbStreamTableName(data))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err := b.EnsureStreamIndexes(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package rethink
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/dbproto"
+	r "gopkg.in/dancannon/gorethink.v2"
+)
+
+func (b *Backend) tableExists(name string) (bool, error) {
+	cursor, err := r.TableList().Contains(name).Run(b.rctx)
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	err = cursor.One(&exists)
+	return exists, err
+}
+
+// Store a stream definition in the streams table unless one with the same
+// ID exists.
+func (b *Backend) InsertStream(data *dbproto.Stream) (bool, error) {
+	data, err := historian.StreamWithId(data)
+	if err != nil {
+		return false, err
+	}
+	res, err := b.StreamsTable.Insert(data).RunWrite(b.rctx)
+	// Another historian may have beaten us to it.
+	if err != nil && !strings.Contains(err.Error(), "Duplicate primary key") {
+		return false, err
+	}
+	return res.Inserted > 0, nil
+}
+
+// Create the table for a stream, keyed by timestamp, with its indexes.
+func (b *Backend) CreateStream(data *dbproto.Stream) error {
+	name := historian.DbStreamTableName(data)
+	exists, err := b.tableExists(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		_, err := r.TableCreate(name, r.TableCreateOpts{PrimaryKey: "timestamp"}).RunWrite(b.rctx)
+		// Another historian may have beaten us to it.
+		if err != nil && !strings.Contains(err.Error(), "already exists") {
+			return err
+		}
+	}
+	return b.EnsureStreamIndexes(data)
+}
+
+// Drop the table of a deleted stream, or rename it out of the way.
+func (b *Backend) DropStream(data *dbproto.Stream, policy historian.TeardownPolicy) error {
+	if policy == historian.TeardownKeep {
+		return nil
+	}
+
+	name := historian.DbStreamTableName(data)
+	_, err := b.SequencesTable.Get(name).Delete().RunWrite(b.rctx)
+	if err != nil && !strings.Contains(err.Error(), "does not exist") {
+		return err
+	}
+	exists, err := b.tableExists(name)
+	if err != nil || !exists {
+		return err
+	}
+
+	if policy == historian.TeardownArchive {
+		_, err = r.Table(name).Config().Update(map[string]interface{}{
+			"name": historian.ArchiveStreamTableName(data, time.Now()),
+		}).RunWrite(b.rctx)
+	} else {
+		_, err = r.TableDrop(name).RunWrite(b.rctx)
+	}
+	if err != nil && strings.Contains(err.Error(), "does not exist") {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package rethink
+
+import (
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/dbproto"
+	r "gopkg.in/dancannon/gorethink.v2"
+)
+
+const streamTableName string = "streams"
+
+// Committed sequence of each stream, keyed by the stream table name.
+const sequenceTableName string = "sequences"
+
+// Wrapper for response from RethinkDB with stream change
+type streamChange struct {
+	NewValue *dbproto.Stream `gorethink:"new_val,omitempty"`
+	OldValue *dbproto.Stream `gorethink:"old_val,omitempty"`
+	State    string          `gorethink:"state,omitempty"`
+}
+
+// RethinkDB storage backend, one table per stream.
+type Backend struct {
+	rctx *r.Session
+
+	StreamsTable   r.Term
+	TemplatesTable r.Term
+	DevicesTable   r.Term
+	SequencesTable r.Term
+}
+
+func NewBackend(rctx *r.Session) *Backend {
+	return &Backend{
+		rctx:           rctx,
+		StreamsTable:   r.Table(streamTableName),
+		TemplatesTable: r.Table(templateTableName),
+		DevicesTable:   r.Table(deviceTableName),
+		SequencesTable: r.Table(sequenceTableName),
+	}
+}
+
+// Loads all streams from the streams table and keeps listening for changes.
+func (b *Backend) WatchStreams() (streams []*dbproto.Stream, feed historian.StreamChangeFeed, loadError error) {
+	cursor, err := b.StreamsTable.Changes(r.ChangesOpts{
+		IncludeInitial: true,
+		IncludeStates:  true,
+	}).Run(b.rctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if loadError != nil {
+			cursor.Close()
+		}
+	}()
+
+	initial := make(map[string]*dbproto.Stream)
+	strm := &streamChange{}
+	for cursor.Next(strm) {
+		if strm.State == "ready" {
+			break
+		}
+		if strm.State == "" {
+			if strm.OldValue != nil {
+				delete(initial, strm.OldValue.Id)
+			}
+			if strm.NewValue != nil {
+				initial[strm.NewValue.Id] = strm.NewValue
+			}
+		}
+		strm = &streamChange{}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	for _, str := range initial {
+		streams = append(streams, str)
+	}
+	return streams, newStreamChangeFeed(cursor), nil
+}
+
+// Open the entry table for a stream, creating any missing indexes and the
+// sequences table if necessary.
+func (b *Backend) OpenStream(data *dbproto.Stream) (historian.StreamBackend, error) {
+	if err := b.EnsureStreamIndexes(data); err != nil {
+		return nil, err
+	}
+	if err := b.ensureTable(sequenceTableName); err != nil {
+		return nil, err
+	}
+	name := historian.DbStreamTableName(data)
+	return &streamBackend{
+		b:         b,
+		name:      name,
+		dataTable: r.Table(name),
+	}, nil
+}
@@ -0,0 +1,251 @@
+package rethink
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/statestream"
+	"github.com/golang/glog"
+	r "gopkg.in/dancannon/gorethink.v2"
+)
+
+type streamEntryChange struct {
+	NewValue *storedEntry `gorethink:"new_val,omitempty"`
+	OldValue *storedEntry `gorethink:"old_val,omitempty"`
+	State    string       `gorethink:"state,omitempty"`
+}
+
+// An entry as stored, with the writer beside its state.
+type storedEntry struct {
+	Type      stream.StreamEntryType `gorethink:"type"`
+	Data      stream.StateData       `gorethink:"data"`
+	Timestamp time.Time              `gorethink:"timestamp"`
+	Writer    string                 `gorethink:"writer,omitempty"`
+}
+
+func newStoredEntry(entry *stream.StreamEntry, meta *historian.EntryMeta) *storedEntry {
+	return &storedEntry{
+		Type:      entry.Type,
+		Data:      entry.Data,
+		Timestamp: entry.Timestamp,
+		Writer:    meta.GetWriter(),
+	}
+}
+
+func (e *storedEntry) entry() *stream.StreamEntry {
+	if e == nil {
+		return nil
+	}
+	return &stream.StreamEntry{Type: e.Type, Data: e.Data, Timestamp: e.Timestamp}
+}
+
+func (c *streamEntryChange) streamEntryChange() *historian.StreamEntryChange {
+	change := &historian.StreamEntryChange{
+		NewValue: c.NewValue.entry(),
+		OldValue: c.OldValue.entry(),
+	}
+	if c.NewValue != nil {
+		change.Writer = c.NewValue.Writer
+	}
+	return change
+}
+
+// Entry storage for a stream, backed by its own table.
+type streamBackend struct {
+	b         *Backend
+	name      string
+	dataTable r.Term
+}
+
+// Retrieve the latest snapshot before timestamp. Return nil for no data.
+func (s *streamBackend) GetSnapshotBefore(timestamp time.Time) (*stream.StreamEntry, error) {
+	snapshot := int(stream.StreamEntrySnapshot)
+	query := s.dataTable.Between(
+		[]interface{}{snapshot, r.MinVal},
+		[]interface{}{snapshot, timestamp},
+		r.BetweenOpts{Index: typeTimestampIndex},
+	).OrderBy(r.OrderByOpts{Index: r.Desc(typeTimestampIndex)}).Limit(1)
+	return s.queryEntry(query)
+}
+
+// Retrieve the earliest entry after timestamp. Return nil for no data.
+func (s *streamBackend) GetEntryAfter(timestamp time.Time, filterType stream.StreamEntryType) (*stream.StreamEntry, error) {
+	var query r.Term
+	if filterType == stream.StreamEntryAny {
+		query = s.dataTable.Between(
+			timestamp,
+			r.MaxVal,
+			r.BetweenOpts{Index: timestampIndex, LeftBound: "open"},
+		).OrderBy(r.OrderByOpts{Index: timestampIndex})
+	} else {
+		query = s.dataTable.Between(
+			[]interface{}{int(filterType), timestamp},
+			[]interface{}{int(filterType), r.MaxVal},
+			r.BetweenOpts{Index: typeTimestampIndex, LeftBound: "open"},
+		).OrderBy(r.OrderByOpts{Index: typeTimestampIndex})
+	}
+	return s.queryEntry(query.Limit(1))
+}
+
+// Retrieve up to limit entries in (timestamp, until], in timestamp order.
+func (s *streamBackend) GetEntriesAfter(timestamp, until time.Time, filterType stream.StreamEntryType, limit int) ([]*stream.StreamEntry, error) {
+	var query r.Term
+	opts := r.BetweenOpts{LeftBound: "open", RightBound: "closed"}
+	if filterType == stream.StreamEntryAny {
+		opts.Index = timestampIndex
+		query = s.dataTable.Between(timestamp, until, opts).
+			OrderBy(r.OrderByOpts{Index: timestampIndex})
+	} else {
+		opts.Index = typeTimestampIndex
+		query = s.dataTable.Between(
+			[]interface{}{int(filterType), timestamp},
+			[]interface{}{int(filterType), until},
+			opts,
+		).OrderBy(r.OrderByOpts{Index: typeTimestampIndex})
+	}
+
+	return s.queryEntries(query.Limit(limit))
+}
+
+// Retrieve up to limit entries before timestamp, newest first.
+func (s *streamBackend) GetEntriesBefore(timestamp time.Time, limit int) ([]*stream.StreamEntry, error) {
+	query := s.dataTable.Between(r.MinVal, timestamp, r.BetweenOpts{Index: timestampIndex}).
+		OrderBy(r.OrderByOpts{Index: r.Desc(timestampIndex)}).
+		Limit(limit)
+	return s.queryEntries(query)
+}
+
+// Run a query for a list of entries.
+func (s *streamBackend) queryEntries(query r.Term) ([]*stream.StreamEntry, error) {
+	cursor, err := query.Run(s.b.rctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var entries []*stream.StreamEntry
+	if err := cursor.All(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Run a query for a single entry. Return nil for no data.
+func (s *streamBackend) queryEntry(query r.Term) (*stream.StreamEntry, error) {
+	cursor, err := query.Run(s.b.rctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	entry := &stream.StreamEntry{}
+	if err := cursor.One(entry); err != nil {
+		if err == r.ErrEmptyResult {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Store a stream entry.
+func (s *streamBackend) SaveEntry(entry *stream.StreamEntry) error {
+	return s.saveEntry(entry, nil)
+}
+
+func (s *streamBackend) saveEntry(entry *stream.StreamEntry, meta *historian.EntryMeta) error {
+	if _, err := s.dataTable.Insert(newStoredEntry(entry, meta)).RunWrite(s.b.rctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Amend an old entry
+func (s *streamBackend) AmendEntry(entry *stream.StreamEntry, oldTimestamp time.Time) error {
+	return s.amendEntry(entry, oldTimestamp, nil)
+}
+
+func (s *streamBackend) amendEntry(entry *stream.StreamEntry, oldTimestamp time.Time, meta *historian.EntryMeta) error {
+	_, err := s.dataTable.Get(oldTimestamp).Replace(newStoredEntry(entry, meta)).RunWrite(s.b.rctx)
+	return err
+}
+
+// Delete the entries in (after, before).
+func (s *streamBackend) DeleteEntries(after, before time.Time) error {
+	_, err := s.dataTable.Between(after, before, r.BetweenOpts{
+		Index:     timestampIndex,
+		LeftBound: "open",
+	}).Delete().RunWrite(s.b.rctx)
+	return err
+}
+
+// Watch the stream table for new entries.
+func (s *streamBackend) WatchEntries() (historian.StreamEntryChangeFeed, error) {
+	cursor, err := s.dataTable.Changes().Run(s.b.rctx)
+	if err != nil {
+		return nil, err
+	}
+	return newStreamEntryChangeFeed(cursor), nil
+}
+
+// Sequences are stored as fixed width hex strings, so string order is
+// numeric order.
+func formatSequence(seq uint64) string {
+	return fmt.Sprintf("%016x", seq)
+}
+
+// Retrieve the highest sequence number committed with an entry, 0 if none.
+func (s *streamBackend) GetSequence() (uint64, error) {
+	cursor, err := s.b.SequencesTable.Get(s.name).Field("seq").Default("").Run(s.b.rctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+
+	var seq string
+	if err := cursor.One(&seq); err != nil || seq == "" {
+		return 0, err
+	}
+	return strconv.ParseUint(seq, 16, 64)
+}
+
+// Raise the sequence of the stream to that of the write and store the entry.
+// RethinkDB has no transactions: the sequence is raised first, atomically
+// within its document, and put back if the entry can't be stored and no
+// later sequence was committed meanwhile.
+func (s *streamBackend) insertSequenced(write *historian.EntryWrite) error {
+	formatted := formatSequence(write.Meta.Sequence)
+	res, err := s.b.SequencesTable.Insert(map[string]interface{}{
+		"id":  s.name,
+		"seq": formatted,
+	}, r.InsertOpts{
+		Conflict: func(id, oldDoc, newDoc r.Term) interface{} {
+			return r.Branch(oldDoc.Field("seq").Lt(newDoc.Field("seq")), newDoc, oldDoc)
+		},
+		ReturnChanges: true,
+	}).RunWrite(s.b.rctx)
+	if err != nil {
+		return err
+	}
+	if res.Inserted+res.Replaced == 0 {
+		return historian.ErrDuplicateSequence
+	}
+
+	saveErr := s.saveEntry(write.Entry, write.Meta)
+	if saveErr == nil {
+		return nil
+	}
+	var previous interface{}
+	if len(res.Changes) != 0 {
+		previous = res.Changes[0].OldValue
+	}
+	_, err = s.b.SequencesTable.Get(s.name).Replace(func(doc r.Term) interface{} {
+		return r.Branch(doc.Field("seq").Eq(formatted), previous, doc)
+	}).RunWrite(s.b.rctx)
+	if err != nil {
+		glog.Warningf("Unable to restore the sequence of %s: %v", s.name, err)
+	}
+	return saveErr
+}
@@ -0,0 +1,167 @@
+package historian
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fuserobotics/statestream"
+	"github.com/golang/glog"
+)
+
+// Periodically compact every stream with a compaction schedule.
+func (h *Historian) compactionThread() {
+	ticker := time.NewTicker(h.CompactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.dispose:
+			return
+		case now := <-ticker.C:
+			h.compactStreams(now)
+		}
+	}
+}
+
+func (h *Historian) compactStreams(now time.Time) {
+	for _, data := range h.GetKnownStreams() {
+		conf := data.Compaction
+		if conf == nil || conf.KeyframeInterval == 0 {
+			continue
+		}
+		str, err := h.GetStream(data.Id)
+		if err != nil {
+			glog.Warningf("Unable to load %s for compaction, %v", data.Id, err)
+			continue
+		}
+		if err := str.compactScheduled(now); err != nil {
+			glog.Warningf("Error compacting %s, %v", data.Id, err)
+		}
+	}
+}
+
+// Compact the history older than the configured minimum age that hasn't been
+// compacted yet.
+func (s *Stream) compactScheduled(now time.Time) error {
+	conf := s.Data.GetCompaction()
+	interval := time.Duration(conf.KeyframeInterval) * time.Millisecond
+	to := now.Add(-time.Duration(conf.MinAge) * time.Millisecond).Truncate(interval)
+
+	from, err := s.compactionHead()
+	if err != nil || from.IsZero() {
+		return err
+	}
+	from = from.Truncate(interval)
+	if !from.Before(to) {
+		return nil
+	}
+
+	if err := s.Compact(from, to, interval); err != nil {
+		return err
+	}
+	s.compactedUntil = to
+	return nil
+}
+
+// Where compaction should resume, zero if the stream is empty. Compaction
+// leaves no mutations behind, so the oldest one marks where the last
+// compaction stopped, whichever instance ran it. Streams of snapshots alone
+// resume where this instance stopped.
+func (s *Stream) compactionHead() (time.Time, error) {
+	first, err := s.storage.GetEntryAfter(time.Time{}, stream.StreamEntryMutation)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if first != nil {
+		return first.Timestamp, nil
+	}
+	if !s.compactedUntil.IsZero() {
+		return s.compactedUntil, nil
+	}
+	first, err = s.storage.GetEntryAfter(time.Time{}, stream.StreamEntryAny)
+	if err != nil || first == nil {
+		return time.Time{}, err
+	}
+	return first.Timestamp, nil
+}
+
+// Rewrite the history between from and to as snapshots every interval,
+// deleting the entries in between. The state at each snapshot is unchanged,
+// changes between snapshots are lost. Snapshots are only written where the
+// state may have changed in the preceding interval.
+//
+// The latest entry in the stream is never touched, so compaction is safe
+// while the stream is being written to.
+func (s *Stream) Compact(from, to time.Time, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("Compaction interval must be positive.")
+	}
+
+	s.maintenanceMtx.Lock()
+	defer s.maintenanceMtx.Unlock()
+
+	// Stay behind the write cursor.
+	latest, err := s.storage.GetEntriesBefore(endOfTime, 1)
+	if err != nil || len(latest) == 0 {
+		return err
+	}
+	if !to.Before(latest[0].Timestamp) {
+		to = latest[0].Timestamp.Add(-time.Nanosecond)
+	}
+
+	glog.Infof("Compacting %s from %v to %v every %v.", s.Data.Id, from, to, interval)
+	var lastKeyframe time.Time
+	for keyframe := from; !keyframe.After(to); keyframe = keyframe.Add(interval) {
+		if !lastKeyframe.IsZero() {
+			changed, err := s.storage.GetEntriesAfter(keyframe.Add(-interval), keyframe, stream.StreamEntryAny, 1)
+			if err != nil {
+				return err
+			}
+			if len(changed) == 0 {
+				continue
+			}
+		}
+
+		written, err := s.writeKeyframe(keyframe)
+		if err != nil {
+			return err
+		}
+		if !written {
+			continue
+		}
+		if !lastKeyframe.IsZero() {
+			if err := s.storage.DeleteEntries(lastKeyframe, keyframe); err != nil {
+				return err
+			}
+		}
+		lastKeyframe = keyframe
+	}
+	return nil
+}
+
+// Write a snapshot of the state at timestamp, replacing any entry there.
+// Returns false if the stream has no state yet at timestamp.
+func (s *Stream) writeKeyframe(timestamp time.Time) (bool, error) {
+	existing, err := s.storage.GetEntriesBefore(timestamp.Add(time.Nanosecond), 1)
+	if err != nil || len(existing) == 0 {
+		return false, err
+	}
+	prev := existing[0]
+	if prev.Timestamp.Equal(timestamp) && prev.Type == stream.StreamEntrySnapshot {
+		return true, nil
+	}
+
+	state, err := s.stateAt(timestamp)
+	if err != nil {
+		return false, err
+	}
+	snapshot := &stream.StreamEntry{
+		Type:      stream.StreamEntrySnapshot,
+		Data:      state,
+		Timestamp: timestamp,
+	}
+	if prev.Timestamp.Equal(timestamp) {
+		return true, s.AmendEntry(snapshot, timestamp)
+	}
+	return true, s.saveEntry(snapshot, nil)
+}
@@ -0,0 +1,63 @@
+package historian
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/statestream"
+)
+
+func TestCompactionHead(t *testing.T) {
+	entry := func(sec int64, entryType stream.StreamEntryType) *stream.StreamEntry {
+		return &stream.StreamEntry{Type: entryType, Timestamp: time.Unix(sec, 0)}
+	}
+
+	tests := []struct {
+		name           string
+		entries        []*stream.StreamEntry
+		compactedUntil int64
+		expected       int64
+	}{
+		{"empty", nil, 0, -1},
+		{"snapshots only", []*stream.StreamEntry{
+			entry(10, stream.StreamEntrySnapshot),
+			entry(20, stream.StreamEntrySnapshot),
+		}, 0, 10},
+		{"snapshots compacted by this instance", []*stream.StreamEntry{
+			entry(10, stream.StreamEntrySnapshot),
+			entry(20, stream.StreamEntrySnapshot),
+		}, 15, 15},
+		// Another instance compacted up to 30, this one never ran.
+		{"compacted elsewhere", []*stream.StreamEntry{
+			entry(10, stream.StreamEntrySnapshot),
+			entry(20, stream.StreamEntrySnapshot),
+			entry(30, stream.StreamEntrySnapshot),
+			entry(35, stream.StreamEntryMutation),
+		}, 0, 35},
+		// Mutations written late behind this instance's compaction.
+		{"mutation behind", []*stream.StreamEntry{
+			entry(10, stream.StreamEntrySnapshot),
+			entry(12, stream.StreamEntryMutation),
+			entry(30, stream.StreamEntrySnapshot),
+		}, 20, 12},
+	}
+	for _, test := range tests {
+		s := &Stream{storage: &sliceStorage{entries: test.entries}}
+		if test.compactedUntil != 0 {
+			s.compactedUntil = time.Unix(test.compactedUntil, 0)
+		}
+		head, err := s.compactionHead()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if test.expected < 0 {
+			if !head.IsZero() {
+				t.Fatalf("%s: expected no head, got %v", test.name, head)
+			}
+			continue
+		}
+		if !head.Equal(time.Unix(test.expected, 0)) {
+			t.Fatalf("%s: expected %d, got %v", test.name, test.expected, head)
+		}
+	}
+}
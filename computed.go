@@ -0,0 +1,86 @@
+package historian
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/historian/expr"
+	"github.com/fuserobotics/statestream"
+	"github.com/golang/glog"
+)
+
+// A computed field with its compiled expression.
+type computedField struct {
+	field      string
+	expression *expr.Expression
+}
+
+// Compile the computed fields of a stream. Fields that don't compile are
+// left out, and reported in the returned error.
+func compileComputedFields(data *dbproto.Stream) ([]*computedField, error) {
+	var res []*computedField
+	var problems []string
+	for _, def := range data.ComputedFields {
+		if def.Field == "" {
+			problems = append(problems, "computed field with no name")
+			continue
+		}
+		expression, err := expr.Compile(def.Expression)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", def.Field, err))
+			continue
+		}
+		res = append(res, &computedField{field: def.Field, expression: expression})
+	}
+	if len(problems) > 0 {
+		return res, fmt.Errorf("Invalid computed fields in %s: %s", data.Id, strings.Join(problems, "; "))
+	}
+	return res, nil
+}
+
+// Set the computed fields of a pushed entry. Mutations are evaluated against
+// the current state with the mutation applied, and only carry computed
+// values that change.
+func applyComputedFields(s *Stream, entry *stream.StreamEntry) (bool, error) {
+	if len(s.computed) == 0 {
+		return true, nil
+	}
+
+	state := map[string]interface{}(entry.Data)
+	var current map[string]interface{}
+	if entry.Type == stream.StreamEntryMutation {
+		writeCursor, err := s.StateStream.WriteCursor()
+		if err != nil {
+			return false, err
+		}
+		err = writeCursor.WriteGuard(func() error {
+			curr, err := writeCursor.State()
+			current = map[string]interface{}(copyState(curr))
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+		state = copyJson(current).(map[string]interface{})
+		mergeJson(state, entry.Data)
+	}
+
+	for _, field := range s.computed {
+		value, err := field.expression.Eval(state)
+		if err != nil {
+			glog.Warningf("Unable to compute %s in %s, %v", field.field, s.Data.Id, err)
+			continue
+		}
+		// Later expressions can use the result.
+		setPath(state, field.field, value)
+		if current != nil {
+			if prev, ok := lookupPath(current, field.field); ok && reflect.DeepEqual(prev, value) {
+				continue
+			}
+		}
+		setPath(entry.Data, field.field, value)
+	}
+	return true, nil
+}
@@ -0,0 +1,171 @@
+package historian
+
+import (
+	"path"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/fuserobotics/historian/dbproto"
+)
+
+// Key in aggregate states holding the lineage of each bound field,
+// keyed by field path. Each lineage has the "sources" the value was taken
+// from and the "timestamp" of the reading in milliseconds.
+const LineageField = "$lineage"
+
+// A reading of a bound field from one source.
+type fieldReading struct {
+	source    string
+	value     interface{}
+	timestamp time.Time
+}
+
+// Current value of an aggregate field.
+type aggregateField struct {
+	value     interface{}
+	timestamp time.Time
+	sources   []string
+	// Priority rank of the source, for PRIORITY.
+	rank int
+	// Current reading of each source, for MEAN and MEDIAN.
+	readings map[string]*fieldReading
+}
+
+// Rank of a source in a priority list, lower is better.
+func priorityRank(priority []string, source string) int {
+	for i, glob := range priority {
+		if matched, _ := path.Match(glob, source); matched {
+			return i
+		}
+	}
+	return len(priority)
+}
+
+// Apply a reading to a bound field according to its conflict policy.
+// Returns true if the aggregate state changed. Rejected readings leave the
+// field untouched.
+func (a *aggregator) resolve(target string, binding *dbproto.FieldBinding, reading *fieldReading) bool {
+	field, ok := a.fields[target]
+	if !ok {
+		field = &aggregateField{readings: make(map[string]*fieldReading)}
+	}
+	window := time.Duration(binding.Window) * time.Millisecond
+
+	rank := field.rank
+	switch binding.Policy {
+	case dbproto.FieldBinding_MEAN, dbproto.FieldBinding_MEDIAN:
+		return a.resolveStatistic(target, binding, field, reading, window)
+	case dbproto.FieldBinding_PRIORITY:
+		rank = priorityRank(binding.Priority, reading.source)
+		if ok && rank > field.rank && (window == 0 || reading.timestamp.Sub(field.timestamp) < window) {
+			return false
+		}
+		if ok && rank == field.rank && reading.timestamp.Before(field.timestamp) {
+			return false
+		}
+	case dbproto.FieldBinding_FIRST_SEEN:
+		// Later readings within the window of the first are duplicates.
+		if ok && (!reading.timestamp.After(field.timestamp) || reading.timestamp.Sub(field.timestamp) < window) {
+			return false
+		}
+	default:
+		if ok && reading.timestamp.Before(field.timestamp) {
+			return false
+		}
+	}
+
+	if ok {
+		if reflect.DeepEqual(field.value, reading.value) {
+			return false
+		}
+		maxRate := time.Duration(binding.GetReference().MaxRate) * time.Millisecond
+		if reading.timestamp.Sub(field.timestamp) < maxRate {
+			return false
+		}
+	}
+	a.fields[target] = field
+	field.rank = rank
+	a.setField(target, field, reading.value, reading.timestamp, []string{reading.source})
+	return true
+}
+
+// Combine the current numeric readings of every source.
+func (a *aggregator) resolveStatistic(target string, binding *dbproto.FieldBinding, field *aggregateField, reading *fieldReading, window time.Duration) bool {
+	if _, ok := toFloat(reading.value); !ok {
+		return false
+	}
+	if prev, ok := field.readings[reading.source]; ok && reading.timestamp.Before(prev.timestamp) {
+		return false
+	}
+	// The latest reading of each source is kept even if the value doesn't
+	// change.
+	a.fields[target] = field
+	field.readings[reading.source] = reading
+
+	latest := reading.timestamp
+	for _, r := range field.readings {
+		if r.timestamp.After(latest) {
+			latest = r.timestamp
+		}
+	}
+	values := make([]float64, 0, len(field.readings))
+	sources := make([]string, 0, len(field.readings))
+	for source, r := range field.readings {
+		if window > 0 && latest.Sub(r.timestamp) >= window {
+			delete(field.readings, source)
+			continue
+		}
+		val, _ := toFloat(r.value)
+		values = append(values, val)
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	sort.Float64s(values)
+
+	var value float64
+	if binding.Policy == dbproto.FieldBinding_MEDIAN {
+		mid := len(values) / 2
+		if len(values)%2 == 0 {
+			value = (values[mid-1] + values[mid]) / 2
+		} else {
+			value = values[mid]
+		}
+	} else {
+		for _, val := range values {
+			value += val
+		}
+		value /= float64(len(values))
+	}
+
+	if field.value == value && reflect.DeepEqual(field.sources, sources) {
+		return false
+	}
+	maxRate := time.Duration(binding.GetReference().MaxRate) * time.Millisecond
+	if field.value != nil && latest.Sub(field.timestamp) < maxRate {
+		return false
+	}
+	a.setField(target, field, value, latest, sources)
+	return true
+}
+
+func (a *aggregator) setField(target string, field *aggregateField, value interface{}, timestamp time.Time, sources []string) {
+	field.value = copyJson(value)
+	field.timestamp = timestamp
+	field.sources = sources
+	setPath(a.changes, target, copyJson(value))
+
+	lineageSources := make([]interface{}, len(sources))
+	for i, source := range sources {
+		lineageSources[i] = source
+	}
+	lineage, ok := asObject(a.changes[LineageField])
+	if !ok {
+		lineage = make(map[string]interface{})
+		a.changes[LineageField] = lineage
+	}
+	lineage[target] = map[string]interface{}{
+		"sources":   lineageSources,
+		"timestamp": float64(timestamp.UnixNano() / int64(time.Millisecond)),
+	}
+}
@@ -0,0 +1,144 @@
+package historian
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/historian/dbproto"
+)
+
+func TestResolve(t *testing.T) {
+	ref := &dbproto.FieldReference{Stream: "*", Field: "altitude"}
+	type step struct {
+		source   string
+		value    interface{}
+		ms       int64
+		accepted bool
+	}
+	tests := []struct {
+		name    string
+		binding *dbproto.FieldBinding
+		steps   []step
+		value   interface{}
+	}{
+		{
+			name:    "latest",
+			binding: &dbproto.FieldBinding{Reference: ref},
+			steps: []step{
+				{"a", 1.0, 100, true},
+				{"b", 2.0, 50, false},
+				{"b", 2.0, 200, true},
+				{"a", 2.0, 300, false},
+			},
+			value: 2.0,
+		},
+		{
+			name:    "priority",
+			binding: &dbproto.FieldBinding{Reference: ref, Policy: dbproto.FieldBinding_PRIORITY, Priority: []string{"a"}, Window: 1000},
+			steps: []step{
+				{"b", 1.0, 0, true},
+				{"a", 2.0, 100, true},
+				{"b", 3.0, 200, false},
+				{"b", 3.0, 1200, true},
+			},
+			value: 3.0,
+		},
+		{
+			// A rejected higher priority reading doesn't take over the field.
+			name:    "priority rejected by rate",
+			binding: &dbproto.FieldBinding{Policy: dbproto.FieldBinding_PRIORITY, Priority: []string{"a"}, Reference: &dbproto.FieldReference{Stream: "*", Field: "altitude", MaxRate: 1000}},
+			steps: []step{
+				{"b", 1.0, 0, true},
+				{"a", 2.0, 500, false},
+				{"b", 3.0, 2000, true},
+			},
+			value: 3.0,
+		},
+		{
+			name:    "priority rejected as unchanged",
+			binding: &dbproto.FieldBinding{Reference: ref, Policy: dbproto.FieldBinding_PRIORITY, Priority: []string{"a"}},
+			steps: []step{
+				{"b", 1.0, 0, true},
+				{"a", 1.0, 100, false},
+				{"b", 2.0, 200, true},
+			},
+			value: 2.0,
+		},
+		{
+			name:    "first seen",
+			binding: &dbproto.FieldBinding{Reference: ref, Policy: dbproto.FieldBinding_FIRST_SEEN},
+			steps: []step{
+				{"a", 1.0, 100, true},
+				{"b", 1.0, 100, false},
+				{"b", 2.0, 200, true},
+			},
+			value: 2.0,
+		},
+		{
+			// Newer readings within the window are duplicates of the first.
+			name:    "first seen in window",
+			binding: &dbproto.FieldBinding{Reference: ref, Policy: dbproto.FieldBinding_FIRST_SEEN, Window: 1000},
+			steps: []step{
+				{"a", 1.0, 100, true},
+				{"b", 2.0, 300, false},
+				{"a", 3.0, 900, false},
+				{"b", 4.0, 1100, true},
+				{"a", 5.0, 1500, false},
+			},
+			value: 4.0,
+		},
+		{
+			name:    "mean",
+			binding: &dbproto.FieldBinding{Reference: ref, Policy: dbproto.FieldBinding_MEAN, Window: 1000},
+			steps: []step{
+				{"a", 1.0, 0, true},
+				{"b", 3.0, 100, true},
+				{"c", "text", 200, false},
+				{"a", 5.0, 1500, true},
+			},
+			value: 5.0,
+		},
+		{
+			name:    "median",
+			binding: &dbproto.FieldBinding{Reference: ref, Policy: dbproto.FieldBinding_MEDIAN},
+			steps: []step{
+				{"a", 1.0, 0, true},
+				{"b", 9.0, 100, true},
+				{"c", 4.0, 200, true},
+			},
+			value: 4.0,
+		},
+	}
+	for _, test := range tests {
+		a := &aggregator{fields: make(map[string]*aggregateField)}
+		for i, step := range test.steps {
+			a.changes = make(map[string]interface{})
+			reading := &fieldReading{
+				source:    step.source,
+				value:     step.value,
+				timestamp: time.Unix(0, step.ms*int64(time.Millisecond)),
+			}
+			if accepted := a.resolve("altitude", test.binding, reading); accepted != step.accepted {
+				t.Fatalf("%s: step %d: expected accepted %v, got %v", test.name, i, step.accepted, accepted)
+			}
+			if _, ok := a.changes["altitude"]; ok != step.accepted {
+				t.Fatalf("%s: step %d: expected changes only on acceptance, got %v", test.name, i, a.changes)
+			}
+		}
+		if value := a.fields["altitude"].value; value != test.value {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.value, value)
+		}
+	}
+}
+
+func TestResolveRejectedFirstReading(t *testing.T) {
+	a := &aggregator{fields: make(map[string]*aggregateField)}
+	a.changes = make(map[string]interface{})
+	binding := &dbproto.FieldBinding{Reference: &dbproto.FieldReference{Stream: "*", Field: "altitude"}, Policy: dbproto.FieldBinding_MEAN}
+	if a.resolve("altitude", binding, &fieldReading{source: "a", value: "text"}) {
+		t.Fatal("expected a non-numeric reading to be rejected")
+	}
+	if _, ok := a.fields["altitude"]; ok {
+		t.Fatal("expected no field for a rejected reading")
+	}
+}
@@ -10,6 +10,17 @@ It is generated from these files:
 
 It has these top-level messages:
 	Stream
+	PayloadTimestamp
+	Deadband
+	ComputedField
+	ScriptStage
+	FieldBinding
+	FieldReference
+	RetentionConfig
+	CompactionConfig
+	StreamTemplate
+	Device
+	DeviceStream
 */
 package dbproto
 
@@ -29,6 +40,154 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+type Stream_Source int32
+
+const (
+	// Entries are pushed by reporters.
+	Stream_PUSH Stream_Source = 0
+	// Fields are taken from other streams.
+	Stream_AGGREGATE Stream_Source = 1
+)
+
+var Stream_Source_name = map[int32]string{
+	0: "PUSH",
+	1: "AGGREGATE",
+}
+var Stream_Source_value = map[string]int32{
+	"PUSH":      0,
+	"AGGREGATE": 1,
+}
+
+func (x Stream_Source) String() string {
+	return proto.EnumName(Stream_Source_name, int32(x))
+}
+func (Stream_Source) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0, 0} }
+
+type Stream_Teardown int32
+
+const (
+	// Use the historian's teardown policy.
+	Stream_TEARDOWN_DEFAULT Stream_Teardown = 0
+	// Leave the entries where they are.
+	Stream_TEARDOWN_KEEP Stream_Teardown = 1
+	// Delete the entries.
+	Stream_TEARDOWN_DROP Stream_Teardown = 2
+	// Move the entries aside.
+	Stream_TEARDOWN_ARCHIVE Stream_Teardown = 3
+)
+
+var Stream_Teardown_name = map[int32]string{
+	0: "TEARDOWN_DEFAULT",
+	1: "TEARDOWN_KEEP",
+	2: "TEARDOWN_DROP",
+	3: "TEARDOWN_ARCHIVE",
+}
+var Stream_Teardown_value = map[string]int32{
+	"TEARDOWN_DEFAULT": 0,
+	"TEARDOWN_KEEP":    1,
+	"TEARDOWN_DROP":    2,
+	"TEARDOWN_ARCHIVE": 3,
+}
+
+func (x Stream_Teardown) String() string {
+	return proto.EnumName(Stream_Teardown_name, int32(x))
+}
+func (Stream_Teardown) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0, 1} }
+
+type PayloadTimestamp_Unit int32
+
+const (
+	// Unix time in seconds.
+	PayloadTimestamp_SECONDS PayloadTimestamp_Unit = 0
+	// Unix time in milliseconds.
+	PayloadTimestamp_MILLISECONDS PayloadTimestamp_Unit = 1
+	// RFC3339 string.
+	PayloadTimestamp_RFC3339 PayloadTimestamp_Unit = 2
+)
+
+var PayloadTimestamp_Unit_name = map[int32]string{
+	0: "SECONDS",
+	1: "MILLISECONDS",
+	2: "RFC3339",
+}
+var PayloadTimestamp_Unit_value = map[string]int32{
+	"SECONDS":      0,
+	"MILLISECONDS": 1,
+	"RFC3339":      2,
+}
+
+func (x PayloadTimestamp_Unit) String() string {
+	return proto.EnumName(PayloadTimestamp_Unit_name, int32(x))
+}
+func (PayloadTimestamp_Unit) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{1, 0} }
+
+type PayloadTimestamp_Fallback int32
+
+const (
+	// Use the reporter timestamp.
+	PayloadTimestamp_ENTRY_TIME PayloadTimestamp_Fallback = 0
+	// Drop the entry, reporting success.
+	PayloadTimestamp_DROP PayloadTimestamp_Fallback = 1
+	// Reject the push with an error.
+	PayloadTimestamp_REJECT PayloadTimestamp_Fallback = 2
+)
+
+var PayloadTimestamp_Fallback_name = map[int32]string{
+	0: "ENTRY_TIME",
+	1: "DROP",
+	2: "REJECT",
+}
+var PayloadTimestamp_Fallback_value = map[string]int32{
+	"ENTRY_TIME": 0,
+	"DROP":       1,
+	"REJECT":     2,
+}
+
+func (x PayloadTimestamp_Fallback) String() string {
+	return proto.EnumName(PayloadTimestamp_Fallback_name, int32(x))
+}
+func (PayloadTimestamp_Fallback) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{1, 1}
+}
+
+type FieldBinding_ConflictPolicy int32
+
+const (
+	// Take the reading with the latest timestamp.
+	FieldBinding_LATEST FieldBinding_ConflictPolicy = 0
+	// Take the reading from the highest priority source.
+	FieldBinding_PRIORITY FieldBinding_ConflictPolicy = 1
+	// Take the mean of the current reading of each source.
+	FieldBinding_MEAN FieldBinding_ConflictPolicy = 2
+	// Take the median of the current reading of each source.
+	FieldBinding_MEDIAN FieldBinding_ConflictPolicy = 3
+	// Take the first reading with each timestamp, or within each window,
+	// dropping the later duplicates from any source.
+	FieldBinding_FIRST_SEEN FieldBinding_ConflictPolicy = 4
+)
+
+var FieldBinding_ConflictPolicy_name = map[int32]string{
+	0: "LATEST",
+	1: "PRIORITY",
+	2: "MEAN",
+	3: "MEDIAN",
+	4: "FIRST_SEEN",
+}
+var FieldBinding_ConflictPolicy_value = map[string]int32{
+	"LATEST":     0,
+	"PRIORITY":   1,
+	"MEAN":       2,
+	"MEDIAN":     3,
+	"FIRST_SEEN": 4,
+}
+
+func (x FieldBinding_ConflictPolicy) String() string {
+	return proto.EnumName(FieldBinding_ConflictPolicy_name, int32(x))
+}
+func (FieldBinding_ConflictPolicy) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{5, 0}
+}
+
 type Stream struct {
 	// ID of the stream (fields 2 + 3 + 4)
 	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
@@ -40,6 +199,35 @@ type Stream struct {
 	StateName string `protobuf:"bytes,4,opt,name=state_name,json=stateName" json:"state_name,omitempty"`
 	// Rate config
 	Config *stream.Config `protobuf:"bytes,5,opt,name=config" json:"config,omitempty"`
+	// Retention policy, or null to keep all entries.
+	Retention *RetentionConfig `protobuf:"bytes,6,opt,name=retention" json:"retention,omitempty"`
+	// Compaction schedule, or null to never compact.
+	Compaction *CompactionConfig `protobuf:"bytes,7,opt,name=compaction" json:"compaction,omitempty"`
+	// Bucket sizes of the rollup tiers to maintain, in milliseconds.
+	RollupBuckets []uint64 `protobuf:"varint,8,rep,packed,name=rollup_buckets,json=rollupBuckets" json:"rollup_buckets,omitempty"`
+	// Where the entries of the stream come from.
+	Source Stream_Source `protobuf:"varint,9,opt,name=source,enum=dbproto.Stream_Source" json:"source,omitempty"`
+	// Field bindings of an aggregate stream, keyed by dotted field path.
+	Fields map[string]*FieldBinding `protobuf:"bytes,10,rep,name=fields" json:"fields,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Take entry timestamps from a field in pushed states, or null to use the
+	// timestamps reporters send.
+	PayloadTimestamp *PayloadTimestamp `protobuf:"bytes,11,opt,name=payload_timestamp,json=payloadTimestamp" json:"payload_timestamp,omitempty"`
+	// Dotted paths of the fields to keep in pushed states, or empty to keep
+	// all. A * matches any key at its level.
+	IncludeFields []string `protobuf:"bytes,12,rep,name=include_fields,json=includeFields" json:"include_fields,omitempty"`
+	// Dotted paths of the fields to remove from pushed states.
+	ExcludeFields []string `protobuf:"bytes,13,rep,name=exclude_fields,json=excludeFields" json:"exclude_fields,omitempty"`
+	// Deadbands of noisy numeric fields, keyed by dotted field path.
+	Deadbands map[string]*Deadband `protobuf:"bytes,14,rep,name=deadbands" json:"deadbands,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Fields derived from pushed states, evaluated in order.
+	ComputedFields []*ComputedField `protobuf:"bytes,15,rep,name=computed_fields,json=computedFields" json:"computed_fields,omitempty"`
+	// Starlark scripts applied to pushed entries, in order.
+	Scripts []*ScriptStage `protobuf:"bytes,16,rep,name=scripts" json:"scripts,omitempty"`
+	// Accept entries up to this many milliseconds older than the latest one,
+	// inserting them into history. 0 rejects late entries.
+	LatenessWindow uint64 `protobuf:"varint,17,opt,name=lateness_window,json=latenessWindow" json:"lateness_window,omitempty"`
+	// What to do with the entries when the stream is deleted.
+	Teardown Stream_Teardown `protobuf:"varint,18,opt,name=teardown,enum=dbproto.Stream_Teardown" json:"teardown,omitempty"`
 }
 
 func (m *Stream) Reset()                    { *m = Stream{} }
@@ -54,8 +242,278 @@ func (m *Stream) GetConfig() *stream.Config {
 	return nil
 }
 
+func (m *Stream) GetRetention() *RetentionConfig {
+	if m != nil {
+		return m.Retention
+	}
+	return nil
+}
+
+func (m *Stream) GetCompaction() *CompactionConfig {
+	if m != nil {
+		return m.Compaction
+	}
+	return nil
+}
+
+func (m *Stream) GetFields() map[string]*FieldBinding {
+	if m != nil {
+		return m.Fields
+	}
+	return nil
+}
+
+func (m *Stream) GetPayloadTimestamp() *PayloadTimestamp {
+	if m != nil {
+		return m.PayloadTimestamp
+	}
+	return nil
+}
+
+func (m *Stream) GetDeadbands() map[string]*Deadband {
+	if m != nil {
+		return m.Deadbands
+	}
+	return nil
+}
+
+func (m *Stream) GetComputedFields() []*ComputedField {
+	if m != nil {
+		return m.ComputedFields
+	}
+	return nil
+}
+
+func (m *Stream) GetScripts() []*ScriptStage {
+	if m != nil {
+		return m.Scripts
+	}
+	return nil
+}
+
+// Field in pushed states holding the time of the state.
+type PayloadTimestamp struct {
+	// Dotted path of the field.
+	Field string                `protobuf:"bytes,1,opt,name=field" json:"field,omitempty"`
+	Unit  PayloadTimestamp_Unit `protobuf:"varint,2,opt,name=unit,enum=dbproto.PayloadTimestamp_Unit" json:"unit,omitempty"`
+	// Times further than this from the reporter timestamp are implausible,
+	// in milliseconds. Zero means no limit.
+	MaxSkew uint64 `protobuf:"varint,3,opt,name=max_skew,json=maxSkew" json:"max_skew,omitempty"`
+	// What to do when the field is missing or implausible.
+	Fallback PayloadTimestamp_Fallback `protobuf:"varint,4,opt,name=fallback,enum=dbproto.PayloadTimestamp_Fallback" json:"fallback,omitempty"`
+}
+
+func (m *PayloadTimestamp) Reset()                    { *m = PayloadTimestamp{} }
+func (m *PayloadTimestamp) String() string            { return proto.CompactTextString(m) }
+func (*PayloadTimestamp) ProtoMessage()               {}
+func (*PayloadTimestamp) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+// Changes to a numeric field smaller than the deadband are not recorded.
+// The threshold is the larger of the absolute and relative epsilons.
+type Deadband struct {
+	// Smallest change to record.
+	Absolute float64 `protobuf:"fixed64,1,opt,name=absolute" json:"absolute,omitempty"`
+	// Smallest change to record, as a fraction of the recorded value.
+	Relative float64 `protobuf:"fixed64,2,opt,name=relative" json:"relative,omitempty"`
+	// Record any change once the value has been held this long, in
+	// milliseconds. Zero means never.
+	MaxSilence uint64 `protobuf:"varint,3,opt,name=max_silence,json=maxSilence" json:"max_silence,omitempty"`
+}
+
+func (m *Deadband) Reset()                    { *m = Deadband{} }
+func (m *Deadband) String() string            { return proto.CompactTextString(m) }
+func (*Deadband) ProtoMessage()               {}
+func (*Deadband) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+// A field computed from the rest of the state by an expression, like
+// flight_state.velocity.speed * 1.94384 or state == "FLYING".
+type ComputedField struct {
+	// Dotted path of the field to set.
+	Field      string `protobuf:"bytes,1,opt,name=field" json:"field,omitempty"`
+	Expression string `protobuf:"bytes,2,opt,name=expression" json:"expression,omitempty"`
+}
+
+func (m *ComputedField) Reset()                    { *m = ComputedField{} }
+func (m *ComputedField) String() string            { return proto.CompactTextString(m) }
+func (*ComputedField) ProtoMessage()               {}
+func (*ComputedField) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+// A Starlark script that transforms pushed entries.
+// The script defines process(entry), see the README.
+type ScriptStage struct {
+	// Name used in errors and logs.
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// Starlark source.
+	Source string `protobuf:"bytes,2,opt,name=source" json:"source,omitempty"`
+	// Most Starlark steps per entry, zero for the default.
+	MaxSteps uint64 `protobuf:"varint,3,opt,name=max_steps,json=maxSteps" json:"max_steps,omitempty"`
+	// Most time per entry, in milliseconds, zero for the default.
+	Timeout uint64 `protobuf:"varint,4,opt,name=timeout" json:"timeout,omitempty"`
+}
+
+func (m *ScriptStage) Reset()                    { *m = ScriptStage{} }
+func (m *ScriptStage) String() string            { return proto.CompactTextString(m) }
+func (*ScriptStage) ProtoMessage()               {}
+func (*ScriptStage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+// Binding of an aggregate stream field.
+type FieldBinding struct {
+	Reference *FieldReference `protobuf:"bytes,1,opt,name=reference" json:"reference,omitempty"`
+	// How to pick a value when several source streams match.
+	Policy FieldBinding_ConflictPolicy `protobuf:"varint,2,opt,name=policy,enum=dbproto.FieldBinding_ConflictPolicy" json:"policy,omitempty"`
+	// Source stream globs, highest priority first, for PRIORITY.
+	// Sources matching none rank last.
+	Priority []string `protobuf:"bytes,3,rep,name=priority" json:"priority,omitempty"`
+	// How long a reading stays current, in milliseconds, for PRIORITY, MEAN,
+	// MEDIAN and FIRST_SEEN. For PRIORITY a lower priority source takes over
+	// once the current reading is this old. For FIRST_SEEN later readings
+	// within it are duplicates. Zero means forever, except for FIRST_SEEN
+	// where only readings with the same timestamp are duplicates.
+	Window uint64 `protobuf:"varint,4,opt,name=window" json:"window,omitempty"`
+}
+
+func (m *FieldBinding) Reset()                    { *m = FieldBinding{} }
+func (m *FieldBinding) String() string            { return proto.CompactTextString(m) }
+func (*FieldBinding) ProtoMessage()               {}
+func (*FieldBinding) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *FieldBinding) GetReference() *FieldReference {
+	if m != nil {
+		return m.Reference
+	}
+	return nil
+}
+
+// Reference to a field in other streams.
+type FieldReference struct {
+	// Glob of source streams, like *.sensor_rx.sensor_233.
+	Stream string `protobuf:"bytes,1,opt,name=stream" json:"stream,omitempty"`
+	// Dotted path of the field holding the time of a reading, optional.
+	Timestamp string `protobuf:"bytes,2,opt,name=timestamp" json:"timestamp,omitempty"`
+	// Dotted path of the field to take.
+	Field string `protobuf:"bytes,3,opt,name=field" json:"field,omitempty"`
+	// Drop readings less than this many milliseconds after the last one.
+	MaxRate uint64 `protobuf:"varint,4,opt,name=max_rate,json=maxRate" json:"max_rate,omitempty"`
+}
+
+func (m *FieldReference) Reset()                    { *m = FieldReference{} }
+func (m *FieldReference) String() string            { return proto.CompactTextString(m) }
+func (*FieldReference) ProtoMessage()               {}
+func (*FieldReference) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+// Limits on the history kept for a stream.
+// Zero values mean no limit.
+type RetentionConfig struct {
+	// Delete entries older than this, in milliseconds.
+	MaxAge uint64 `protobuf:"varint,1,opt,name=max_age,json=maxAge" json:"max_age,omitempty"`
+	// Keep at most this many entries.
+	MaxEntries uint64 `protobuf:"varint,2,opt,name=max_entries,json=maxEntries" json:"max_entries,omitempty"`
+	// Keep at most this many bytes of JSON entry data.
+	MaxBytes uint64 `protobuf:"varint,3,opt,name=max_bytes,json=maxBytes" json:"max_bytes,omitempty"`
+}
+
+func (m *RetentionConfig) Reset()                    { *m = RetentionConfig{} }
+func (m *RetentionConfig) String() string            { return proto.CompactTextString(m) }
+func (*RetentionConfig) ProtoMessage()               {}
+func (*RetentionConfig) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+// Re-distribution of old history into evenly spaced snapshots.
+type CompactionConfig struct {
+	// Spacing between snapshots, in milliseconds.
+	KeyframeInterval uint64 `protobuf:"varint,1,opt,name=keyframe_interval,json=keyframeInterval" json:"keyframe_interval,omitempty"`
+	// Only compact entries older than this, in milliseconds.
+	MinAge uint64 `protobuf:"varint,2,opt,name=min_age,json=minAge" json:"min_age,omitempty"`
+}
+
+func (m *CompactionConfig) Reset()                    { *m = CompactionConfig{} }
+func (m *CompactionConfig) String() string            { return proto.CompactTextString(m) }
+func (*CompactionConfig) ProtoMessage()               {}
+func (*CompactionConfig) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+// Streams shared by a class of devices, like a type of plane.
+type StreamTemplate struct {
+	// Name of the template.
+	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	// Streams of each device using the template. The device hostname and ID
+	// are filled in. Component and state names may be path.Match patterns,
+	// registered as concrete streams when a device first pushes to them.
+	Streams []*Stream `protobuf:"bytes,2,rep,name=streams" json:"streams,omitempty"`
+}
+
+func (m *StreamTemplate) Reset()                    { *m = StreamTemplate{} }
+func (m *StreamTemplate) String() string            { return proto.CompactTextString(m) }
+func (*StreamTemplate) ProtoMessage()               {}
+func (*StreamTemplate) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *StreamTemplate) GetStreams() []*Stream {
+	if m != nil {
+		return m.Streams
+	}
+	return nil
+}
+
+// A device whose streams come from a template.
+type Device struct {
+	// Hostname of the device.
+	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	// ID of the StreamTemplate to expand.
+	Template string `protobuf:"bytes,2,opt,name=template" json:"template,omitempty"`
+	// Streams replacing the template stream with the same component and state
+	// names, or added to the template.
+	Overrides []*Stream `protobuf:"bytes,3,rep,name=overrides" json:"overrides,omitempty"`
+	// Streams the device registered by pushing to a template pattern. Each is
+	// expanded from the pattern it matches.
+	Registered []*DeviceStream `protobuf:"bytes,4,rep,name=registered" json:"registered,omitempty"`
+}
+
+func (m *Device) Reset()                    { *m = Device{} }
+func (m *Device) String() string            { return proto.CompactTextString(m) }
+func (*Device) ProtoMessage()               {}
+func (*Device) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *Device) GetOverrides() []*Stream {
+	if m != nil {
+		return m.Overrides
+	}
+	return nil
+}
+
+func (m *Device) GetRegistered() []*DeviceStream {
+	if m != nil {
+		return m.Registered
+	}
+	return nil
+}
+
+// A concrete stream of a device, by name.
+type DeviceStream struct {
+	ComponentName string `protobuf:"bytes,1,opt,name=component_name,json=componentName" json:"component_name,omitempty"`
+	StateName     string `protobuf:"bytes,2,opt,name=state_name,json=stateName" json:"state_name,omitempty"`
+}
+
+func (m *DeviceStream) Reset()                    { *m = DeviceStream{} }
+func (m *DeviceStream) String() string            { return proto.CompactTextString(m) }
+func (*DeviceStream) ProtoMessage()               {}
+func (*DeviceStream) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
 func init() {
 	proto.RegisterType((*Stream)(nil), "dbproto.Stream")
+	proto.RegisterType((*PayloadTimestamp)(nil), "dbproto.PayloadTimestamp")
+	proto.RegisterType((*Deadband)(nil), "dbproto.Deadband")
+	proto.RegisterType((*ComputedField)(nil), "dbproto.ComputedField")
+	proto.RegisterType((*ScriptStage)(nil), "dbproto.ScriptStage")
+	proto.RegisterType((*FieldBinding)(nil), "dbproto.FieldBinding")
+	proto.RegisterType((*FieldReference)(nil), "dbproto.FieldReference")
+	proto.RegisterType((*RetentionConfig)(nil), "dbproto.RetentionConfig")
+	proto.RegisterType((*CompactionConfig)(nil), "dbproto.CompactionConfig")
+	proto.RegisterType((*StreamTemplate)(nil), "dbproto.StreamTemplate")
+	proto.RegisterType((*Device)(nil), "dbproto.Device")
+	proto.RegisterType((*DeviceStream)(nil), "dbproto.DeviceStream")
+	proto.RegisterEnum("dbproto.Stream_Source", Stream_Source_name, Stream_Source_value)
+	proto.RegisterEnum("dbproto.Stream_Teardown", Stream_Teardown_name, Stream_Teardown_value)
+	proto.RegisterEnum("dbproto.PayloadTimestamp_Unit", PayloadTimestamp_Unit_name, PayloadTimestamp_Unit_value)
+	proto.RegisterEnum("dbproto.PayloadTimestamp_Fallback", PayloadTimestamp_Fallback_name, PayloadTimestamp_Fallback_value)
+	proto.RegisterEnum("dbproto.FieldBinding_ConflictPolicy", FieldBinding_ConflictPolicy_name, FieldBinding_ConflictPolicy_value)
 }
 
 func init() {
@@ -63,19 +521,85 @@ func init() {
 }
 
 var fileDescriptor0 = []byte{
-	// 217 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x74, 0x8e, 0xbf, 0x4a, 0x04, 0x31,
-	0x10, 0xc6, 0xd9, 0x55, 0x57, 0x6e, 0xc4, 0x15, 0xb6, 0x5a, 0x04, 0xe1, 0x10, 0xd4, 0xab, 0x12,
-	0xd1, 0xc2, 0x07, 0xb0, 0xb1, 0xb2, 0x38, 0x1f, 0xe0, 0xc8, 0xbf, 0xbb, 0x9d, 0x22, 0x99, 0x23,
-	0x99, 0xf5, 0xb5, 0x7c, 0x45, 0x71, 0xb2, 0xda, 0xd9, 0x24, 0xcc, 0xef, 0xfb, 0xcd, 0xf0, 0xc1,
-	0xcb, 0x01, 0x79, 0x9a, 0xad, 0x72, 0x14, 0xf5, 0x7e, 0x2e, 0x21, 0x93, 0x25, 0x46, 0x57, 0xf4,
-	0x84, 0x85, 0x29, 0xa3, 0x49, 0xda, 0xdb, 0x63, 0x26, 0xa6, 0xdf, 0x5f, 0xc9, 0x3b, 0x9c, 0x2f,
-	0xe3, 0xf5, 0xe3, 0x7f, 0x17, 0x0a, 0x1b, 0x0e, 0x85, 0x73, 0x30, 0x51, 0x3b, 0x4a, 0x7b, 0x3c,
-	0xd4, 0xd5, 0xdb, 0xaf, 0x06, 0xba, 0x0f, 0xe1, 0x43, 0x0f, 0x2d, 0xfa, 0xb1, 0x59, 0x37, 0x9b,
-	0xd5, 0xb6, 0x45, 0x3f, 0x3c, 0xc0, 0x95, 0x0f, 0x9f, 0xe8, 0xc2, 0x6e, 0xa2, 0xc2, 0xc9, 0xc4,
-	0x30, 0xb6, 0x12, 0xf6, 0x15, 0xbf, 0x2d, 0x74, 0xb8, 0x83, 0xde, 0x51, 0x3c, 0x52, 0x0a, 0x89,
-	0x77, 0xe2, 0x9d, 0x88, 0x77, 0xf9, 0x47, 0xdf, 0x7f, 0xb4, 0x1b, 0x00, 0xa9, 0x51, 0x95, 0x53,
-	0x51, 0x56, 0x42, 0x24, 0xbe, 0x87, 0xae, 0x36, 0x1b, 0xcf, 0xd6, 0xcd, 0xe6, 0xe2, 0xa9, 0x57,
-	0xb5, 0xaf, 0x7a, 0x15, 0xba, 0x5d, 0x52, 0xdb, 0x49, 0xf1, 0xe7, 0xef, 0x00, 0x00, 0x00, 0xff,
-	0xff, 0xc3, 0x88, 0x16, 0x81, 0x2e, 0x01, 0x00, 0x00,
+	// 1279 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x56, 0xdb, 0x6e, 0xdb, 0x46,
+	0x13, 0x36, 0x25, 0x45, 0x87, 0x91, 0x4d, 0xd3, 0x8b, 0xfc, 0x09, 0xe3, 0xfc, 0x4d, 0x55, 0xa2,
+	0x6d, 0x5c, 0x04, 0x95, 0x03, 0xb9, 0x69, 0x9b, 0x22, 0x68, 0xa1, 0x58, 0x74, 0xa2, 0xc6, 0x96,
+	0x85, 0x95, 0xd2, 0x34, 0x40, 0x01, 0x61, 0x45, 0xae, 0xe5, 0x85, 0x29, 0x52, 0x20, 0x57, 0xb6,
+	0xf4, 0x08, 0x7d, 0x85, 0x02, 0x7d, 0xcd, 0x5e, 0x17, 0x7b, 0x20, 0x45, 0x29, 0x36, 0x7a, 0x63,
+	0x6b, 0xbe, 0xf9, 0x66, 0x76, 0x4e, 0x3b, 0x4b, 0xf8, 0x61, 0xc2, 0xf8, 0xe5, 0x7c, 0xdc, 0xf4,
+	0xa2, 0xe9, 0xe1, 0xc5, 0x3c, 0xa1, 0x71, 0x34, 0x8e, 0x38, 0xf3, 0x92, 0xc3, 0x4b, 0x96, 0xf0,
+	0x28, 0x66, 0x24, 0x3c, 0xf4, 0xc7, 0xb3, 0x38, 0xe2, 0x51, 0xfa, 0xbf, 0x29, 0xff, 0xa2, 0x8a,
+	0x16, 0xf7, 0x9f, 0xdf, 0xe5, 0x21, 0xe1, 0x84, 0xd3, 0x84, 0xc7, 0x94, 0x4c, 0x0f, 0xbd, 0x28,
+	0xbc, 0x60, 0x13, 0x65, 0xea, 0xfc, 0x53, 0x85, 0xf2, 0x40, 0xe2, 0xc8, 0x84, 0x02, 0xf3, 0x6d,
+	0xa3, 0x61, 0x1c, 0xd4, 0x70, 0x81, 0xf9, 0xe8, 0x29, 0xec, 0xfa, 0xf4, 0x9a, 0x79, 0x74, 0x74,
+	0x19, 0x25, 0x3c, 0x24, 0x53, 0x6a, 0x17, 0xa4, 0xd2, 0x54, 0xf0, 0x5b, 0x8d, 0xa2, 0xaf, 0xc0,
+	0xf4, 0xa2, 0xe9, 0x2c, 0x0a, 0x69, 0xc8, 0x47, 0x92, 0x57, 0x94, 0xbc, 0x9d, 0x0c, 0xed, 0x09,
+	0xda, 0x67, 0x00, 0x32, 0x0c, 0x45, 0x29, 0x49, 0x4a, 0x4d, 0x22, 0x52, 0xfd, 0x35, 0x94, 0x55,
+	0x64, 0xf6, 0xbd, 0x86, 0x71, 0x50, 0x6f, 0x99, 0x4d, 0x15, 0x6f, 0xf3, 0x58, 0xa2, 0x58, 0x6b,
+	0xd1, 0xf7, 0x50, 0x8b, 0x29, 0xa7, 0x21, 0x67, 0x51, 0x68, 0x97, 0x25, 0xd5, 0x6e, 0xa6, 0xf5,
+	0xc0, 0xa9, 0x46, 0x1b, 0xad, 0xa8, 0xe8, 0x25, 0x80, 0x88, 0x87, 0x78, 0xd2, 0xb0, 0x22, 0x0d,
+	0x1f, 0x65, 0x86, 0xc7, 0x99, 0x4a, 0x5b, 0xe6, 0xc8, 0x22, 0xc1, 0x38, 0x0a, 0x82, 0xf9, 0x6c,
+	0x34, 0x9e, 0x7b, 0x57, 0x94, 0x27, 0x76, 0xb5, 0x51, 0x3c, 0x28, 0xe1, 0x1d, 0x85, 0xbe, 0x56,
+	0x20, 0x6a, 0x42, 0x39, 0x89, 0xe6, 0xb1, 0x47, 0xed, 0x5a, 0xc3, 0x38, 0x30, 0x5b, 0x0f, 0x32,
+	0xef, 0xaa, 0xc2, 0xcd, 0x81, 0xd4, 0x62, 0xcd, 0x42, 0x47, 0x50, 0xbe, 0x60, 0x34, 0xf0, 0x13,
+	0x1b, 0x1a, 0xc5, 0x83, 0x7a, 0xeb, 0xf1, 0x26, 0xff, 0x44, 0x6a, 0xdd, 0x90, 0xc7, 0x4b, 0xac,
+	0xa9, 0xe8, 0x04, 0xf6, 0x66, 0x64, 0x19, 0x44, 0xc4, 0x1f, 0x71, 0x36, 0xa5, 0x09, 0x27, 0xd3,
+	0x99, 0x5d, 0xdf, 0xc8, 0xa6, 0xaf, 0x18, 0xc3, 0x94, 0x80, 0xad, 0xd9, 0x06, 0x22, 0x72, 0x62,
+	0xa1, 0x17, 0xcc, 0x7d, 0x3a, 0xd2, 0x41, 0x6c, 0x37, 0x8a, 0xa2, 0x69, 0x1a, 0x55, 0x67, 0x0b,
+	0x1a, 0x5d, 0xac, 0xd1, 0x76, 0x14, 0x8d, 0x2e, 0xf2, 0xb4, 0x57, 0x50, 0xf3, 0x29, 0xf1, 0xc7,
+	0x24, 0xf4, 0x13, 0xdb, 0x94, 0xd9, 0x3c, 0xd9, 0xcc, 0xa6, 0x93, 0x12, 0x54, 0x42, 0x2b, 0x03,
+	0xf4, 0x0b, 0xec, 0x8a, 0x6a, 0xcf, 0x39, 0xf5, 0xd3, 0x53, 0x76, 0xa5, 0x8f, 0x07, 0x6b, 0xfd,
+	0x11, 0x7a, 0x79, 0x1e, 0x36, 0xbd, 0xbc, 0x28, 0x2a, 0x5f, 0x49, 0xbc, 0x98, 0xcd, 0x78, 0x62,
+	0x5b, 0xd2, 0xf0, 0xfe, 0xea, 0x70, 0x89, 0x0f, 0x38, 0x99, 0x50, 0x9c, 0x92, 0xc4, 0x68, 0x07,
+	0x84, 0xd3, 0x90, 0x26, 0xc9, 0xe8, 0x86, 0x85, 0x7e, 0x74, 0x63, 0xef, 0x35, 0x8c, 0x83, 0x12,
+	0x36, 0x53, 0xf8, 0x83, 0x44, 0xd1, 0x77, 0x50, 0xe5, 0x94, 0xc4, 0x7e, 0x74, 0x13, 0xda, 0x48,
+	0x36, 0xd5, 0xde, 0x4c, 0x6b, 0xa8, 0xf5, 0x38, 0x63, 0xee, 0xf7, 0xa1, 0x9e, 0x6b, 0x1d, 0xb2,
+	0xa0, 0x78, 0x45, 0x97, 0xfa, 0x66, 0x89, 0x9f, 0xe8, 0x19, 0xdc, 0xbb, 0x26, 0xc1, 0x5c, 0x5d,
+	0xa8, 0x7a, 0xeb, 0x7f, 0x99, 0x4f, 0x69, 0xf6, 0x9a, 0x85, 0x3e, 0x0b, 0x27, 0x58, 0x71, 0x7e,
+	0x2a, 0xfc, 0x68, 0xec, 0x9f, 0x83, 0xb9, 0x5e, 0xbe, 0x5b, 0x9c, 0x3e, 0x5d, 0x77, 0xba, 0x97,
+	0x39, 0x4d, 0x2d, 0x73, 0x0e, 0x9d, 0x2f, 0xa0, 0xac, 0xa6, 0x11, 0x55, 0xa1, 0xd4, 0x7f, 0x3f,
+	0x78, 0x6b, 0x6d, 0xa1, 0x1d, 0xa8, 0xb5, 0xdf, 0xbc, 0xc1, 0xee, 0x9b, 0xf6, 0xd0, 0xb5, 0x0c,
+	0xe7, 0x0f, 0xa8, 0xa6, 0xb9, 0xa1, 0xfb, 0x60, 0x0d, 0xdd, 0x36, 0xee, 0x9c, 0x7f, 0xe8, 0x8d,
+	0x3a, 0xee, 0x49, 0xfb, 0xfd, 0xe9, 0xd0, 0xda, 0x42, 0x7b, 0xb0, 0x93, 0xa1, 0xef, 0x5c, 0xb7,
+	0x6f, 0x19, 0x6b, 0x50, 0x07, 0x9f, 0xf7, 0xad, 0xc2, 0x9a, 0x6d, 0x1b, 0x1f, 0xbf, 0xed, 0xfe,
+	0xe6, 0x5a, 0x45, 0xe7, 0xef, 0x02, 0x58, 0x9b, 0x63, 0x8a, 0xee, 0xc3, 0x3d, 0xd9, 0x7f, 0x9d,
+	0x96, 0x12, 0x50, 0x0b, 0x4a, 0xf3, 0x90, 0x71, 0x99, 0x97, 0x99, 0x9b, 0xab, 0x4d, 0xf3, 0xe6,
+	0xfb, 0x90, 0x71, 0x2c, 0xb9, 0xe8, 0x11, 0x54, 0xa7, 0x64, 0x31, 0x4a, 0xae, 0xe8, 0x8d, 0xdc,
+	0x46, 0x25, 0x5c, 0x99, 0x92, 0xc5, 0xe0, 0x8a, 0xde, 0xa0, 0x9f, 0xa1, 0x7a, 0x41, 0x82, 0x60,
+	0x4c, 0xbc, 0x2b, 0xb9, 0x85, 0xcc, 0x96, 0x73, 0xb7, 0xcb, 0x13, 0xcd, 0xc4, 0x99, 0x8d, 0xd3,
+	0x82, 0x92, 0x38, 0x08, 0xd5, 0xa1, 0x32, 0x70, 0x8f, 0xcf, 0x7b, 0x9d, 0x81, 0xb5, 0x85, 0x2c,
+	0xd8, 0x3e, 0xeb, 0x9e, 0x9e, 0x76, 0x53, 0xc4, 0x10, 0x6a, 0x7c, 0x72, 0x7c, 0x74, 0x74, 0xf4,
+	0xd2, 0x2a, 0x38, 0xcf, 0xa1, 0x9a, 0x7a, 0x42, 0x26, 0x80, 0xdb, 0x1b, 0xe2, 0x8f, 0xa3, 0x61,
+	0xf7, 0xcc, 0xb5, 0xb6, 0x44, 0x03, 0x64, 0xa5, 0x0c, 0x04, 0x50, 0xc6, 0xee, 0xaf, 0xee, 0xf1,
+	0xd0, 0x2a, 0x38, 0x1e, 0x54, 0xd3, 0xbe, 0xa1, 0x7d, 0xa8, 0x92, 0x71, 0x12, 0x05, 0x73, 0x4e,
+	0x65, 0x65, 0x0c, 0x9c, 0xc9, 0x42, 0x17, 0xd3, 0x80, 0x70, 0x76, 0xad, 0x1a, 0x6f, 0xe0, 0x4c,
+	0x46, 0x9f, 0x43, 0x5d, 0x16, 0x81, 0x05, 0x34, 0xf4, 0xa8, 0xae, 0x03, 0x88, 0x3a, 0x28, 0xc4,
+	0x71, 0x61, 0x67, 0xed, 0x62, 0xdd, 0xd1, 0x80, 0x27, 0x00, 0x74, 0x31, 0x8b, 0x69, 0x92, 0x88,
+	0xd5, 0xa9, 0x1e, 0x81, 0x1c, 0xe2, 0xcc, 0xa0, 0x9e, 0xbb, 0x66, 0x08, 0x41, 0x49, 0xae, 0x78,
+	0xe5, 0x43, 0xfe, 0x46, 0x0f, 0xb2, 0xdd, 0xa8, 0xcc, 0xb5, 0x84, 0x1e, 0x43, 0x4d, 0x86, 0xc8,
+	0xe9, 0x2c, 0xd1, 0x01, 0x8a, 0xc6, 0x0d, 0x84, 0x8c, 0x6c, 0xa8, 0x88, 0x1d, 0x17, 0xcd, 0xb9,
+	0x6c, 0x54, 0x09, 0xa7, 0xa2, 0xf3, 0x67, 0x01, 0xb6, 0xf3, 0x77, 0x05, 0xbd, 0x10, 0xaf, 0xc2,
+	0x05, 0x8d, 0x65, 0xa2, 0x86, 0xbc, 0x00, 0x0f, 0xd7, 0x6f, 0x15, 0x4e, 0xd5, 0x78, 0xc5, 0x44,
+	0xaf, 0xa0, 0x3c, 0x8b, 0x02, 0xe6, 0x2d, 0xf5, 0x70, 0x7d, 0x79, 0xeb, 0x4d, 0x94, 0x4f, 0x50,
+	0xc0, 0x3c, 0xde, 0x97, 0x5c, 0xac, 0x6d, 0x44, 0xed, 0x67, 0x31, 0x8b, 0x62, 0xc6, 0x97, 0x76,
+	0x51, 0xae, 0xc5, 0x4c, 0x16, 0x09, 0xeb, 0xcd, 0xa2, 0x42, 0xd7, 0x92, 0xd3, 0x07, 0x73, 0xdd,
+	0x9b, 0xe8, 0xfa, 0x69, 0x7b, 0xe8, 0x0e, 0xc4, 0x8d, 0xda, 0x86, 0x6a, 0x1f, 0x77, 0xcf, 0x71,
+	0x77, 0xf8, 0xd1, 0x32, 0xc4, 0x64, 0x9c, 0xb9, 0xed, 0x9e, 0x55, 0x10, 0x9c, 0x33, 0xb7, 0xd3,
+	0x6d, 0xf7, 0xac, 0xa2, 0x98, 0x9f, 0x93, 0x2e, 0x1e, 0x0c, 0x47, 0x03, 0xd7, 0xed, 0x59, 0x25,
+	0xe7, 0x06, 0xcc, 0xf5, 0x04, 0x65, 0xb1, 0xe5, 0x72, 0xd2, 0x2d, 0xd0, 0x12, 0xfa, 0x3f, 0xd4,
+	0x56, 0x6f, 0x86, 0xea, 0xc3, 0x0a, 0x58, 0xf5, 0xbe, 0x98, 0xef, 0xbd, 0xbe, 0x48, 0x31, 0xe1,
+	0x34, 0x6d, 0xc2, 0x94, 0x2c, 0x30, 0xe1, 0xd4, 0xb9, 0x84, 0xdd, 0x8d, 0xf7, 0x16, 0x3d, 0x04,
+	0xa1, 0x1d, 0x91, 0x89, 0x6a, 0x42, 0x09, 0x97, 0xa7, 0x64, 0xd1, 0x9e, 0x64, 0xa3, 0x48, 0x43,
+	0x1e, 0x33, 0x9a, 0xd8, 0x85, 0x6c, 0x14, 0x5d, 0x85, 0xa4, 0x83, 0x30, 0x5e, 0x72, 0x9a, 0x1f,
+	0x84, 0xd7, 0x42, 0x76, 0x7e, 0x07, 0x6b, 0xf3, 0x81, 0x46, 0xcf, 0x60, 0xef, 0x8a, 0x2e, 0x2f,
+	0x62, 0x32, 0xa5, 0x23, 0x16, 0x72, 0x1a, 0x5f, 0x93, 0x40, 0x1f, 0x6a, 0xa5, 0x8a, 0xae, 0xc6,
+	0x65, 0x5c, 0x2c, 0x94, 0x71, 0x15, 0x74, 0x5c, 0x2c, 0x6c, 0x4f, 0xa8, 0xf3, 0x0e, 0x4c, 0xb5,
+	0xc7, 0x87, 0x74, 0x3a, 0x13, 0xcb, 0xff, 0x93, 0xcf, 0xa0, 0x6f, 0xa0, 0xa2, 0xca, 0x27, 0xa2,
+	0x16, 0x6f, 0xcb, 0xee, 0xc6, 0x0b, 0x80, 0x53, 0xbd, 0xf3, 0x97, 0x01, 0xe5, 0x8e, 0xfc, 0x36,
+	0xfa, 0xc4, 0xcb, 0xbe, 0x78, 0x48, 0xd4, 0x09, 0xba, 0xf2, 0x99, 0x8c, 0xbe, 0x85, 0x5a, 0x74,
+	0x4d, 0xe3, 0x98, 0xf9, 0x32, 0xf5, 0x5b, 0xcf, 0x58, 0x31, 0xd0, 0x0b, 0x80, 0x98, 0x4e, 0x58,
+	0xc2, 0x69, 0x4c, 0x7d, 0xbb, 0xd4, 0x28, 0xae, 0xbd, 0x20, 0xea, 0x7c, 0x6d, 0x95, 0x23, 0x3a,
+	0x43, 0xd8, 0xce, 0xeb, 0x6e, 0xf9, 0x6a, 0x33, 0xfe, 0xfb, 0xab, 0xad, 0xb0, 0xf1, 0xd5, 0x36,
+	0x2e, 0xcb, 0x53, 0x8f, 0xfe, 0x1d, 0x00, 0xe4, 0x96, 0x3d, 0xd8, 0xbc, 0x0a, 0x00, 0x00,
 }
@@ -0,0 +1,95 @@
+package historian
+
+import (
+	"math"
+	"time"
+
+	"github.com/fuserobotics/statestream"
+)
+
+// Last recorded value of a field with a deadband.
+type recordedValue struct {
+	value     float64
+	timestamp time.Time
+}
+
+// Hold back changes to numeric fields that stay within their deadband.
+// Held fields are removed from mutations and reset to the recorded value in
+// snapshots. Mutations left with no fields are dropped. The values that pass
+// are only recorded once the entry is written, see commitDeadbands.
+func applyDeadbands(s *Stream, entry *stream.StreamEntry) (bool, error) {
+	if len(s.Data.Deadbands) == 0 {
+		return true, nil
+	}
+
+	s.deadbandMtx.Lock()
+	defer s.deadbandMtx.Unlock()
+
+	pending := make(map[string]*recordedValue)
+	for path, deadband := range s.Data.Deadbands {
+		val, ok := lookupPath(entry.Data, path)
+		if !ok {
+			continue
+		}
+		num, ok := toFloat(val)
+		if !ok {
+			continue
+		}
+
+		recorded, ok := s.deadbandValues[path]
+		if ok {
+			threshold := math.Max(deadband.Absolute, deadband.Relative*math.Abs(recorded.value))
+			maxSilence := time.Duration(deadband.MaxSilence) * time.Millisecond
+			silent := maxSilence > 0 && entry.Timestamp.Sub(recorded.timestamp) >= maxSilence
+			if math.Abs(num-recorded.value) < threshold && !silent {
+				if entry.Type == stream.StreamEntryMutation {
+					removePath(entry.Data, path)
+				} else {
+					setPath(entry.Data, path, recorded.value)
+				}
+				continue
+			}
+		}
+		pending[path] = &recordedValue{value: num, timestamp: entry.Timestamp}
+	}
+
+	if entry.Type == stream.StreamEntryMutation && len(entry.Data) == 0 {
+		return false, nil
+	}
+	if len(pending) != 0 {
+		if s.deadbandPending == nil {
+			s.deadbandPending = make(map[*stream.StreamEntry]map[string]*recordedValue)
+		}
+		s.deadbandPending[entry] = pending
+	}
+	return true, nil
+}
+
+// Record the deadband values that passed in an entry once it is written.
+func (s *Stream) commitDeadbands(entry *stream.StreamEntry) {
+	s.deadbandMtx.Lock()
+	defer s.deadbandMtx.Unlock()
+
+	pending, ok := s.deadbandPending[entry]
+	if !ok {
+		return
+	}
+	if s.deadbandValues == nil {
+		s.deadbandValues = make(map[string]*recordedValue)
+	}
+	for path, value := range pending {
+		s.deadbandValues[path] = value
+	}
+	delete(s.deadbandPending, entry)
+}
+
+// Forget the deadband values of entries that were dropped or failed to
+// write.
+func (s *Stream) discardDeadbands(entries []*stream.StreamEntry) {
+	s.deadbandMtx.Lock()
+	defer s.deadbandMtx.Unlock()
+
+	for _, entry := range entries {
+		delete(s.deadbandPending, entry)
+	}
+}
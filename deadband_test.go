@@ -0,0 +1,51 @@
+package historian
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+)
+
+func TestDeadbandCommit(t *testing.T) {
+	s := &Stream{Data: &dbproto.Stream{
+		Deadbands: map[string]*dbproto.Deadband{"alt": {Absolute: 1}},
+	}}
+
+	steps := []struct {
+		value  float64
+		write  bool
+		passed bool
+	}{
+		{10, false, true},
+		// The first entry was never written, so nothing is recorded yet.
+		{10.5, true, true},
+		{11, true, false},
+		{12, false, true},
+		// Still compared with 10.5.
+		{11.2, true, false},
+		{11.6, true, true},
+	}
+	for i, step := range steps {
+		entry := &stream.StreamEntry{
+			Type:      stream.StreamEntryMutation,
+			Data:      stream.StateData{"alt": step.value},
+			Timestamp: time.Unix(int64(i), 0),
+		}
+		keep, err := applyDeadbands(s, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keep != step.passed {
+			t.Fatalf("step %d: expected passed %v, got %v", i, step.passed, keep)
+		}
+		if step.write {
+			s.commitDeadbands(entry)
+		}
+		s.discardDeadbands([]*stream.StreamEntry{entry})
+	}
+	if len(s.deadbandPending) != 0 {
+		t.Fatalf("expected no pending values, got %v", s.deadbandPending)
+	}
+}
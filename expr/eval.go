@@ -0,0 +1,228 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// Functions by name, with their number of arguments. 0 takes any number.
+var functions = map[string]int{
+	"abs":   1,
+	"sqrt":  1,
+	"floor": 1,
+	"ceil":  1,
+	"round": 1,
+	"min":   0,
+	"max":   0,
+}
+
+// Evaluate the expression against JSON data. Missing fields are null.
+// Results that can't be stored as JSON, like NaN and infinities, are errors.
+func (e *Expression) Eval(data map[string]interface{}) (interface{}, error) {
+	res, err := e.root.eval(data)
+	if err != nil {
+		return nil, err
+	}
+	if num, ok := res.(float64); ok && (math.IsNaN(num) || math.IsInf(num, 0)) {
+		return nil, fmt.Errorf("Result %v is not a finite number.", num)
+	}
+	return res, nil
+}
+
+func (n *literalNode) eval(data map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n *fieldNode) eval(data map[string]interface{}) (interface{}, error) {
+	var val interface{} = data
+	for _, key := range n.path {
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		val = obj[key]
+	}
+	return normalize(val), nil
+}
+
+func (n *unaryNode) eval(data map[string]interface{}) (interface{}, error) {
+	val, err := n.operand.eval(data)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !truthy(val), nil
+	}
+	num, ok := val.(float64)
+	if !ok {
+		return nil, fmt.Errorf("Cannot negate %v.", val)
+	}
+	return -num, nil
+}
+
+func (n *binaryNode) eval(data map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short circuit logical operators.
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(data)
+		return truthy(right), err
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(data)
+		return truthy(right), err
+	}
+
+	right, err := n.right.eval(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(left, right), nil
+	case "!=":
+		return !reflect.DeepEqual(left, right), nil
+	}
+
+	if n.op == "+" {
+		if ls, ok := left.(string); ok {
+			return ls + fmt.Sprint(right), nil
+		}
+		if rs, ok := right.(string); ok {
+			return fmt.Sprint(left) + rs, nil
+		}
+	}
+
+	if ls, ok := left.(string); ok {
+		if rs, ok := right.(string); ok {
+			return compare(n.op, strings.Compare(ls, rs))
+		}
+	}
+
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("Cannot apply %s to %v and %v.", n.op, left, right)
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, errors.New("Division by zero.")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, errors.New("Division by zero.")
+		}
+		return math.Mod(l, r), nil
+	}
+	switch {
+	case l < r:
+		return compare(n.op, -1)
+	case l > r:
+		return compare(n.op, 1)
+	default:
+		return compare(n.op, 0)
+	}
+}
+
+func compare(op string, cmp int) (interface{}, error) {
+	switch op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return nil, fmt.Errorf("Cannot apply %s to strings.", op)
+}
+
+func (n *callNode) eval(data map[string]interface{}) (interface{}, error) {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		val, err := arg.eval(data)
+		if err != nil {
+			return nil, err
+		}
+		num, ok := val.(float64)
+		if !ok {
+			return nil, fmt.Errorf("Cannot call %s with %v.", n.fn, val)
+		}
+		args[i] = num
+	}
+
+	switch n.fn {
+	case "abs":
+		return math.Abs(args[0]), nil
+	case "sqrt":
+		return math.Sqrt(args[0]), nil
+	case "floor":
+		return math.Floor(args[0]), nil
+	case "ceil":
+		return math.Ceil(args[0]), nil
+	case "round":
+		return math.Floor(args[0] + 0.5), nil
+	case "min", "max":
+		res := args[0]
+		for _, arg := range args[1:] {
+			if (n.fn == "min") == (arg < res) {
+				res = arg
+			}
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("Unknown function %s.", n.fn)
+}
+
+// Convert numbers to float64.
+func normalize(val interface{}) interface{} {
+	switch v := val.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	}
+	return val
+}
+
+func truthy(val interface{}) bool {
+	switch v := val.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	data := map[string]interface{}{
+		"n":    3,
+		"name": "rx",
+		"gps": map[string]interface{}{
+			"sats": 7.0,
+		},
+	}
+
+	tests := []struct {
+		src      string
+		expected interface{}
+	}{
+		// Precedence and associativity
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"10 - 4 - 3", 3.0},
+		{"12 / 3 / 2", 2.0},
+		{"7 % 4 * 2", 6.0},
+		{"-2 * 3", -6.0},
+		{"--2", 2.0},
+		{"1 < 2 == 2 < 3", true},
+		{"true || false && false", true},
+		{"!false && false", false},
+		{"1 + 1 == 2 && 2 * 2 == 4", true},
+
+		// Short circuiting skips errors on the right
+		{"false && 1 / 0", false},
+		{"true || 1 / 0", true},
+		{"0 && missing.field", false},
+		{"'' || 'x'", true},
+
+		// Fields, normalized to float64, missing ones are null
+		{"n + 1", 4.0},
+		{"gps.sats >= 4", true},
+		{"missing == null", true},
+		{"gps.sats.more == null", true},
+
+		// Strings and coercion
+		{"'a' + 1", "a1"},
+		{"1 + 'a'", "1a"},
+		{"name + '_' + n", "rx_3"},
+		{"\"b\" > 'a'", true},
+		{"'abc' <= 'abd'", true},
+		{"'1' == 1", false},
+		{"'it\\'s'", "it's"},
+		{"!''", true},
+
+		// Functions
+		{"max(1, 5, 3)", 5.0},
+		{"min(4, 2)", 2.0},
+		{"MAX(n, 1)", 3.0},
+		{"round(2.5)", 3.0},
+		{"floor(-1.5)", -2.0},
+		{"ceil(1.2)", 2.0},
+		{"abs(-3)", 3.0},
+		{"sqrt(16)", 4.0},
+		{"1e3 + 2.5E-1", 1000.25},
+	}
+	for _, test := range tests {
+		e, err := Compile(test.src)
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		res, err := e.Eval(data)
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		if !reflect.DeepEqual(res, test.expected) {
+			t.Fatalf("%s: expected %#v, got %#v", test.src, test.expected, res)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"gps.",
+		"gps.1",
+		"'open",
+		"1 # 2",
+		"1..2",
+		// Arity
+		"abs()",
+		"abs(1, 2)",
+		"sqrt()",
+		"max()",
+		"min(1,)",
+		"nope(1)",
+	}
+	for _, src := range tests {
+		if _, err := Compile(src); err == nil {
+			t.Fatalf("%q: expected a compile error", src)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []string{
+		"1 / 0",
+		"1 % 0",
+		"1 / 0 || true",
+		"-'a'",
+		"'a' * 2",
+		"'a' < 1",
+		"'a' && 1 / 0",
+		"missing + 1",
+		"abs('x')",
+		"max(1, name)",
+		// Not finite
+		"sqrt(-1)",
+		"sqrt(0 - n)",
+		"1e308 * 10",
+		"1e308 + 1e308",
+		"-1e308 - 1e308",
+	}
+	for _, src := range tests {
+		e, err := Compile(src)
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		if res, err := e.Eval(map[string]interface{}{"name": "rx", "n": 4.0}); err == nil {
+			t.Fatalf("%q: expected an error, got %v", src, res)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package expr
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	typ   tokenType
+	text  string
+	num   float64
+	start int
+}
+
+// Operators, longest first.
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "<", ">", "!", "(", ")", ",", "."}
+
+func tokenize(src string) ([]*token, error) {
+	var res []*token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.' || src[j] == 'e' || src[j] == 'E' ||
+				((src[j] == '-' || src[j] == '+') && (src[j-1] == 'e' || src[j-1] == 'E'))) {
+				j++
+			}
+			num, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid number %q at %d.", src[i:j], i)
+			}
+			res = append(res, &token{typ: tokenNumber, text: src[i:j], num: num, start: i})
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			var buf bytes.Buffer
+			for ; j < len(src) && rune(src[j]) != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				buf.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("Unterminated string at %d.", i)
+			}
+			res = append(res, &token{typ: tokenString, text: buf.String(), start: i})
+			i = j + 1
+		case c == '_' || c == '$' || unicode.IsLetter(c):
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '$' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			res = append(res, &token{typ: tokenIdent, text: src[i:j], start: i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("Unexpected %q at %d.", c, i)
+			}
+			res = append(res, &token{typ: tokenOperator, text: op, start: i})
+			i += len(op)
+		}
+	}
+	return append(res, &token{typ: tokenEOF, start: len(src)}), nil
+}
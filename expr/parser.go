@@ -0,0 +1,221 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A compiled expression.
+//
+// Expressions are made of numbers, 'strings', true, false, null, dotted
+// field paths like flight_state.velocity.speed, the operators
+// || && == != < <= > >= + - * / % ! and calls to abs, min, max, sqrt, floor,
+// ceil and round. + also joins strings.
+type Expression struct {
+	src  string
+	root node
+}
+
+// Parse an expression.
+func Compile(src string) (*Expression, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.typ != tokenEOF {
+		return nil, fmt.Errorf("Unexpected %q at %d.", tok.text, tok.start)
+	}
+	return &Expression{src: src, root: root}, nil
+}
+
+func (e *Expression) String() string {
+	return e.src
+}
+
+type node interface {
+	eval(data map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+type fieldNode struct {
+	path []string
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+type callNode struct {
+	fn   string
+	args []node
+}
+
+// Binary operators by precedence, lowest first.
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+type parser struct {
+	tokens []*token
+	pos    int
+}
+
+func (p *parser) peek() *token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() *token {
+	tok := p.tokens[p.pos]
+	if tok.typ != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) isOperator(ops ...string) bool {
+	tok := p.peek()
+	if tok.typ != tokenOperator {
+		return false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.isOperator(op) {
+		tok := p.peek()
+		return fmt.Errorf("Expected %q at %d.", op, tok.start)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator(precedence[level]...) {
+		op := p.next().text
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOperator("-", "!") {
+		op := p.next().text
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.typ {
+	case tokenNumber:
+		return &literalNode{value: tok.num}, nil
+	case tokenString:
+		return &literalNode{value: tok.text}, nil
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if p.isOperator("(") {
+			return p.parseCall(tok)
+		}
+		path := []string{tok.text}
+		for p.isOperator(".") {
+			p.next()
+			key := p.next()
+			if key.typ != tokenIdent {
+				return nil, fmt.Errorf("Expected a field name at %d.", key.start)
+			}
+			path = append(path, key.text)
+		}
+		return &fieldNode{path: path}, nil
+	case tokenOperator:
+		if tok.text == "(" {
+			inner, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		}
+	case tokenEOF:
+		return nil, errors.New("Unexpected end of expression.")
+	}
+	return nil, fmt.Errorf("Unexpected %q at %d.", tok.text, tok.start)
+}
+
+func (p *parser) parseCall(name *token) (node, error) {
+	fn := strings.ToLower(name.text)
+	arity, ok := functions[fn]
+	if !ok {
+		return nil, fmt.Errorf("Unknown function %s at %d.", name.text, name.start)
+	}
+	p.next()
+
+	call := &callNode{fn: fn}
+	for !p.isOperator(")") {
+		if len(call.args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+	}
+	p.next()
+
+	if (arity > 0 && len(call.args) != arity) || len(call.args) == 0 {
+		return nil, fmt.Errorf("Wrong number of arguments to %s at %d.", name.text, name.start)
+	}
+	return call, nil
+}
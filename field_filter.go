@@ -0,0 +1,79 @@
+package historian
+
+import (
+	"strings"
+
+	"github.com/fuserobotics/statestream"
+)
+
+// Keep only the included fields of data, then remove the excluded ones.
+// Paths are dotted, and a * matches any key at its level.
+func filterFields(data map[string]interface{}, include, exclude []string) map[string]interface{} {
+	if len(include) > 0 {
+		res := make(map[string]interface{})
+		for _, path := range include {
+			selectPath(data, res, strings.Split(path, "."))
+		}
+		data = res
+	}
+	for _, path := range exclude {
+		deletePath(data, strings.Split(path, "."))
+	}
+	return data
+}
+
+// Copy the fields matching a path from src into dst.
+func selectPath(src, dst map[string]interface{}, keys []string) {
+	for key, val := range src {
+		if keys[0] != "*" && keys[0] != key {
+			continue
+		}
+		if len(keys) == 1 {
+			dst[key] = val
+			continue
+		}
+		child, ok := asObject(val)
+		if !ok {
+			continue
+		}
+		dstChild, ok := asObject(dst[key])
+		if !ok {
+			dstChild = make(map[string]interface{})
+		}
+		selectPath(child, dstChild, keys[1:])
+		if len(dstChild) > 0 {
+			dst[key] = dstChild
+		}
+	}
+}
+
+func deletePath(data map[string]interface{}, keys []string) {
+	for key, val := range data {
+		if keys[0] != "*" && keys[0] != key {
+			continue
+		}
+		if len(keys) == 1 {
+			delete(data, key)
+			continue
+		}
+		if child, ok := asObject(val); ok {
+			deletePath(child, keys[1:])
+		}
+	}
+}
+
+// Strip the fields the stream doesn't record from a pushed entry.
+// Mutations left with no fields are dropped.
+func applyFieldFilter(s *Stream, entry *stream.StreamEntry) (bool, error) {
+	include := s.Data.IncludeFields
+	exclude := s.Data.ExcludeFields
+	if len(include) == 0 && len(exclude) == 0 {
+		return true, nil
+	}
+
+	entry.Data = stream.StateData(filterFields(entry.Data, include, exclude))
+	if entry.Type == stream.StreamEntryMutation && len(entry.Data) == 0 {
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,125 @@
+package historian
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+)
+
+func testState() map[string]interface{} {
+	return map[string]interface{}{
+		"altitude": 10.0,
+		"speed":    2.0,
+		"gps": map[string]interface{}{
+			"lat":  1.0,
+			"lon":  2.0,
+			"sats": 7.0,
+		},
+		"motors": map[string]interface{}{
+			"left":  map[string]interface{}{"rpm": 100.0, "temp": 40.0},
+			"right": map[string]interface{}{"rpm": 110.0, "temp": 41.0},
+		},
+	}
+}
+
+func TestFilterFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		expected map[string]interface{}
+	}{
+		{
+			name:     "include",
+			include:  []string{"altitude", "missing"},
+			expected: map[string]interface{}{"altitude": 10.0},
+		},
+		{
+			name:    "include nested",
+			include: []string{"gps.lat", "motors.*.rpm"},
+			expected: map[string]interface{}{
+				"gps": map[string]interface{}{"lat": 1.0},
+				"motors": map[string]interface{}{
+					"left":  map[string]interface{}{"rpm": 100.0},
+					"right": map[string]interface{}{"rpm": 110.0},
+				},
+			},
+		},
+		{
+			name:    "exclude nested",
+			exclude: []string{"speed", "gps", "motors.*.temp", "altitude.deeper"},
+			expected: map[string]interface{}{
+				"altitude": 10.0,
+				"motors": map[string]interface{}{
+					"left":  map[string]interface{}{"rpm": 100.0},
+					"right": map[string]interface{}{"rpm": 110.0},
+				},
+			},
+		},
+		{
+			// Exclusions apply to what the inclusions keep.
+			name:     "exclude takes precedence",
+			include:  []string{"gps", "speed"},
+			exclude:  []string{"gps.sats", "speed"},
+			expected: map[string]interface{}{"gps": map[string]interface{}{"lat": 1.0, "lon": 2.0}},
+		},
+	}
+	for _, test := range tests {
+		res := filterFields(testState(), test.include, test.exclude)
+		if !reflect.DeepEqual(res, test.expected) {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.expected, res)
+		}
+	}
+}
+
+func TestApplyFieldFilter(t *testing.T) {
+	s := &Stream{Data: &dbproto.Stream{ExcludeFields: []string{"speed"}}}
+	tests := []struct {
+		entryType stream.StreamEntryType
+		data      stream.StateData
+		keep      bool
+	}{
+		{stream.StreamEntryMutation, stream.StateData{"speed": 1.0}, false},
+		{stream.StreamEntryMutation, stream.StateData{"speed": 1.0, "altitude": 2.0}, true},
+		// Snapshots are kept even when emptied.
+		{stream.StreamEntrySnapshot, stream.StateData{"speed": 1.0}, true},
+	}
+	for i, test := range tests {
+		entry := &stream.StreamEntry{Type: test.entryType, Data: test.data}
+		keep, err := applyFieldFilter(s, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keep != test.keep {
+			t.Fatalf("entry %d: expected keep %v, got %v", i, test.keep, keep)
+		}
+		if _, ok := entry.Data["speed"]; ok {
+			t.Fatalf("entry %d: expected speed to be removed, got %v", i, entry.Data)
+		}
+	}
+}
+
+func TestRemoteConfigFieldFilters(t *testing.T) {
+	h := NewHistorian(nil)
+	h.KnownStreams["plane_1_fc_state"] = &dbproto.Stream{
+		Id:             "plane_1_fc_state",
+		DeviceHostname: "plane_1",
+		ComponentName:  "fc",
+		StateName:      "state",
+		IncludeFields:  []string{"gps.*"},
+		ExcludeFields:  []string{"gps.raw"},
+	}
+	config, err := h.BuildRemoteStreamConfig("plane_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Streams) != 1 {
+		t.Fatalf("expected one stream, got %v", config.Streams)
+	}
+	str := config.Streams[0]
+	if !reflect.DeepEqual(str.IncludeFields, []string{"gps.*"}) || !reflect.DeepEqual(str.ExcludeFields, []string{"gps.raw"}) {
+		t.Fatalf("expected the field filters to be published, got %v and %v", str.IncludeFields, str.ExcludeFields)
+	}
+}
@@ -2,32 +2,20 @@ package historian
 
 import (
 	"errors"
+	"sync"
+	"time"
+
 	"github.com/fuserobotics/historian/dbproto"
 	"github.com/fuserobotics/reporter/remote"
-	"github.com/fuserobotics/statestream"
-	r "gopkg.in/dancannon/gorethink.v2"
 )
 
-const streamTableName string = "streams"
-
-// Wrapper for response from RethinkDB with stream change
-type streamChange struct {
-	NewValue *dbproto.Stream `gorethink:"new_val,omitempty"`
-	OldValue *dbproto.Stream `gorethink:"old_val,omitempty"`
-	State    string          `gorethink:"state,omitempty"`
-}
-
-type streamEntryChange struct {
-	NewValue *stream.StreamEntry `gorethink:"new_val,omitempty"`
-	OldValue *stream.StreamEntry `gorethink:"old_val,omitempty"`
-	State    string              `gorethink:"state,omitempty"`
-}
-
 type Historian struct {
-	rctx    *r.Session
+	backend Backend
 	dispose chan bool
 
-	StreamsTable r.Term
+	// Guards Streams, KnownStreams, RemoteStreamConfigs and the stream
+	// definitions they are derived from
+	mtx sync.Mutex
 
 	// Map of loaded streams
 	Streams map[string]*Stream
@@ -38,42 +26,169 @@ type Historian struct {
 
 	// All known streams
 	KnownStreams map[string]*dbproto.Stream
+
+	// Streams in the streams table, by ID
+	definedStreams map[string]*dbproto.Stream
+	// Stream templates and device records, by ID
+	templates map[string]*dbproto.StreamTemplate
+	devices   map[string]*dbproto.Device
+	// Streams expanded from device templates, by ID
+	expandedStreams map[string]*dbproto.Stream
+	// Template streams with patterns for names, by device hostname
+	streamPatterns map[string][]*dbproto.Stream
+
+	// Problems in the definitions of known streams, by ID
+	streamErrors map[string]error
+	// Compiled computed fields of known streams, by ID
+	computedFields map[string][]*computedField
+	// Loaded scripts of known streams, by ID
+	scriptStages map[string][]Stage
+
+	// Stored with every entry this instance writes, see EntryMeta.
+	// Defaults to the hostname and a random suffix. Set before Init.
+	InstanceId string
+
+	// Register unknown streams pushed to by matching devices, nil to reject
+	// them.
+	AutoRegister *AutoRegisterPolicy
+
+	// What to do with the entries of deleted streams
+	TeardownPolicy TeardownPolicy
+
+	// Batch entry writes across streams, up to this many per batch.
+	// 0 or 1 writes every entry on its own. Set before Init.
+	WriteBatchSize int
+	// Longest time to hold an entry waiting for a batch to fill.
+	WriteBatchLatency time.Duration
+
+	// Extra stages applied to pushed entries, after the stream scripts.
+	// Set before Init.
+	Stages []Stage
+
+	// How often to prune streams with a retention policy, 0 to never.
+	// Set before Init.
+	RetentionInterval time.Duration
+	// How often to compact streams with a compaction schedule, 0 to never.
+	// Set before Init.
+	CompactionInterval time.Duration
+
+	// Serve history requests spanning at least this many buckets of a
+	// rollup tier from the coarsest such tier, 0 to always replay entries.
+	RollupQueryBuckets int
+
+	writes *writePipeline
+
+	// Running aggregators by stream ID, owned by aggregateThread
+	aggregators     map[string]*aggregator
+	aggregatesDirty chan bool
 }
 
-func NewHistorian(rctx *r.Session) *Historian {
+func NewHistorian(backend Backend) *Historian {
 	res := &Historian{
-		rctx:                rctx,
-		dispose:             make(chan bool, 1),
+		backend:             backend,
+		dispose:             make(chan bool),
 		Streams:             make(map[string]*Stream),
 		RemoteStreamConfigs: make(map[string]*remote.RemoteStreamConfig),
 		KnownStreams:        make(map[string]*dbproto.Stream),
-		StreamsTable:        r.Table(streamTableName),
+		definedStreams:      make(map[string]*dbproto.Stream),
+		templates:           make(map[string]*dbproto.StreamTemplate),
+		devices:             make(map[string]*dbproto.Device),
+		expandedStreams:     make(map[string]*dbproto.Stream),
+		streamPatterns:      make(map[string][]*dbproto.Stream),
+		streamErrors:        make(map[string]error),
+		computedFields:      make(map[string][]*computedField),
+		scriptStages:        make(map[string][]Stage),
+		aggregators:         make(map[string]*aggregator),
+		aggregatesDirty:     make(chan bool, 1),
+		InstanceId:          newInstanceId(),
 	}
 	return res
 }
 
+// Stop following the backend, dispose of the loaded streams and close the
+// write pipeline. Call at most once.
+func (h *Historian) Dispose() {
+	close(h.dispose)
+
+	h.mtx.Lock()
+	for id, str := range h.Streams {
+		str.Dispose()
+		delete(h.Streams, id)
+	}
+	h.mtx.Unlock()
+
+	if h.writes != nil {
+		h.writes.close()
+	}
+}
+
 // Returns pre-loaded stream or gets from DB
-func (h *Historian) GetStream(id string) (str *Stream, ferr error) {
+func (h *Historian) GetStream(id string) (*Stream, error) {
+	h.mtx.Lock()
 	if str, ok := h.Streams[id]; ok {
+		h.mtx.Unlock()
 		return str, nil
 	}
-
 	data, ok := h.KnownStreams[id]
+	h.mtx.Unlock()
 	if !ok {
 		return nil, errors.New("Stream not known.")
 	}
 
+	// Opening talks to the backend, don't hold up other streams meanwhile.
 	str, err := h.NewStream(data)
 	if err != nil {
 		return nil, err
 	}
 
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if existing, ok := h.Streams[id]; ok {
+		str.Dispose()
+		return existing, nil
+	}
+	if h.KnownStreams[id] != data {
+		str.Dispose()
+		return nil, errors.New("Stream changed while opening, try again.")
+	}
+
 	// Note: be sure to call Dispose() when deleting.
 	h.Streams[id] = str
 	return str, nil
 }
 
+// Returns a copy of the list of known streams
+func (h *Historian) GetKnownStreams() []*dbproto.Stream {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	res := make([]*dbproto.Stream, 0, len(h.KnownStreams))
+	for _, stream := range h.KnownStreams {
+		res = append(res, stream)
+	}
+	return res
+}
+
+// Returns the problem with the definition of a stream, if any.
+// Streams with problems keep ingesting, without the broken settings.
+func (h *Historian) GetStreamError(id string) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	return h.streamErrors[id]
+}
+
 func (h *Historian) GetDeviceStreams(hostname string) ([]*dbproto.Stream, error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	return h.deviceStreams(hostname), nil
+}
+
+// The streams of a device, by concrete names only: template patterns are
+// not passed on to the reporter until a push registers a stream matching
+// them. Call with mtx held.
+func (h *Historian) deviceStreams(hostname string) []*dbproto.Stream {
 	res := []*dbproto.Stream{}
 
 	for _, stream := range h.KnownStreams {
@@ -83,23 +198,25 @@ func (h *Historian) GetDeviceStreams(hostname string) ([]*dbproto.Stream, error)
 		res = append(res, stream)
 	}
 
-	return res, nil
+	return res
 }
 
 func (h *Historian) BuildRemoteStreamConfig(hostname string) (*remote.RemoteStreamConfig, error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
 	if resa, ok := h.RemoteStreamConfigs[hostname]; ok {
 		return resa, nil
 	}
 
-	streams, err := h.GetDeviceStreams(hostname)
-	if err != nil {
-		return nil, err
-	}
+	streams := h.deviceStreams(hostname)
 	res := &remote.RemoteStreamConfig{}
 	for _, stream := range streams {
 		rstream := &remote.RemoteStreamConfig_Stream{
-			ComponentId: stream.ComponentName,
-			StateId:     stream.StateName,
+			ComponentId:   stream.ComponentName,
+			StateId:       stream.StateName,
+			IncludeFields: stream.IncludeFields,
+			ExcludeFields: stream.ExcludeFields,
 		}
 		res.Streams = append(res.Streams, rstream)
 	}
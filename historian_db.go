@@ -1,33 +1,53 @@
 package historian
 
 import (
+	"errors"
+	"strings"
+
 	"github.com/fuserobotics/historian/dbproto"
 	"github.com/fuserobotics/reporter/remote"
 	"github.com/golang/glog"
-	r "gopkg.in/dancannon/gorethink.v2"
+	"github.com/golang/protobuf/proto"
 	"time"
 )
 
 func (h *Historian) Init() error {
+	if h.WriteBatchSize > 1 && h.writes == nil {
+		h.writes = newWritePipeline(h.backend, h.WriteBatchSize, h.WriteBatchLatency)
+	}
+
 	doneChan := make(chan error, 1)
 	go h.backgroundSync(doneChan)
 	for err := range doneChan {
 		return err
 	}
+	devicesDone := make(chan error, 1)
+	go h.deviceSync(devicesDone)
+	for err := range devicesDone {
+		return err
+	}
+
+	go h.aggregateThread()
+	if h.RetentionInterval > 0 {
+		go h.retentionThread()
+	}
+	if h.CompactionInterval > 0 {
+		go h.compactionThread()
+	}
 	return nil
 }
 
 func (h *Historian) backgroundSync(initChan chan error) (disposed bool) {
 	initDone := false
 	defer func() {
-		if initDone || initChan == nil {
+		if !disposed && (initDone || initChan == nil) {
 			glog.Warningf("Lost connection to streams table changes, retrying...")
 			// backoff retry
 			time.Sleep(time.Duration(3) * time.Second)
 			go h.backgroundSync(nil)
 		}
 	}()
-	cursor, err := h.loadStreams()
+	feed, err := h.loadStreams()
 	if err != nil {
 		glog.Warningf("Error loading streams from db: %v\n", err)
 		if initChan != nil {
@@ -42,32 +62,136 @@ func (h *Historian) backgroundSync(initChan chan error) (disposed bool) {
 	}
 	initDone = true
 
-	changesChan := make(chan streamChange)
-	cursor.Listen(changesChan)
+	defer feed.Close()
+
+	changesChan := feed.Changes()
 	for {
 		select {
 		case <-h.dispose:
 			return true
-		case cha := <-changesChan:
-			h.handleChange(&cha)
+		case cha, ok := <-changesChan:
+			if !ok {
+				glog.Warningf("Error listening to remote changes, %v", feed.Err())
+				return
+			}
+			h.handleChange(cha)
 		}
-		if err := cursor.Err(); err != nil {
-			glog.Warningf("Error listening to remote changes, %v", err)
-			return
+	}
+}
+
+func (h *Historian) handleChange(cha *StreamChange) {
+	var ids []string
+	h.mtx.Lock()
+	// an update must not look like a deletion
+	if cha.OldValue != nil && (cha.NewValue == nil || cha.NewValue.Id != cha.OldValue.Id) {
+		delete(h.definedStreams, cha.OldValue.Id)
+		ids = append(ids, cha.OldValue.Id)
+	}
+	if cha.NewValue != nil {
+		h.definedStreams[cha.NewValue.Id] = cha.NewValue
+		ids = append(ids, cha.NewValue.Id)
+	}
+	h.mtx.Unlock()
+
+	h.syncKnownStreams(ids)
+}
+
+// The definition a stream should have: the one in the streams table, or
+// else the one expanded from its device's template. Call with mtx held.
+func (h *Historian) desiredStream(id string) *dbproto.Stream {
+	if data, ok := h.definedStreams[id]; ok {
+		return data
+	}
+	return h.expandedStreams[id]
+}
+
+// Whether two stream definitions, either of which may be nil, are the same.
+func sameStream(a, b *dbproto.Stream) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return proto.Equal(a, b)
+}
+
+// Whether a stream definition needs storage the old one didn't have.
+func needsProvisioning(old, data *dbproto.Stream) bool {
+	if old == nil {
+		return true
+	}
+	if DbStreamTableName(old) != DbStreamTableName(data) || len(old.RollupBuckets) != len(data.RollupBuckets) {
+		return true
+	}
+	for i, bucket := range data.RollupBuckets {
+		if old.RollupBuckets[i] != bucket {
+			return true
 		}
 	}
+	return false
 }
 
-func (h *Historian) handleChange(cha *streamChange) {
-	if cha.State != "" {
-		return
+// Bring the known definitions of streams up to date. Storage for new
+// streams is provisioned, and that of deleted ones torn down, without
+// holding mtx.
+func (h *Historian) syncKnownStreams(ids []string) {
+	planned := make(map[string]*dbproto.Stream)
+	var provision []*dbproto.Stream
+	h.mtx.Lock()
+	for _, id := range ids {
+		data := h.desiredStream(id)
+		planned[id] = data
+		if data != nil && needsProvisioning(h.KnownStreams[id], data) {
+			provision = append(provision, data)
+		}
 	}
+	h.mtx.Unlock()
+
+	for _, data := range provision {
+		h.provisionStream(data)
+	}
+
+	var teardown []*dbproto.Stream
+	h.mtx.Lock()
+	for _, id := range ids {
+		// Changed meanwhile, the sync for that change applies it.
+		if !sameStream(h.desiredStream(id), planned[id]) {
+			continue
+		}
+		if old := h.updateKnownStream(id); old != nil {
+			teardown = append(teardown, old)
+		}
+	}
+	h.mtx.Unlock()
+
+	for _, data := range teardown {
+		h.teardownStream(data)
+	}
+}
 
+// Make the known definition of a stream the desired one. Returns the old
+// definition if the stream was deleted. Call with mtx held.
+func (h *Historian) updateKnownStream(id string) (deleted *dbproto.Stream) {
+	data := h.desiredStream(id)
+	old := h.KnownStreams[id]
+	if sameStream(old, data) {
+		return nil
+	}
+	h.applyStreamChange(&StreamChange{OldValue: old, NewValue: data})
+	if data == nil {
+		return old
+	}
+	return nil
+}
+
+// Replace the known definition of a stream. Call with mtx held.
+func (h *Historian) applyStreamChange(cha *StreamChange) {
 	invalidHostname := ""
 
 	if cha.OldValue != nil {
 		glog.Infof("Removing old stream %s", cha.OldValue.Id)
 		delete(h.KnownStreams, cha.OldValue.Id)
+		delete(h.streamErrors, cha.OldValue.Id)
+		delete(h.computedFields, cha.OldValue.Id)
+		delete(h.scriptStages, cha.OldValue.Id)
 		if oi, ok := h.Streams[cha.OldValue.Id]; ok {
 			oi.Dispose()
 			delete(h.Streams, cha.OldValue.Id)
@@ -78,45 +202,116 @@ func (h *Historian) handleChange(cha *streamChange) {
 	if cha.NewValue != nil {
 		glog.Infof("Adding new stream %s", cha.NewValue.Id)
 		h.KnownStreams[cha.NewValue.Id] = cha.NewValue
+
+		h.compileStream(cha.NewValue)
 		invalidHostname = cha.NewValue.DeviceHostname
 	}
 
 	if invalidHostname != "" {
 		delete(h.RemoteStreamConfigs, invalidHostname)
 	}
+	h.markAggregatesDirty()
 }
 
-// Full reload: loads in all streams from DB and swaps out maps.
-func (h *Historian) loadStreams() (rcrsr *r.Cursor, loadError error) {
-	cursor, err := h.StreamsTable.Changes(r.ChangesOpts{
-		IncludeInitial: true,
-		IncludeStates:  true,
-	}).Run(h.rctx)
-	defer func() {
-		if loadError != nil {
-			cursor.Close()
+// Create the storage of a stream and its rollup tiers, if it doesn't exist.
+func (h *Historian) provisionStream(data *dbproto.Stream) {
+	if err := h.backend.CreateStream(data); err != nil {
+		glog.Warningf("Error provisioning storage for %s: %v", data.Id, err)
+	}
+	for _, rollup := range RollupStreams(data) {
+		if err := h.backend.CreateStream(rollup); err != nil {
+			glog.Warningf("Error provisioning storage for %s: %v", rollup.Id, err)
 		}
-	}()
-	if err != nil {
-		return nil, err
 	}
+}
 
-	h.KnownStreams = make(map[string]*dbproto.Stream)
-	h.Streams = make(map[string]*Stream)
-	h.RemoteStreamConfigs = make(map[string]*remote.RemoteStreamConfig)
+// The teardown policy of a stream, falling back to the historian's.
+func (h *Historian) streamTeardownPolicy(data *dbproto.Stream) TeardownPolicy {
+	switch data.Teardown {
+	case dbproto.Stream_TEARDOWN_KEEP:
+		return TeardownKeep
+	case dbproto.Stream_TEARDOWN_DROP:
+		return TeardownDrop
+	case dbproto.Stream_TEARDOWN_ARCHIVE:
+		return TeardownArchive
+	}
+	return h.TeardownPolicy
+}
 
-	strm := &streamChange{}
-	for cursor.Next(strm) {
-		if strm.State == "ready" {
-			break
+// Dispose of the storage of a deleted stream and its rollup tiers according
+// to its teardown policy.
+func (h *Historian) teardownStream(data *dbproto.Stream) {
+	policy := h.streamTeardownPolicy(data)
+	if policy == TeardownKeep {
+		return
+	}
+	glog.Infof("Tearing down storage for deleted stream %s", data.Id)
+	if err := h.backend.DropStream(data, policy); err != nil {
+		glog.Warningf("Error tearing down storage for %s: %v", data.Id, err)
+	}
+	for _, rollup := range RollupStreams(data) {
+		if err := h.backend.DropStream(rollup, policy); err != nil {
+			glog.Warningf("Error tearing down storage for %s: %v", rollup.Id, err)
 		}
-		h.handleChange(strm)
-		strm = &streamChange{}
 	}
+}
 
-	if err := cursor.Err(); err != nil {
+// Compile the computed fields and scripts of a stream, recording problems
+// in streamErrors.
+func (h *Historian) compileStream(data *dbproto.Stream) {
+	var problems []string
+
+	computed, err := compileComputedFields(data)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	h.computedFields[data.Id] = computed
+
+	scripts, err := compileScriptStages(data)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	h.scriptStages[data.Id] = scripts
+
+	if len(problems) > 0 {
+		err := errors.New(strings.Join(problems, " "))
+		glog.Warningf("%v", err)
+		h.streamErrors[data.Id] = err
+	}
+}
+
+// Full reload: loads in all streams from the backend and brings the known
+// streams up to date with them.
+func (h *Historian) loadStreams() (StreamChangeFeed, error) {
+	streams, feed, err := h.backend.WatchStreams()
+	if err != nil {
 		return nil, err
 	}
 
-	return cursor, nil
+	h.mtx.Lock()
+	h.definedStreams = make(map[string]*dbproto.Stream)
+	for _, strm := range streams {
+		h.definedStreams[strm.Id] = strm
+	}
+	ids := h.knownStreamIds()
+	h.RemoteStreamConfigs = make(map[string]*remote.RemoteStreamConfig)
+	h.mtx.Unlock()
+
+	h.syncKnownStreams(ids)
+	return feed, nil
+}
+
+// IDs of every stream that is known or should be. Call with mtx held.
+func (h *Historian) knownStreamIds() []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, streams := range []map[string]*dbproto.Stream{h.KnownStreams, h.definedStreams, h.expandedStreams} {
+		for id := range streams {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
 }
@@ -0,0 +1,140 @@
+package historian_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/memory"
+	"github.com/fuserobotics/historian/dbproto"
+)
+
+// Memory backend recording the storage provisioned and torn down.
+type provisionBackend struct {
+	*memory.Backend
+
+	mtx     sync.Mutex
+	created map[string]int
+	dropped map[string]historian.TeardownPolicy
+}
+
+func newProvisionBackend() *provisionBackend {
+	return &provisionBackend{
+		Backend: memory.NewBackend(),
+		created: make(map[string]int),
+		dropped: make(map[string]historian.TeardownPolicy),
+	}
+}
+
+func (b *provisionBackend) CreateStream(data *dbproto.Stream) error {
+	b.mtx.Lock()
+	b.created[data.Id]++
+	b.mtx.Unlock()
+	return b.Backend.CreateStream(data)
+}
+
+func (b *provisionBackend) DropStream(data *dbproto.Stream, policy historian.TeardownPolicy) error {
+	b.mtx.Lock()
+	b.dropped[data.Id] = policy
+	b.mtx.Unlock()
+	return b.Backend.DropStream(data, policy)
+}
+
+func (b *provisionBackend) counts(id string) (created int, policy historian.TeardownPolicy, dropped bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	policy, dropped = b.dropped[id]
+	return b.created[id], policy, dropped
+}
+
+// Wait for the historian to catch up with a change.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func knownStream(h *historian.Historian, id string) *dbproto.Stream {
+	for _, data := range h.GetKnownStreams() {
+		if data.Id == id {
+			return data
+		}
+	}
+	return nil
+}
+
+func TestStreamProvisioning(t *testing.T) {
+	b := newProvisionBackend()
+	dropped := &dbproto.Stream{Id: "plane_1_fc_state", DeviceHostname: "plane_1", ComponentName: "fc", StateName: "state", Teardown: dbproto.Stream_TEARDOWN_DROP}
+	kept := &dbproto.Stream{Id: "plane_1_rx_state", DeviceHostname: "plane_1", ComponentName: "rx", StateName: "state"}
+	b.PutStream(dropped)
+	b.PutStream(kept)
+
+	h := historian.NewHistorian(b)
+	h.TeardownPolicy = historian.TeardownKeep
+	if err := h.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		change  func()
+		id      string
+		ready   func() bool
+		created int
+		dropped bool
+		policy  historian.TeardownPolicy
+	}{
+		{
+			name:    "initial load",
+			change:  func() {},
+			id:      dropped.Id,
+			ready:   func() bool { return knownStream(h, dropped.Id) != nil },
+			created: 1,
+		},
+		{
+			name: "update keeping the table",
+			change: func() {
+				b.PutStream(&dbproto.Stream{Id: dropped.Id, DeviceHostname: "plane_1", ComponentName: "fc", StateName: "state", Teardown: dbproto.Stream_TEARDOWN_DROP, LatenessWindow: 1000})
+			},
+			id: dropped.Id,
+			ready: func() bool {
+				data := knownStream(h, dropped.Id)
+				return data != nil && data.LatenessWindow == 1000
+			},
+			created: 1,
+		},
+		{
+			name:    "delete with a stream policy",
+			change:  func() { b.DeleteStream(dropped.Id) },
+			id:      dropped.Id,
+			ready:   func() bool { return knownStream(h, dropped.Id) == nil },
+			created: 1,
+			dropped: true,
+			policy:  historian.TeardownDrop,
+		},
+		{
+			name:    "delete with the default policy",
+			change:  func() { b.DeleteStream(kept.Id) },
+			id:      kept.Id,
+			ready:   func() bool { return knownStream(h, kept.Id) == nil },
+			created: 1,
+		},
+	}
+	for _, test := range tests {
+		test.change()
+		waitFor(t, test.name, test.ready)
+		created, policy, dropped := b.counts(test.id)
+		if created != test.created {
+			t.Fatalf("%s: expected %d creates, got %d", test.name, test.created, created)
+		}
+		if dropped != test.dropped || policy != test.policy {
+			t.Fatalf("%s: expected dropped %v with %v, got %v with %v", test.name, test.dropped, test.policy, dropped, policy)
+		}
+	}
+}
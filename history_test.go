@@ -0,0 +1,157 @@
+package historian_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/memory"
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+)
+
+var base = time.Unix(1000, 0).UTC()
+
+func at(sec int) time.Time {
+	return base.Add(time.Duration(sec) * time.Second)
+}
+
+// An entry at a second after base. Uppercase types are snapshots.
+func parseEntry(spec string) *stream.StreamEntry {
+	var typ byte
+	var sec int
+	fmt.Sscanf(spec, "%c%d", &typ, &sec)
+	entryType := stream.StreamEntryMutation
+	if typ == 'S' {
+		entryType = stream.StreamEntrySnapshot
+	}
+	return &stream.StreamEntry{
+		Type:      entryType,
+		Data:      stream.StateData{"altitude": float64(sec)},
+		Timestamp: at(sec),
+	}
+}
+
+// Load a stream holding entries like "S1" and "m2" into a new historian.
+func openHistory(t *testing.T, data *dbproto.Stream, specs []string) (*historian.Historian, *historian.Stream, historian.StreamBackend) {
+	b := memory.NewBackend()
+	data.Id = historian.DbStreamTableName(data)
+	b.PutStream(data)
+	storage, err := b.OpenStream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range specs {
+		if err := storage.SaveEntry(parseEntry(spec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := historian.NewHistorian(b)
+	if err := h.Init(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the stream to load", func() bool { return knownStream(h, data.Id) != nil })
+	str, err := h.GetStream(data.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, str, storage
+}
+
+// The entries left in storage, formatted like the specs.
+func historySpecs(t *testing.T, storage historian.StreamBackend) []string {
+	entries, err := storage.GetEntriesAfter(time.Time{}, at(1000), stream.StreamEntryAny, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make([]string, len(entries))
+	for i, entry := range entries {
+		typ := "m"
+		if entry.Type == stream.StreamEntrySnapshot {
+			typ = "S"
+		}
+		res[i] = fmt.Sprintf("%s%d", typ, int(entry.Timestamp.Sub(base)/time.Second))
+	}
+	return res
+}
+
+func expectSpecs(t *testing.T, name string, got, expected []string) {
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("%s: expected %v, got %v", name, expected, got)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	history := []string{"S1", "m2", "m3", "S4", "m5"}
+	now := at(10)
+
+	tests := []struct {
+		name      string
+		retention *dbproto.RetentionConfig
+		expected  []string
+	}{
+		{"within limits", &dbproto.RetentionConfig{MaxEntries: 10}, history},
+		{"entries up to a snapshot", &dbproto.RetentionConfig{MaxEntries: 2}, []string{"S4", "m5"}},
+		{"entries up to a mutation", &dbproto.RetentionConfig{MaxEntries: 3}, []string{"S3", "S4", "m5"}},
+		{"age", &dbproto.RetentionConfig{MaxAge: 6500}, []string{"S4", "m5"}},
+		{"everything expired", &dbproto.RetentionConfig{MaxAge: 1000}, []string{"S5"}},
+	}
+	for _, test := range tests {
+		data := &dbproto.Stream{DeviceHostname: "plane_1", ComponentName: "fc", StateName: "state", Retention: test.retention}
+		h, str, storage := openHistory(t, data, history)
+		if err := str.Prune(now); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		expectSpecs(t, test.name, historySpecs(t, storage), test.expected)
+		h.Dispose()
+	}
+}
+
+func TestCompact(t *testing.T) {
+	tests := []struct {
+		name     string
+		history  []string
+		from, to int
+		expected []string
+	}{
+		{
+			name:     "mutations",
+			history:  []string{"S0", "m1", "m2", "m3", "m4", "m5", "m6", "m7", "m9"},
+			from:     0,
+			to:       20,
+			expected: []string{"S0", "S5", "m6", "m7", "m9"},
+		},
+		{
+			name:     "quiet intervals",
+			history:  []string{"S0", "m1", "m12", "m16", "m17"},
+			from:     0,
+			to:       20,
+			expected: []string{"S0", "S5", "S15", "m16", "m17"},
+		},
+		{
+			name:     "range",
+			history:  []string{"S0", "m1", "m2", "m6", "m7", "m11", "m12"},
+			from:     5,
+			to:       10,
+			expected: []string{"S0", "m1", "m2", "S5", "S10", "m11", "m12"},
+		},
+		{
+			name:     "latest entry untouched",
+			history:  []string{"S0", "m3"},
+			from:     0,
+			to:       20,
+			expected: []string{"S0", "m3"},
+		},
+	}
+	for _, test := range tests {
+		data := &dbproto.Stream{DeviceHostname: "plane_1", ComponentName: "fc", StateName: "state"}
+		h, str, storage := openHistory(t, data, test.history)
+		if err := str.Compact(at(test.from), at(test.to), 5*time.Second); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		expectSpecs(t, test.name, historySpecs(t, storage), test.expected)
+		h.Dispose()
+	}
+}
@@ -0,0 +1,140 @@
+package historian
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/fuserobotics/statestream"
+)
+
+// A stage of the pipeline between a push and the stream.
+type Stage interface {
+	// Process a pushed entry. Return no entries to drop it, or several to
+	// split it.
+	Process(s *Stream, entry *stream.StreamEntry) ([]*stream.StreamEntry, error)
+}
+
+// Adapts a function that keeps or drops entries to a Stage.
+type FilterStage func(s *Stream, entry *stream.StreamEntry) (bool, error)
+
+func (f FilterStage) Process(s *Stream, entry *stream.StreamEntry) ([]*stream.StreamEntry, error) {
+	keep, err := f(s, entry)
+	if err != nil || !keep {
+		return nil, err
+	}
+	return []*stream.StreamEntry{entry}, nil
+}
+
+// Build the ingestion pipeline of a stream: the payload timestamp and field
+// filters, the stream scripts, the historian's own stages, then deadbands
+// and computed fields.
+func (s *Stream) buildPipeline() []Stage {
+	stages := []Stage{
+		FilterStage(applyPayloadTimestamp),
+		FilterStage(applyFieldFilter),
+	}
+	stages = append(stages, s.scripts...)
+	stages = append(stages, s.h.Stages...)
+	return append(stages,
+		FilterStage(applyDeadbands),
+		FilterStage(applyComputedFields),
+	)
+}
+
+// Outcome of ingesting a pushed entry.
+type IngestResult int
+
+const (
+	// Stored, possibly split into several entries.
+	IngestWritten IngestResult = iota
+	// Dropped by a stage of the pipeline.
+	IngestDropped
+	// Already stored, see IngestSequenced.
+	IngestDuplicate
+)
+
+type entriesByTime []*stream.StreamEntry
+
+func (e entriesByTime) Len() int           { return len(e) }
+func (e entriesByTime) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e entriesByTime) Less(i, j int) bool { return e[i].Timestamp.Before(e[j].Timestamp) }
+
+// Pass a pushed entry through the ingestion pipeline of the stream and write
+// the result. Dropped entries are not written and return no error. Entries
+// older than the live state are inserted into history if within the stream's
+// lateness window.
+func (s *Stream) Ingest(entry *stream.StreamEntry) (IngestResult, error) {
+	return s.ingest(entry, 0)
+}
+
+// Ingest an entry, committing a sequence number with the result if not 0.
+func (s *Stream) ingest(entry *stream.StreamEntry, seq uint64) (IngestResult, error) {
+	// Every entry out of a stage, to forget the deadband values of those
+	// not written.
+	staged := []*stream.StreamEntry{entry}
+	defer func() { s.discardDeadbands(staged) }()
+
+	entries := []*stream.StreamEntry{entry}
+	for _, stage := range s.pipeline {
+		var next []*stream.StreamEntry
+		for _, entry := range entries {
+			out, err := stage.Process(s, entry)
+			if err != nil {
+				return IngestWritten, err
+			}
+			next = append(next, out...)
+		}
+		entries = next
+		staged = append(staged, entries...)
+	}
+	if len(entries) == 0 {
+		return IngestDropped, nil
+	}
+
+	sort.Stable(entriesByTime(entries))
+	for i, entry := range entries {
+		// The sequence is committed with the last entry. A retry of a partly
+		// written split finds the earlier entries stored and skips them.
+		last := i == len(entries)-1
+		var meta *EntryMeta
+		if seq != 0 && last {
+			meta = &EntryMeta{Sequence: seq}
+		}
+		if err := s.writeIngested(entry, meta); err != nil {
+			// Another historian stored it meanwhile.
+			if err == ErrDuplicateSequence {
+				return IngestDuplicate, nil
+			}
+			if seq == 0 || last {
+				return IngestWritten, err
+			}
+			stored, serr := s.alreadyStored(entry)
+			if serr != nil {
+				return IngestWritten, serr
+			}
+			if !stored {
+				return IngestWritten, err
+			}
+		}
+		s.commitDeadbands(entry)
+	}
+	return IngestWritten, nil
+}
+
+// Check if an entry is stored already: an entry exists at its timestamp and
+// applying it to the state there changes nothing.
+func (s *Stream) alreadyStored(entry *stream.StreamEntry) (bool, error) {
+	existing, err := s.storage.GetEntriesBefore(entry.Timestamp.Add(time.Nanosecond), 1)
+	if err != nil {
+		return false, err
+	}
+	if len(existing) == 0 || !existing[0].Timestamp.Equal(entry.Timestamp) {
+		return false, nil
+	}
+	state, err := s.stateAt(entry.Timestamp)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(applyEntry(state, entry), stream.StateData(copyJson(state).(map[string]interface{}))), nil
+}
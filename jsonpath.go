@@ -0,0 +1,108 @@
+package historian
+
+import (
+	"strings"
+
+	"github.com/fuserobotics/statestream"
+)
+
+// Look up a dotted field path like flight_state.velocity.speed in JSON data.
+func lookupPath(data map[string]interface{}, path string) (interface{}, bool) {
+	var val interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := asObject(val)
+		if !ok {
+			return nil, false
+		}
+		val, ok = obj[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return val, true
+}
+
+// Set a dotted field path in JSON data, creating objects along the way.
+func setPath(data map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	obj := data
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := asObject(obj[key])
+		if !ok {
+			child = make(map[string]interface{})
+			obj[key] = child
+		}
+		obj = child
+	}
+	obj[keys[len(keys)-1]] = value
+}
+
+// Remove a dotted field path from JSON data, along with any objects left
+// empty.
+func removePath(data map[string]interface{}, path string) {
+	keys := strings.Split(path, ".")
+	if len(keys) > 1 {
+		child, ok := asObject(data[keys[0]])
+		if !ok {
+			return
+		}
+		removePath(child, strings.Join(keys[1:], "."))
+		if len(child) > 0 {
+			return
+		}
+	}
+	delete(data, keys[0])
+}
+
+// Deep merge the fields of src into dst.
+func mergeJson(dst, src map[string]interface{}) {
+	for key, val := range src {
+		if srcObj, ok := asObject(val); ok {
+			if dstObj, ok := asObject(dst[key]); ok {
+				mergeJson(dstObj, srcObj)
+				continue
+			}
+		}
+		dst[key] = copyJson(val)
+	}
+}
+
+func asObject(val interface{}) (map[string]interface{}, bool) {
+	switch obj := val.(type) {
+	case map[string]interface{}:
+		return obj, true
+	case stream.StateData:
+		return obj, true
+	default:
+		return nil, false
+	}
+}
+
+// Deep copy JSON data.
+func copyJson(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			res[key] = copyJson(child)
+		}
+		return res
+	case stream.StateData:
+		return copyJson(map[string]interface{}(v))
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, child := range v {
+			res[i] = copyJson(child)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+func copyState(state stream.StateData) stream.StateData {
+	if state == nil {
+		return stream.StateData{}
+	}
+	return stream.StateData(copyJson(map[string]interface{}(state)).(map[string]interface{}))
+}
@@ -0,0 +1,263 @@
+package historian
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fuserobotics/statestream"
+)
+
+// Timestamp of the live state of the stream.
+func (s *Stream) liveTimestamp() (time.Time, error) {
+	writeCursor, err := s.StateStream.WriteCursor()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var ts time.Time
+	err = writeCursor.WriteGuard(func() error {
+		ts = writeCursor.ComputedTimestamp()
+		return nil
+	})
+	return ts, err
+}
+
+// Whether a late entry at timestamp may be inserted into history, given the
+// timestamp of the live state.
+func (s *Stream) withinLatenessWindow(timestamp, live time.Time) bool {
+	window := time.Duration(s.Data.LatenessWindow) * time.Millisecond
+	return window > 0 && !timestamp.Before(live.Add(-window))
+}
+
+// Write an entry with meta, inserting it into history if it is older than the
+// live state.
+func (s *Stream) writeIngested(entry *stream.StreamEntry, meta *EntryMeta) error {
+	live, err := s.liveTimestamp()
+	if err != nil {
+		return err
+	}
+	if entry.Timestamp.After(live) {
+		// Later entries build on the state late entries changed.
+		if err := s.flushLate(); err != nil {
+			return err
+		}
+		return s.writeEntry(entry, meta)
+	}
+	if !s.withinLatenessWindow(entry.Timestamp, live) {
+		return fmt.Errorf("Entry at %v is older than the lateness window of %s.", entry.Timestamp, s.Data.Id)
+	}
+	return s.insertLate(entry, meta)
+}
+
+// Work owed by late entries: the rollup buckets to rebuild, and whether to
+// reload the live state.
+type lateWork struct {
+	timestamps []time.Time
+	reload     bool
+	reloadAt   time.Time
+}
+
+// Begin a batch of ingestion, returning a func to end it. The rollup rebuilds
+// and live state reload owed by late entries are deferred until the batch
+// ends or an entry is written to the live state, so a backlog of late
+// entries costs one of each.
+func (s *Stream) BeginBatch() func() error {
+	s.lateMtx.Lock()
+	s.lateBatches++
+	s.lateMtx.Unlock()
+
+	var once sync.Once
+	return func() (err error) {
+		once.Do(func() {
+			s.lateMtx.Lock()
+			s.lateBatches--
+			pending := s.lateBatches == 0
+			s.lateMtx.Unlock()
+			if pending {
+				err = s.flushLate()
+			}
+		})
+		return
+	}
+}
+
+// Rebuild the rollup buckets and reload the live state as late entries
+// require.
+func (s *Stream) flushLate() error {
+	s.lateMtx.Lock()
+	work := s.late
+	s.late = lateWork{}
+	s.lateMtx.Unlock()
+	if len(work.timestamps) == 0 && !work.reload {
+		return nil
+	}
+
+	s.maintenanceMtx.Lock()
+	defer s.maintenanceMtx.Unlock()
+
+	for _, tier := range s.rollups {
+		if err := tier.rebuild(s, work.timestamps); err != nil {
+			return err
+		}
+	}
+	if !work.reload {
+		return nil
+	}
+	return s.reloadLiveState(work.reloadAt, true)
+}
+
+// Insert an entry older than the live state into history. Later snapshots
+// still holding the values it replaced are rewritten, the rollup buckets
+// touched are rebuilt, and if the change reaches the latest entry the live
+// state is reloaded from the stored entries. Outside a batch, see
+// BeginBatch, this is done before returning.
+func (s *Stream) InsertLate(entry *stream.StreamEntry) error {
+	return s.insertLate(entry, nil)
+}
+
+// Insert a late entry, storing meta with it if set.
+func (s *Stream) insertLate(entry *stream.StreamEntry, meta *EntryMeta) error {
+	if err := s.insertLateEntry(entry, meta); err != nil {
+		return err
+	}
+
+	s.lateMtx.Lock()
+	batched := s.lateBatches > 0
+	s.lateMtx.Unlock()
+	if batched {
+		return nil
+	}
+	return s.flushLate()
+}
+
+func (s *Stream) insertLateEntry(entry *stream.StreamEntry, meta *EntryMeta) error {
+	s.maintenanceMtx.Lock()
+	defer s.maintenanceMtx.Unlock()
+
+	existing, err := s.storage.GetEntriesBefore(entry.Timestamp.Add(time.Nanosecond), 1)
+	if err != nil {
+		return err
+	}
+	if len(existing) != 0 && existing[0].Timestamp.Equal(entry.Timestamp) {
+		return errors.New("An entry already exists at that timestamp.")
+	}
+	before, err := s.stateAt(entry.Timestamp.Add(-time.Nanosecond))
+	if err != nil {
+		return err
+	}
+	if err := s.saveEntry(entry, meta); err != nil {
+		return err
+	}
+
+	amended, reachesHead, err := s.rederiveSnapshots(entry.Timestamp, before)
+	// Whatever was written needs rebuilding, even after an error.
+	s.lateMtx.Lock()
+	s.late.timestamps = append(append(s.late.timestamps, entry.Timestamp), amended...)
+	if reachesHead && (!s.late.reload || entry.Timestamp.Before(s.late.reloadAt)) {
+		s.late.reload = true
+		s.late.reloadAt = entry.Timestamp
+	}
+	s.lateMtx.Unlock()
+	return err
+}
+
+// Walk the snapshots after an entry inserted at timestamp, which were
+// computed without it, and carry its changes to the state over to those
+// still holding the values from before, given as the state before it. Stops
+// once later readings have replaced every value it changed. Returns the
+// timestamps of the amended snapshots, and whether the changes reach the
+// latest entry.
+func (s *Stream) rederiveSnapshots(timestamp time.Time, before stream.StateData) ([]time.Time, bool, error) {
+	after, err := s.stateAt(timestamp)
+	if err != nil {
+		return nil, false, err
+	}
+	paths := changedPaths("", before, after, nil)
+
+	var amended []time.Time
+	for len(paths) != 0 {
+		next, err := s.storage.GetEntryAfter(timestamp, stream.StreamEntrySnapshot)
+		if err != nil {
+			return amended, false, err
+		}
+		if next == nil {
+			return amended, true, nil
+		}
+		timestamp = next.Timestamp
+
+		data := copyState(next.Data)
+		var carried []string
+		for _, path := range paths {
+			old, hadOld := lookupPath(before, path)
+			cur, hasCur := lookupPath(data, path)
+			if hadOld != hasCur || !reflect.DeepEqual(old, cur) {
+				// Replaced by a later reading.
+				continue
+			}
+			if val, ok := lookupPath(after, path); ok {
+				setPath(data, path, copyJson(val))
+			} else {
+				removePath(data, path)
+			}
+			carried = append(carried, path)
+		}
+		paths = carried
+		if len(carried) == 0 {
+			break
+		}
+		if err := s.AmendEntry(&stream.StreamEntry{
+			Type:      stream.StreamEntrySnapshot,
+			Data:      data,
+			Timestamp: next.Timestamp,
+		}, next.Timestamp); err != nil {
+			return amended, false, err
+		}
+		amended = append(amended, next.Timestamp)
+	}
+	return amended, false, nil
+}
+
+// Append the dotted paths of the leaves that differ between two states.
+func changedPaths(prefix string, before, after map[string]interface{}, res []string) []string {
+	keys := make(map[string]bool, len(before)+len(after))
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+	for key := range keys {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		oldObj, oldIsObj := asObject(before[key])
+		newObj, newIsObj := asObject(after[key])
+		if oldIsObj && newIsObj {
+			res = changedPaths(path, oldObj, newObj, res)
+			continue
+		}
+		_, hadOld := before[key]
+		_, hasNew := after[key]
+		if hadOld != hasNew || !reflect.DeepEqual(before[key], after[key]) {
+			res = append(res, path)
+		}
+	}
+	return res
+}
+
+// Reload the live state after a late entry at timestamp changed it.
+func (s *Stream) reloadLiveState(timestamp time.Time, local bool) error {
+	s.StateStream.ResetWriter()
+	writeCursor, err := s.StateStream.WriteCursor()
+	if err != nil {
+		return err
+	}
+	return s.notifyStateChange(writeCursor, &StateChange{
+		Timestamp: timestamp,
+		Local:     local,
+		Late:      true,
+	})
+}
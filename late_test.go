@@ -0,0 +1,139 @@
+package historian_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+)
+
+func stateEntry(sec int, entryType stream.StreamEntryType, data stream.StateData) *stream.StreamEntry {
+	return &stream.StreamEntry{Type: entryType, Data: data, Timestamp: at(sec)}
+}
+
+// The data of the stored entry at sec.
+func storedData(t *testing.T, storage historian.StreamBackend, sec int) stream.StateData {
+	entries, err := storage.GetEntriesAfter(at(sec-1), at(sec), stream.StreamEntryAny, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 {
+		t.Fatalf("no entry at %d", sec)
+	}
+	return entries[0].Data
+}
+
+func TestInsertLate(t *testing.T) {
+	h, s, storage := openHistory(t, &dbproto.Stream{DeviceHostname: "plane_1", ComponentName: "fc", StateName: "state"}, nil)
+	defer h.Dispose()
+	history := []*stream.StreamEntry{
+		stateEntry(10, stream.StreamEntrySnapshot, stream.StateData{"a": 1.0, "b": 1.0}),
+		stateEntry(20, stream.StreamEntryMutation, stream.StateData{"b": 2.0}),
+		stateEntry(30, stream.StreamEntrySnapshot, stream.StateData{"a": 1.0, "b": 2.0}),
+		stateEntry(40, stream.StreamEntryMutation, stream.StateData{"a": 5.0}),
+		stateEntry(50, stream.StreamEntrySnapshot, stream.StateData{"a": 5.0, "b": 2.0}),
+	}
+	for _, entry := range history {
+		if err := storage.SaveEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mtx sync.Mutex
+	var reloads []int
+	cancel := s.OnStateChange(func(change *historian.StateChange) {
+		if change.Late {
+			mtx.Lock()
+			reloads = append(reloads, int(change.Timestamp.Sub(base).Seconds()))
+			mtx.Unlock()
+		}
+	})
+	defer cancel()
+
+	tests := []struct {
+		name     string
+		entry    *stream.StreamEntry
+		expected map[int]stream.StateData
+		reloads  []int
+	}{
+		{
+			name:  "superseded before the head",
+			entry: stateEntry(15, stream.StreamEntryMutation, stream.StateData{"a": 3.0}),
+			expected: map[int]stream.StateData{
+				30: {"a": 3.0, "b": 2.0},
+				50: {"a": 5.0, "b": 2.0},
+			},
+		},
+		{
+			name:  "reaching the head",
+			entry: stateEntry(45, stream.StreamEntryMutation, stream.StateData{"b": 7.0}),
+			expected: map[int]stream.StateData{
+				50: {"a": 5.0, "b": 7.0},
+			},
+			reloads: []int{45},
+		},
+	}
+	for _, test := range tests {
+		mtx.Lock()
+		reloads = nil
+		mtx.Unlock()
+		if err := s.InsertLate(test.entry); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for sec, data := range test.expected {
+			if got := storedData(t, storage, sec); !reflect.DeepEqual(got, data) {
+				t.Fatalf("%s: expected %v at %d, got %v", test.name, data, sec, got)
+			}
+		}
+		mtx.Lock()
+		got := reloads
+		mtx.Unlock()
+		if !reflect.DeepEqual(got, test.reloads) {
+			t.Fatalf("%s: expected reloads at %v, got %v", test.name, test.reloads, got)
+		}
+	}
+
+	if err := s.InsertLate(stateEntry(45, stream.StreamEntryMutation, stream.StateData{"b": 8.0})); err == nil {
+		t.Fatal("expected an error inserting over an existing entry")
+	}
+}
+
+func TestInsertLateBatch(t *testing.T) {
+	h, s, storage := openHistory(t, &dbproto.Stream{DeviceHostname: "plane_1", ComponentName: "fc", StateName: "state"}, []string{"S10", "m20"})
+	defer h.Dispose()
+
+	var mtx sync.Mutex
+	var reloads []int
+	cancel := s.OnStateChange(func(change *historian.StateChange) {
+		if change.Late {
+			mtx.Lock()
+			reloads = append(reloads, int(change.Timestamp.Sub(base).Seconds()))
+			mtx.Unlock()
+		}
+	})
+	defer cancel()
+
+	end := s.BeginBatch()
+	for _, sec := range []int{15, 12, 17} {
+		if err := s.InsertLate(stateEntry(sec, stream.StreamEntryMutation, stream.StateData{"late": float64(sec)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtx.Lock()
+	if len(reloads) != 0 {
+		t.Fatalf("expected no reload before the batch ends, got %v", reloads)
+	}
+	mtx.Unlock()
+	if err := end(); err != nil {
+		t.Fatal(err)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if !reflect.DeepEqual(reloads, []int{12}) {
+		t.Fatalf("expected one reload from the earliest late entry, got %v", reloads)
+	}
+	expectSpecs(t, "stored", historySpecs(t, storage), []string{"S10", "m12", "m15", "m17", "m20"})
+}
@@ -0,0 +1,33 @@
+package historian
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceThreadsStopOnDispose(t *testing.T) {
+	threads := map[string]func(h *Historian){
+		"retention":  (*Historian).retentionThread,
+		"compaction": (*Historian).compactionThread,
+	}
+	for name, thread := range threads {
+		h := &Historian{
+			dispose:            make(chan bool),
+			RetentionInterval:  time.Millisecond,
+			CompactionInterval: time.Millisecond,
+		}
+		done := make(chan bool)
+		go func() {
+			thread(h)
+			close(done)
+		}()
+		time.Sleep(5 * time.Millisecond)
+		close(h.dispose)
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%s thread kept running after dispose", name)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package historian
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+)
+
+// Parse a payload time in the given unit.
+func parsePayloadTime(val interface{}, unit dbproto.PayloadTimestamp_Unit) (time.Time, bool) {
+	if unit == dbproto.PayloadTimestamp_RFC3339 {
+		str, ok := val.(string)
+		if !ok {
+			return time.Time{}, false
+		}
+		ts, err := time.Parse(time.RFC3339Nano, str)
+		return ts, err == nil
+	}
+
+	num, ok := toFloat(val)
+	if !ok {
+		return time.Time{}, false
+	}
+	scale := float64(time.Second)
+	if unit == dbproto.PayloadTimestamp_MILLISECONDS {
+		scale = float64(time.Millisecond)
+	}
+	return time.Unix(0, int64(num*scale)), true
+}
+
+// Set the entry timestamp from the configured payload field.
+func applyPayloadTimestamp(s *Stream, entry *stream.StreamEntry) (bool, error) {
+	conf := s.Data.GetPayloadTimestamp()
+	if conf == nil || conf.Field == "" {
+		return true, nil
+	}
+
+	if val, ok := lookupPath(entry.Data, conf.Field); ok {
+		if ts, ok := parsePayloadTime(val, conf.Unit); ok {
+			maxSkew := time.Duration(conf.MaxSkew) * time.Millisecond
+			skew := ts.Sub(entry.Timestamp)
+			if skew < 0 {
+				skew = -skew
+			}
+			if maxSkew == 0 || skew <= maxSkew {
+				entry.Timestamp = ts
+				return true, nil
+			}
+		}
+	}
+
+	switch conf.Fallback {
+	case dbproto.PayloadTimestamp_DROP:
+		return false, nil
+	case dbproto.PayloadTimestamp_REJECT:
+		return false, errors.New("Entry has no plausible payload timestamp.")
+	default:
+		return true, nil
+	}
+}
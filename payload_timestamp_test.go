@@ -0,0 +1,86 @@
+package historian
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+)
+
+func TestParsePayloadTime(t *testing.T) {
+	expected := time.Unix(1475439400, 500*int64(time.Millisecond))
+	tests := []struct {
+		name string
+		val  interface{}
+		unit dbproto.PayloadTimestamp_Unit
+		ok   bool
+	}{
+		{"seconds", 1475439400.5, dbproto.PayloadTimestamp_SECONDS, true},
+		{"milliseconds", 1475439400500.0, dbproto.PayloadTimestamp_MILLISECONDS, true},
+		{"rfc3339", "2016-10-02T20:16:40.5Z", dbproto.PayloadTimestamp_RFC3339, true},
+		{"rfc3339 with offset", "2016-10-02T22:16:40.5+02:00", dbproto.PayloadTimestamp_RFC3339, true},
+		{"number as rfc3339", 1475439400.5, dbproto.PayloadTimestamp_RFC3339, false},
+		{"invalid rfc3339", "yesterday", dbproto.PayloadTimestamp_RFC3339, false},
+		{"string as seconds", "yesterday", dbproto.PayloadTimestamp_SECONDS, false},
+	}
+	for _, test := range tests {
+		ts, ok := parsePayloadTime(test.val, test.unit)
+		if ok != test.ok {
+			t.Fatalf("%s: expected ok %v, got %v", test.name, test.ok, ok)
+		}
+		if ok && !ts.Equal(expected) {
+			t.Fatalf("%s: expected %v, got %v", test.name, expected, ts)
+		}
+	}
+}
+
+func TestApplyPayloadTimestamp(t *testing.T) {
+	entryTime := time.Unix(1475439400, 0)
+	payloadTime := entryTime.Add(-time.Minute)
+	fallbacks := []dbproto.PayloadTimestamp_Fallback{
+		dbproto.PayloadTimestamp_ENTRY_TIME,
+		dbproto.PayloadTimestamp_DROP,
+		dbproto.PayloadTimestamp_REJECT,
+	}
+	tests := []struct {
+		name    string
+		data    stream.StateData
+		maxSkew uint64
+		// Whether the payload time is used, or the fallback applies.
+		plausible bool
+	}{
+		{"plausible", stream.StateData{"gps": map[string]interface{}{"time": float64(payloadTime.Unix())}}, 120000, true},
+		{"no skew limit", stream.StateData{"gps": map[string]interface{}{"time": float64(payloadTime.Unix())}}, 0, true},
+		{"missing field", stream.StateData{"altitude": 1.0}, 0, false},
+		{"unparseable", stream.StateData{"gps": map[string]interface{}{"time": "now"}}, 0, false},
+		{"too far off", stream.StateData{"gps": map[string]interface{}{"time": float64(payloadTime.Unix())}}, 30000, false},
+	}
+	for _, fallback := range fallbacks {
+		for _, test := range tests {
+			s := &Stream{Data: &dbproto.Stream{PayloadTimestamp: &dbproto.PayloadTimestamp{
+				Field:    "gps.time",
+				MaxSkew:  test.maxSkew,
+				Fallback: fallback,
+			}}}
+			entry := &stream.StreamEntry{Data: test.data, Timestamp: entryTime}
+			keep, err := applyPayloadTimestamp(s, entry)
+
+			name := fallback.String() + " " + test.name
+			expectedKeep := test.plausible || fallback == dbproto.PayloadTimestamp_ENTRY_TIME
+			if (err != nil) != (!test.plausible && fallback == dbproto.PayloadTimestamp_REJECT) {
+				t.Fatalf("%s: unexpected error %v", name, err)
+			}
+			if err == nil && keep != expectedKeep {
+				t.Fatalf("%s: expected keep %v, got %v", name, expectedKeep, keep)
+			}
+			expectedTime := entryTime
+			if test.plausible {
+				expectedTime = payloadTime
+			}
+			if !entry.Timestamp.Equal(expectedTime) {
+				t.Fatalf("%s: expected timestamp %v, got %v", name, expectedTime, entry.Timestamp)
+			}
+		}
+	}
+}
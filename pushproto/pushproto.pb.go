@@ -0,0 +1,315 @@
+// Code generated by protoc-gen-go.
+// source: github.com/fuserobotics/historian/pushproto/pushproto.proto
+// DO NOT EDIT!
+
+/*
+Package pushproto is a generated protocol buffer package.
+
+It is generated from these files:
+	github.com/fuserobotics/historian/pushproto/pushproto.proto
+
+It has these top-level messages:
+	PushStreamEntriesRequest
+	PushStreamEntry
+	PushStreamEntriesResponse
+	PushStreamEntryAck
+*/
+package pushproto
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+import remote "github.com/fuserobotics/reporter/remote"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+// Entries for many streams of a device, written in order. Batches are
+// limited to 1000 entries.
+type PushStreamEntriesRequest struct {
+	// Identifier of the pushing device.
+	HostIdentifier string             `protobuf:"bytes,1,opt,name=host_identifier,json=hostIdentifier" json:"host_identifier,omitempty"`
+	Entries        []*PushStreamEntry `protobuf:"bytes,2,rep,name=entries" json:"entries,omitempty"`
+	// CRC of the remote stream config the device has.
+	ConfigCrc32 uint32 `protobuf:"varint,3,opt,name=config_crc32,json=configCrc32" json:"config_crc32,omitempty"`
+}
+
+func (m *PushStreamEntriesRequest) Reset()                    { *m = PushStreamEntriesRequest{} }
+func (m *PushStreamEntriesRequest) String() string            { return proto.CompactTextString(m) }
+func (*PushStreamEntriesRequest) ProtoMessage()               {}
+func (*PushStreamEntriesRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *PushStreamEntriesRequest) GetEntries() []*PushStreamEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+// An entry for one stream of a device.
+type PushStreamEntry struct {
+	ComponentId string                    `protobuf:"bytes,1,opt,name=component_id,json=componentId" json:"component_id,omitempty"`
+	StateId     string                    `protobuf:"bytes,2,opt,name=state_id,json=stateId" json:"state_id,omitempty"`
+	Entry       *remote.RemoteStreamEntry `protobuf:"bytes,3,opt,name=entry" json:"entry,omitempty"`
+	// Sequence number assigned by the device, increasing with every entry
+	// pushed to the stream. Entries at or below the last stored sequence number
+	// are acknowledged without being stored again. 0 disables deduplication.
+	Sequence uint64 `protobuf:"varint,4,opt,name=sequence" json:"sequence,omitempty"`
+}
+
+func (m *PushStreamEntry) Reset()                    { *m = PushStreamEntry{} }
+func (m *PushStreamEntry) String() string            { return proto.CompactTextString(m) }
+func (*PushStreamEntry) ProtoMessage()               {}
+func (*PushStreamEntry) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *PushStreamEntry) GetEntry() *remote.RemoteStreamEntry {
+	if m != nil {
+		return m.Entry
+	}
+	return nil
+}
+
+type PushStreamEntriesResponse struct {
+	// One acknowledgement per request entry, in the same order.
+	Acks []*PushStreamEntryAck `protobuf:"bytes,1,rep,name=acks" json:"acks,omitempty"`
+	// CRC of the current remote stream config of the device.
+	ConfigCrc32 uint32 `protobuf:"varint,2,opt,name=config_crc32,json=configCrc32" json:"config_crc32,omitempty"`
+	// The current remote stream config, if the device's is out of date.
+	Config *remote.RemoteStreamConfig `protobuf:"bytes,3,opt,name=config" json:"config,omitempty"`
+}
+
+func (m *PushStreamEntriesResponse) Reset()                    { *m = PushStreamEntriesResponse{} }
+func (m *PushStreamEntriesResponse) String() string            { return proto.CompactTextString(m) }
+func (*PushStreamEntriesResponse) ProtoMessage()               {}
+func (*PushStreamEntriesResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *PushStreamEntriesResponse) GetAcks() []*PushStreamEntryAck {
+	if m != nil {
+		return m.Acks
+	}
+	return nil
+}
+
+func (m *PushStreamEntriesResponse) GetConfig() *remote.RemoteStreamConfig {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+type PushStreamEntryAck struct {
+	// Whether the entry was accepted: stored, dropped by the stream's pipeline,
+	// or already stored.
+	Ok bool `protobuf:"varint,1,opt,name=ok" json:"ok,omitempty"`
+	// Why the entry was not accepted.
+	Error string `protobuf:"bytes,2,opt,name=error" json:"error,omitempty"`
+	// The entry's sequence number was already stored.
+	Duplicate bool `protobuf:"varint,3,opt,name=duplicate" json:"duplicate,omitempty"`
+	// The stream's pipeline dropped the entry instead of storing it.
+	Dropped bool `protobuf:"varint,4,opt,name=dropped" json:"dropped,omitempty"`
+}
+
+func (m *PushStreamEntryAck) Reset()                    { *m = PushStreamEntryAck{} }
+func (m *PushStreamEntryAck) String() string            { return proto.CompactTextString(m) }
+func (*PushStreamEntryAck) ProtoMessage()               {}
+func (*PushStreamEntryAck) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func init() {
+	proto.RegisterType((*PushStreamEntriesRequest)(nil), "pushproto.PushStreamEntriesRequest")
+	proto.RegisterType((*PushStreamEntry)(nil), "pushproto.PushStreamEntry")
+	proto.RegisterType((*PushStreamEntriesResponse)(nil), "pushproto.PushStreamEntriesResponse")
+	proto.RegisterType((*PushStreamEntryAck)(nil), "pushproto.PushStreamEntryAck")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for HistorianRemoteService service
+
+type HistorianRemoteServiceClient interface {
+	// Push a batch of entries. Entries for the same stream are written in
+	// order, and once one fails the rest for that stream are not attempted.
+	PushStreamEntries(ctx context.Context, in *PushStreamEntriesRequest, opts ...grpc.CallOption) (*PushStreamEntriesResponse, error)
+	// Push a stream of batches, receiving a response for each batch.
+	StreamPushEntries(ctx context.Context, opts ...grpc.CallOption) (HistorianRemoteService_StreamPushEntriesClient, error)
+}
+
+type historianRemoteServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewHistorianRemoteServiceClient(cc *grpc.ClientConn) HistorianRemoteServiceClient {
+	return &historianRemoteServiceClient{cc}
+}
+
+func (c *historianRemoteServiceClient) PushStreamEntries(ctx context.Context, in *PushStreamEntriesRequest, opts ...grpc.CallOption) (*PushStreamEntriesResponse, error) {
+	out := new(PushStreamEntriesResponse)
+	err := grpc.Invoke(ctx, "/pushproto.HistorianRemoteService/PushStreamEntries", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *historianRemoteServiceClient) StreamPushEntries(ctx context.Context, opts ...grpc.CallOption) (HistorianRemoteService_StreamPushEntriesClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_HistorianRemoteService_serviceDesc.Streams[0], c.cc, "/pushproto.HistorianRemoteService/StreamPushEntries", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &historianRemoteServiceStreamPushEntriesClient{stream}
+	return x, nil
+}
+
+type HistorianRemoteService_StreamPushEntriesClient interface {
+	Send(*PushStreamEntriesRequest) error
+	Recv() (*PushStreamEntriesResponse, error)
+	grpc.ClientStream
+}
+
+type historianRemoteServiceStreamPushEntriesClient struct {
+	grpc.ClientStream
+}
+
+func (x *historianRemoteServiceStreamPushEntriesClient) Send(m *PushStreamEntriesRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *historianRemoteServiceStreamPushEntriesClient) Recv() (*PushStreamEntriesResponse, error) {
+	m := new(PushStreamEntriesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Server API for HistorianRemoteService service
+
+type HistorianRemoteServiceServer interface {
+	// Push a batch of entries. Entries for the same stream are written in
+	// order, and once one fails the rest for that stream are not attempted.
+	PushStreamEntries(context.Context, *PushStreamEntriesRequest) (*PushStreamEntriesResponse, error)
+	// Push a stream of batches, receiving a response for each batch.
+	StreamPushEntries(HistorianRemoteService_StreamPushEntriesServer) error
+}
+
+func RegisterHistorianRemoteServiceServer(s *grpc.Server, srv HistorianRemoteServiceServer) {
+	s.RegisterService(&_HistorianRemoteService_serviceDesc, srv)
+}
+
+func _HistorianRemoteService_PushStreamEntries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PushStreamEntriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HistorianRemoteServiceServer).PushStreamEntries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pushproto.HistorianRemoteService/PushStreamEntries",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HistorianRemoteServiceServer).PushStreamEntries(ctx, req.(*PushStreamEntriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HistorianRemoteService_StreamPushEntries_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(HistorianRemoteServiceServer).StreamPushEntries(&historianRemoteServiceStreamPushEntriesServer{stream})
+}
+
+type HistorianRemoteService_StreamPushEntriesServer interface {
+	Send(*PushStreamEntriesResponse) error
+	Recv() (*PushStreamEntriesRequest, error)
+	grpc.ServerStream
+}
+
+type historianRemoteServiceStreamPushEntriesServer struct {
+	grpc.ServerStream
+}
+
+func (x *historianRemoteServiceStreamPushEntriesServer) Send(m *PushStreamEntriesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *historianRemoteServiceStreamPushEntriesServer) Recv() (*PushStreamEntriesRequest, error) {
+	m := new(PushStreamEntriesRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var _HistorianRemoteService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "pushproto.HistorianRemoteService",
+	HandlerType: (*HistorianRemoteServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PushStreamEntries",
+			Handler:    _HistorianRemoteService_PushStreamEntries_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamPushEntries",
+			Handler:       _HistorianRemoteService_StreamPushEntries_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "github.com/fuserobotics/historian/pushproto/pushproto.proto",
+}
+
+func init() {
+	proto.RegisterFile("github.com/fuserobotics/historian/pushproto/pushproto.proto", fileDescriptor0)
+}
+
+var fileDescriptor0 = []byte{
+	// 441 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x92, 0xc1, 0x8e, 0xd3, 0x30,
+	0x10, 0x86, 0x71, 0xb6, 0xbb, 0x4d, 0xa7, 0xb0, 0xab, 0xb5, 0x10, 0x4a, 0x23, 0x90, 0x4a, 0x41,
+	0x22, 0xa7, 0x06, 0xb2, 0x7b, 0xe3, 0x84, 0x56, 0x48, 0xf4, 0x86, 0xcc, 0x03, 0x2c, 0xa9, 0x33,
+	0xdd, 0x58, 0xa5, 0x71, 0x18, 0x3b, 0x48, 0xfb, 0x2e, 0x5c, 0x79, 0x2f, 0x1e, 0x05, 0xc5, 0x4e,
+	0x5b, 0x20, 0x2d, 0x37, 0x2e, 0x71, 0xe6, 0xf7, 0xef, 0x99, 0xdf, 0x9f, 0x0c, 0x6f, 0xef, 0x94,
+	0x2d, 0x9b, 0xe5, 0x5c, 0xea, 0x4d, 0xba, 0x6a, 0x0c, 0x92, 0x5e, 0x6a, 0xab, 0xa4, 0x49, 0x4b,
+	0x65, 0xac, 0x26, 0x95, 0x57, 0x69, 0xdd, 0x98, 0xb2, 0x26, 0x6d, 0xf5, 0xfe, 0x6f, 0xee, 0xbe,
+	0x7c, 0xb4, 0x13, 0xe2, 0xeb, 0x63, 0x7d, 0x08, 0x6b, 0x4d, 0x16, 0x29, 0x25, 0xdc, 0x68, 0x8b,
+	0xdd, 0xe2, 0x1b, 0xcc, 0xbe, 0x33, 0x88, 0x3e, 0x36, 0xa6, 0xfc, 0x64, 0x09, 0xf3, 0xcd, 0xfb,
+	0xca, 0x92, 0x42, 0x23, 0xf0, 0x6b, 0x83, 0xc6, 0xf2, 0x57, 0x70, 0x51, 0x6a, 0x63, 0x6f, 0x55,
+	0x81, 0x95, 0x55, 0x2b, 0x85, 0x14, 0xb1, 0x29, 0x4b, 0x46, 0xe2, 0xbc, 0x95, 0x17, 0x3b, 0x95,
+	0x5f, 0xc3, 0x10, 0xfd, 0xd1, 0x28, 0x98, 0x9e, 0x24, 0xe3, 0x2c, 0x9e, 0xef, 0x93, 0xfe, 0xd9,
+	0xfe, 0x5e, 0x6c, 0xad, 0xfc, 0x39, 0x3c, 0x94, 0xba, 0x5a, 0xa9, 0xbb, 0x5b, 0x49, 0xf2, 0x2a,
+	0x8b, 0x4e, 0xa6, 0x2c, 0x79, 0x24, 0xc6, 0x5e, 0xbb, 0x69, 0xa5, 0x36, 0xde, 0xc5, 0x5f, 0xe7,
+	0xfd, 0xb1, 0x4d, 0xad, 0x2b, 0xac, 0xda, 0x68, 0x5d, 0xa4, 0xf1, 0x4e, 0x5b, 0x14, 0x7c, 0x02,
+	0xa1, 0xb1, 0xb9, 0xc5, 0x76, 0x3b, 0x70, 0xdb, 0x43, 0x57, 0x2f, 0x0a, 0x9e, 0xc2, 0x69, 0x3b,
+	0xff, 0xde, 0x4d, 0x1b, 0x67, 0x93, 0x79, 0x87, 0x43, 0xb8, 0xe5, 0xf7, 0x9c, 0xde, 0xc7, 0x63,
+	0x08, 0x4d, 0xcb, 0xa3, 0x92, 0x18, 0x0d, 0xa6, 0x2c, 0x19, 0x88, 0x5d, 0x3d, 0xfb, 0xc1, 0x60,
+	0x72, 0x80, 0x9e, 0xa9, 0x75, 0x65, 0x90, 0xbf, 0x81, 0x41, 0x2e, 0xd7, 0x26, 0x62, 0x0e, 0xc9,
+	0xb3, 0xe3, 0x48, 0xde, 0xc9, 0xb5, 0x70, 0xd6, 0x1e, 0x92, 0xa0, 0x87, 0x84, 0x67, 0x70, 0xe6,
+	0xcb, 0xee, 0x06, 0xf1, 0xa1, 0x1b, 0xdc, 0x38, 0x87, 0xe8, 0x9c, 0x33, 0x02, 0xde, 0x1f, 0xc9,
+	0xcf, 0x21, 0xd0, 0x6b, 0x87, 0x2f, 0x14, 0x81, 0x5e, 0xf3, 0xc7, 0x70, 0x8a, 0x44, 0x9a, 0x3a,
+	0x64, 0xbe, 0xe0, 0x4f, 0x61, 0x54, 0x34, 0xf5, 0x17, 0x25, 0x73, 0x8b, 0x6e, 0x64, 0x28, 0xf6,
+	0x02, 0x8f, 0x60, 0x58, 0x90, 0xae, 0x6b, 0x2c, 0x1c, 0x9c, 0x50, 0x6c, 0xcb, 0xec, 0x27, 0x83,
+	0x27, 0x1f, 0xb6, 0x4f, 0xb8, 0xcb, 0x86, 0xf4, 0x4d, 0x49, 0xe4, 0x9f, 0xe1, 0xb2, 0x47, 0x8d,
+	0xbf, 0x38, 0xca, 0x67, 0xff, 0x22, 0xe3, 0x97, 0xff, 0x36, 0x79, 0xf0, 0xb3, 0x07, 0xbc, 0x80,
+	0x4b, 0xbf, 0xd5, 0x9a, 0xfe, 0xc7, 0x84, 0x84, 0xbd, 0x66, 0xcb, 0x33, 0x67, 0xbb, 0xfa, 0x35,
+	0x00, 0x64, 0x71, 0x9c, 0x4b, 0xc3, 0x03, 0x00, 0x00,
+}
@@ -0,0 +1,158 @@
+package historian
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+	"github.com/golang/glog"
+)
+
+// Entries fetched at a time while measuring retained history.
+const retentionPageSize = 100
+
+// Later than any entry.
+var endOfTime = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Periodically prune every stream with a retention policy.
+func (h *Historian) retentionThread() {
+	ticker := time.NewTicker(h.RetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.dispose:
+			return
+		case now := <-ticker.C:
+			h.pruneStreams(now)
+		}
+	}
+}
+
+func (h *Historian) pruneStreams(now time.Time) {
+	for _, data := range h.GetKnownStreams() {
+		if data.Retention == nil {
+			continue
+		}
+		str, err := h.GetStream(data.Id)
+		if err != nil {
+			glog.Warningf("Unable to load %s for pruning, %v", data.Id, err)
+			continue
+		}
+		if err := str.Prune(now); err != nil {
+			glog.Warningf("Error pruning %s, %v", data.Id, err)
+		}
+	}
+}
+
+// Delete the entries outside the stream's retention policy.
+// The oldest entry kept is turned into a snapshot if necessary, so the state
+// at the earliest retained time can still be computed. If every entry has
+// expired the latest one is kept so the current state survives.
+func (s *Stream) Prune(now time.Time) error {
+	ret := s.Data.GetRetention()
+	if ret == nil {
+		return nil
+	}
+
+	s.maintenanceMtx.Lock()
+	defer s.maintenanceMtx.Unlock()
+
+	keepFrom, err := s.retentionHead(ret, now)
+	if err != nil || keepFrom.IsZero() {
+		return err
+	}
+
+	head, err := s.storage.GetEntryAfter(keepFrom.Add(-time.Nanosecond), stream.StreamEntryAny)
+	if err != nil {
+		return err
+	}
+	if head == nil {
+		latest, err := s.storage.GetEntriesBefore(endOfTime, 1)
+		if err != nil || len(latest) == 0 {
+			return err
+		}
+		head = latest[0]
+	}
+
+	older, err := s.storage.GetEntriesBefore(head.Timestamp, 1)
+	if err != nil || len(older) == 0 {
+		return err
+	}
+
+	if head.Type != stream.StreamEntrySnapshot {
+		state, err := s.stateAt(head.Timestamp)
+		if err != nil {
+			return err
+		}
+		snapshot := &stream.StreamEntry{
+			Type:      stream.StreamEntrySnapshot,
+			Data:      state,
+			Timestamp: head.Timestamp,
+		}
+		if err := s.AmendEntry(snapshot, head.Timestamp); err != nil {
+			return err
+		}
+	}
+
+	glog.Infof("Pruning entries of %s before %v.", s.Data.Id, head.Timestamp)
+	return s.storage.DeleteEntries(time.Time{}, head.Timestamp)
+}
+
+// Find the earliest time retained by the policy, zero if there is no limit.
+func (s *Stream) retentionHead(ret *dbproto.RetentionConfig, now time.Time) (time.Time, error) {
+	var keepFrom time.Time
+	if ret.MaxAge > 0 {
+		keepFrom = now.Add(-time.Duration(ret.MaxAge) * time.Millisecond)
+	}
+	if ret.MaxEntries == 0 && ret.MaxBytes == 0 {
+		return keepFrom, nil
+	}
+
+	// Walk back from the newest entry until a limit is exceeded.
+	var count, size uint64
+	var newer time.Time
+	before := endOfTime
+	for {
+		page, err := s.storage.GetEntriesBefore(before, retentionPageSize)
+		if err != nil {
+			return time.Time{}, err
+		}
+		for _, entry := range page {
+			if entry.Timestamp.Before(keepFrom) {
+				return keepFrom, nil
+			}
+			data, err := json.Marshal(entry.Data)
+			if err != nil {
+				return time.Time{}, err
+			}
+			count++
+			size += uint64(len(data))
+			if (ret.MaxEntries > 0 && count > ret.MaxEntries) || (ret.MaxBytes > 0 && size > ret.MaxBytes) {
+				if newer.IsZero() {
+					// Always keep the latest entry.
+					return entry.Timestamp, nil
+				}
+				return newer, nil
+			}
+			newer = entry.Timestamp
+		}
+		if len(page) < retentionPageSize {
+			return keepFrom, nil
+		}
+		before = page[len(page)-1].Timestamp
+	}
+}
+
+// Compute the state at timestamp with a read cursor.
+func (s *Stream) stateAt(timestamp time.Time) (stream.StateData, error) {
+	cursor := s.StateStream.BuildCursor(stream.ReadForwardCursor)
+	if err := cursor.Init(timestamp); err != nil {
+		return nil, err
+	}
+	if err := cursor.Error(); err != nil {
+		return nil, err
+	}
+	return cursor.State()
+}
@@ -0,0 +1,409 @@
+package historian
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+	"github.com/golang/glog"
+)
+
+// Aggregate of a numeric field over a rollup bucket.
+type FieldRollup struct {
+	Min   float64
+	Max   float64
+	Mean  float64
+	Count uint64
+	Last  float64
+}
+
+func (f *FieldRollup) add(value float64) {
+	if f.Count == 0 || value < f.Min {
+		f.Min = value
+	}
+	if f.Count == 0 || value > f.Max {
+		f.Max = value
+	}
+	f.Mean = (f.Mean*float64(f.Count) + value) / float64(f.Count+1)
+	f.Count++
+	f.Last = value
+}
+
+// Reserved field of a rollup bucket holding the timestamp of the last entry
+// rolled up into it, so each instance following a stream rolls up every
+// entry once.
+const RollupThroughField = "$through"
+
+// A rollup tier of a stream, storing one snapshot per bucket keyed by the
+// start of the bucket. Each snapshot maps dotted numeric field paths to their
+// FieldRollup, see ParseRollupBucket. A field is rolled up each time an entry
+// changes its value, whoever wrote the entry, so live updates and rebuilds
+// from storage agree.
+type RollupTier struct {
+	Bucket time.Duration
+	Data   *dbproto.Stream
+	// History of the buckets, for history requests
+	StateStream *stream.Stream
+
+	h       *Historian
+	storage StreamBackend
+
+	mtx sync.Mutex
+	// Bucket cached in fields, and the last entry rolled up into it
+	current time.Time
+	through time.Time
+	fields  map[string]*FieldRollup
+	// Live state after the last change rolled up, nil to load it
+	state stream.StateData
+}
+
+// Definitions of the rollup streams of a stream.
+func RollupStreams(data *dbproto.Stream) []*dbproto.Stream {
+	res := make([]*dbproto.Stream, 0, len(data.RollupBuckets))
+	for _, bucket := range data.RollupBuckets {
+		if bucket == 0 {
+			continue
+		}
+		res = append(res, rollupStream(data, time.Duration(bucket)*time.Millisecond))
+	}
+	return res
+}
+
+func rollupStream(data *dbproto.Stream, bucket time.Duration) *dbproto.Stream {
+	// Every bucket is a snapshot.
+	keyframe := bucket / time.Millisecond
+	if keyframe > math.MaxUint32 {
+		keyframe = math.MaxUint32
+	}
+	res := &dbproto.Stream{
+		DeviceHostname: data.DeviceHostname,
+		ComponentName:  data.ComponentName,
+		StateName:      data.StateName + "_rollup_" + rollupSuffix(bucket),
+		Config: &stream.Config{
+			RecordRate: &stream.RateConfig{KeyframeFrequency: uint32(keyframe)},
+		},
+	}
+	res.Id = DbStreamTableName(res)
+	return res
+}
+
+// Short name of a bucket size, like 1m or 6h.
+func rollupSuffix(bucket time.Duration) string {
+	switch {
+	case bucket%time.Hour == 0:
+		return fmt.Sprintf("%dh", bucket/time.Hour)
+	case bucket%time.Minute == 0:
+		return fmt.Sprintf("%dm", bucket/time.Minute)
+	case bucket%time.Second == 0:
+		return fmt.Sprintf("%ds", bucket/time.Second)
+	default:
+		return fmt.Sprintf("%dms", bucket/time.Millisecond)
+	}
+}
+
+// Open the rollup tiers of a stream and start feeding them.
+func (s *Stream) openRollups() error {
+	for _, data := range s.Data.RollupBuckets {
+		if data == 0 {
+			continue
+		}
+		bucket := time.Duration(data) * time.Millisecond
+		tier := &RollupTier{
+			Bucket: bucket,
+			Data:   rollupStream(s.Data, bucket),
+			h:      s.h,
+		}
+		storage, err := s.h.backend.OpenStream(tier.Data)
+		if err != nil {
+			return err
+		}
+		tier.storage = storage
+		tier.StateStream, err = stream.NewStream(storage, tier.Data.Config)
+		if err != nil {
+			return err
+		}
+		s.rollups = append(s.rollups, tier)
+		s.OnStateChange(tier.handleStateChange)
+	}
+	return nil
+}
+
+// The rollup tier with the given bucket size, nil if not configured.
+func (s *Stream) Rollup(bucket time.Duration) *RollupTier {
+	for _, tier := range s.rollups {
+		if tier.Bucket == bucket {
+			return tier
+		}
+	}
+	return nil
+}
+
+// The rollup tier to serve the history between from and to from: the
+// coarsest one with at least h.RollupQueryBuckets buckets in the range. Nil
+// if there is none, or the range is too short for any tier.
+func (s *Stream) RollupFor(from, to time.Time) *RollupTier {
+	if s.h.RollupQueryBuckets <= 0 {
+		return nil
+	}
+	span := to.Sub(from)
+	var res *RollupTier
+	for _, tier := range s.rollups {
+		if span/tier.Bucket < time.Duration(s.h.RollupQueryBuckets) {
+			continue
+		}
+		if res == nil || tier.Bucket > res.Bucket {
+			res = tier
+		}
+	}
+	return res
+}
+
+// Iterate over the buckets starting in [from, to].
+func (t *RollupTier) Buckets(from, to time.Time) *EntryIterator {
+	return newEntryIterator(t.storage, from, to, stream.StreamEntrySnapshot)
+}
+
+func (t *RollupTier) handleStateChange(change *StateChange) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	// Late entries are rolled up by rebuilding their buckets, reload what the
+	// rebuild wrote.
+	if change.Late {
+		t.fields = nil
+		t.state = nil
+		return
+	}
+	before := t.state
+	if before == nil {
+		var err error
+		before, err = change.Stream.stateAt(change.Timestamp.Add(-time.Nanosecond))
+		if err != nil {
+			glog.Warningf("Error updating %s, %v", t.Data.Id, err)
+			return
+		}
+	}
+	after := stream.StateData(copyJson(map[string]interface{}(change.State)).(map[string]interface{}))
+	t.state = after
+	if err := t.add(change.Timestamp, before, after); err != nil {
+		glog.Warningf("Error updating %s, %v", t.Data.Id, err)
+	}
+}
+
+// Add the numeric fields an entry changed to its bucket, unless the bucket
+// already includes it. Call with mtx held.
+func (t *RollupTier) add(timestamp time.Time, before, after stream.StateData) error {
+	bucket := timestamp.Truncate(t.Bucket)
+	exists := false
+	if bucket.Equal(t.current) && t.fields != nil {
+		exists = true
+	} else {
+		existing, err := t.storage.GetEntriesBefore(bucket.Add(time.Nanosecond), 1)
+		if err != nil {
+			return err
+		}
+		t.fields = make(map[string]*FieldRollup)
+		t.through = time.Time{}
+		if len(existing) != 0 && existing[0].Timestamp.Equal(bucket) {
+			exists = true
+			t.fields = ParseRollupBucket(existing[0])
+			t.through = rollupThrough(existing[0])
+		}
+		t.current = bucket
+	}
+
+	// Rolled up already, by this instance or another.
+	if !timestamp.After(t.through) {
+		return nil
+	}
+	t.addChanges(before, after)
+	t.through = timestamp
+	return t.store(bucket, exists)
+}
+
+func (t *RollupTier) addChanges(before, after stream.StateData) {
+	changedNumericFields("", before, after, func(path string, value float64) {
+		field, ok := t.fields[path]
+		if !ok {
+			field = &FieldRollup{}
+			t.fields[path] = field
+		}
+		field.add(value)
+	})
+}
+
+// Recompute the buckets containing timestamps from the entries of the
+// stream, each once.
+func (t *RollupTier) rebuild(s *Stream, timestamps []time.Time) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	// Reload the current bucket and state next time.
+	defer func() {
+		t.fields = nil
+		t.state = nil
+	}()
+
+	rebuilt := make(map[int64]bool)
+	for _, ts := range timestamps {
+		bucket := ts.Truncate(t.Bucket)
+		if rebuilt[bucket.UnixNano()] {
+			continue
+		}
+		rebuilt[bucket.UnixNano()] = true
+		if err := t.rebuildBucket(s, bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replay the entries of a bucket from the state before it, rolling up what
+// each changed like handleStateChange does.
+func (t *RollupTier) rebuildBucket(s *Stream, bucket time.Time) error {
+	state, err := s.stateAt(bucket.Add(-time.Nanosecond))
+	if err != nil {
+		return err
+	}
+	t.fields = make(map[string]*FieldRollup)
+	t.through = time.Time{}
+	it := newEntryIterator(s.storage, bucket, bucket.Add(t.Bucket-time.Nanosecond), stream.StreamEntryAny)
+	for it.Next() {
+		entry := it.Entry()
+		next := applyEntry(state, entry)
+		t.addChanges(state, next)
+		state = next
+		t.through = entry.Timestamp
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	existing, err := t.storage.GetEntriesBefore(bucket.Add(time.Nanosecond), 1)
+	if err != nil {
+		return err
+	}
+	exists := len(existing) != 0 && existing[0].Timestamp.Equal(bucket)
+	if !exists && len(t.fields) == 0 {
+		return nil
+	}
+	return t.store(bucket, exists)
+}
+
+// The state after an entry: a snapshot replaces it, a mutation is merged in.
+func applyEntry(state stream.StateData, entry *stream.StreamEntry) stream.StateData {
+	if entry.Type == stream.StreamEntrySnapshot || state == nil {
+		return stream.StateData(copyJson(map[string]interface{}(entry.Data)).(map[string]interface{}))
+	}
+	res := copyJson(map[string]interface{}(state)).(map[string]interface{})
+	mergeJson(res, entry.Data)
+	return stream.StateData(res)
+}
+
+// The timestamp of the last entry rolled up into a bucket.
+func rollupThrough(entry *stream.StreamEntry) time.Time {
+	val, _ := entry.Data[RollupThroughField].(string)
+	through, _ := time.Parse(time.RFC3339Nano, val)
+	return through
+}
+
+// Write the field rollups of a bucket.
+func (t *RollupTier) store(bucket time.Time, exists bool) error {
+	data := make(stream.StateData, len(t.fields))
+	for path, field := range t.fields {
+		data[path] = map[string]interface{}{
+			"min":   field.Min,
+			"max":   field.Max,
+			"mean":  field.Mean,
+			"count": field.Count,
+			"last":  field.Last,
+		}
+	}
+	data[RollupThroughField] = t.through.Format(time.RFC3339Nano)
+	entry := &stream.StreamEntry{
+		Type:      stream.StreamEntrySnapshot,
+		Data:      data,
+		Timestamp: bucket,
+	}
+	var err error
+	if exists {
+		err = t.h.amendEntry(t.storage, entry, bucket, nil)
+	} else {
+		err = t.h.saveEntry(t.storage, entry, nil)
+	}
+	if err != nil {
+		// Reload the bucket next time.
+		t.fields = nil
+	}
+	return err
+}
+
+// Read the field rollups in a bucket entry.
+func ParseRollupBucket(entry *stream.StreamEntry) map[string]*FieldRollup {
+	res := make(map[string]*FieldRollup, len(entry.Data))
+	for path, val := range entry.Data {
+		data, ok := val.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		field := &FieldRollup{}
+		field.Min, _ = toFloat(data["min"])
+		field.Max, _ = toFloat(data["max"])
+		field.Mean, _ = toFloat(data["mean"])
+		field.Last, _ = toFloat(data["last"])
+		count, _ := toFloat(data["count"])
+		field.Count = uint64(count)
+		res[path] = field
+	}
+	return res
+}
+
+// Call fn with the dotted path and value of every numeric field of after
+// that differs in before. Reserved top level fields such as LineageField are
+// not state and are skipped.
+func changedNumericFields(prefix string, before, after map[string]interface{}, fn func(path string, value float64)) {
+	for key, val := range after {
+		if prefix == "" && strings.HasPrefix(key, "$") {
+			continue
+		}
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if child, ok := asObject(val); ok {
+			oldChild, _ := asObject(before[key])
+			changedNumericFields(path, oldChild, child, fn)
+			continue
+		}
+		num, ok := toFloat(val)
+		if !ok {
+			continue
+		}
+		if old, ok := toFloat(before[key]); ok && old == num {
+			continue
+		}
+		fn(path, num)
+	}
+}
+
+func toFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
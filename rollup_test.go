@@ -0,0 +1,170 @@
+package historian
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/statestream"
+)
+
+// Storage recording the bucket written last.
+type bucketStorage struct {
+	StreamBackend
+	last *stream.StreamEntry
+}
+
+func (s *bucketStorage) GetEntriesBefore(timestamp time.Time, limit int) ([]*stream.StreamEntry, error) {
+	if s.last == nil || !s.last.Timestamp.Before(timestamp) {
+		return nil, nil
+	}
+	return []*stream.StreamEntry{s.last}, nil
+}
+
+func (s *bucketStorage) AmendEntry(entry *stream.StreamEntry, oldTimestamp time.Time) error {
+	s.last = entry
+	return nil
+}
+
+func (s *bucketStorage) SaveEntry(entry *stream.StreamEntry) error {
+	s.last = entry
+	return nil
+}
+
+// A stream over entries, and the changes to its live state they make.
+func rollupSource(t *testing.T, entries []*stream.StreamEntry) (*Stream, []*StateChange) {
+	storage := &sliceStorage{entries: entries}
+	str, err := stream.NewStream(storage, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Stream{storage: storage, StateStream: str}
+
+	var changes []*StateChange
+	var state stream.StateData
+	for i, entry := range entries {
+		state = applyEntry(state, entry)
+		changes = append(changes, &StateChange{
+			Stream:    s,
+			Timestamp: entry.Timestamp,
+			State:     state,
+			// Entries written by other instances are rolled up too.
+			Local: i%2 == 0,
+		})
+	}
+	return s, changes
+}
+
+func rollupEntry(sec int64, entryType stream.StreamEntryType, data stream.StateData) *stream.StreamEntry {
+	return &stream.StreamEntry{Type: entryType, Data: data, Timestamp: time.Unix(sec, 0)}
+}
+
+var rollupEntries = []*stream.StreamEntry{
+	rollupEntry(0, stream.StreamEntrySnapshot, stream.StateData{"altitude": 10.0, "speed": 1.0}),
+	rollupEntry(1, stream.StreamEntryMutation, stream.StateData{"altitude": 20.0}),
+	rollupEntry(2, stream.StreamEntryMutation, stream.StateData{"altitude": 30.0, "gps": map[string]interface{}{"sats": 7.0}}),
+	// Reserved fields are not rolled up.
+	rollupEntry(3, stream.StreamEntryMutation, stream.StateData{LineageField: map[string]interface{}{"altitude": map[string]interface{}{"timestamp": 3000.0}}}),
+	// Keyframes and rewrites of the same value don't count again.
+	rollupEntry(4, stream.StreamEntrySnapshot, stream.StateData{"altitude": 30.0, "speed": 1.0, "gps": map[string]interface{}{"sats": 7.0}}),
+	rollupEntry(5, stream.StreamEntryMutation, stream.StateData{"speed": 1.0}),
+}
+
+func TestRollupCountsChanges(t *testing.T) {
+	_, changes := rollupSource(t, rollupEntries)
+	storage := &bucketStorage{}
+	tier := &RollupTier{Bucket: time.Minute, h: &Historian{}, storage: storage}
+	for _, change := range changes {
+		tier.handleStateChange(change)
+	}
+
+	fields := ParseRollupBucket(storage.last)
+	tests := []struct {
+		path  string
+		count uint64
+		mean  float64
+		last  float64
+	}{
+		{"altitude", 3, 20, 30},
+		{"speed", 1, 1, 1},
+		{"gps.sats", 1, 7, 7},
+	}
+	if len(fields) != len(tests) {
+		t.Fatalf("expected %d fields, got %v", len(tests), fields)
+	}
+	for _, test := range tests {
+		field := fields[test.path]
+		if field == nil {
+			t.Fatalf("%s: not rolled up", test.path)
+		}
+		if field.Count != test.count || field.Mean != test.mean || field.Last != test.last {
+			t.Fatalf("%s: expected count %d mean %v last %v, got %+v", test.path, test.count, test.mean, test.last, field)
+		}
+	}
+
+	// An instance seeing an entry already rolled up, for example after
+	// loading the bucket another instance wrote, doesn't count it again.
+	tier.fields = nil
+	tier.state = nil
+	last := changes[len(changes)-1]
+	tier.handleStateChange(&StateChange{Stream: last.Stream, Timestamp: last.Timestamp, State: stream.StateData{"speed": 2.0}})
+	if count := ParseRollupBucket(storage.last)["speed"].Count; count != 1 {
+		t.Fatalf("expected the entry to be rolled up once, got count %d", count)
+	}
+}
+
+func TestRollupRebuildMatches(t *testing.T) {
+	s, changes := rollupSource(t, rollupEntries)
+	live := &bucketStorage{}
+	liveTier := &RollupTier{Bucket: time.Minute, h: &Historian{}, storage: live}
+	for _, change := range changes {
+		liveTier.handleStateChange(change)
+	}
+
+	rebuilt := &bucketStorage{}
+	rebuiltTier := &RollupTier{Bucket: time.Minute, h: &Historian{}, storage: rebuilt}
+	if err := rebuiltTier.rebuild(s, []time.Time{time.Unix(30, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(live.last.Data, rebuilt.last.Data) {
+		t.Fatalf("expected the rebuilt bucket %v to match the live one %v", rebuilt.last.Data, live.last.Data)
+	}
+}
+
+func TestRollupFor(t *testing.T) {
+	s := &Stream{
+		h: &Historian{RollupQueryBuckets: 100},
+		rollups: []*RollupTier{
+			{Bucket: time.Minute},
+			{Bucket: time.Hour},
+		},
+	}
+	from := time.Unix(0, 0)
+
+	tests := []struct {
+		name     string
+		span     time.Duration
+		expected time.Duration
+	}{
+		{"short", time.Hour, 0},
+		{"minutes", 100 * time.Minute, time.Minute},
+		{"hours", 200 * time.Hour, time.Hour},
+	}
+	for _, test := range tests {
+		tier := s.RollupFor(from, from.Add(test.span))
+		if test.expected == 0 {
+			if tier != nil {
+				t.Fatalf("%s: expected no tier, got %v", test.name, tier.Bucket)
+			}
+			continue
+		}
+		if tier == nil || tier.Bucket != test.expected {
+			t.Fatalf("%s: expected the %v tier, got %v", test.name, test.expected, tier)
+		}
+	}
+
+	s.h.RollupQueryBuckets = 0
+	if tier := s.RollupFor(from, from.Add(1000*time.Hour)); tier != nil {
+		t.Fatal("expected no tier with rollup queries disabled")
+	}
+}
@@ -0,0 +1,285 @@
+package historian
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+	"github.com/golang/glog"
+	"go.starlark.net/starlark"
+)
+
+const (
+	defaultScriptMaxSteps = 1000000
+	defaultScriptTimeout  = 100 * time.Millisecond
+)
+
+// Stage running a Starlark script's process(entry) function on each entry.
+//
+// The entry is a dict with "type" ("snapshot" or "mutation"), "timestamp"
+// (unix milliseconds) and "data". process returns None to drop the entry, a
+// dict to replace it, or a list of dicts to split it. Keys missing from a
+// returned dict keep the values of the original entry.
+type ScriptStage struct {
+	Name string
+
+	process  starlark.Callable
+	maxSteps uint64
+	timeout  time.Duration
+}
+
+// Load a script, running its top level under the same limits as process.
+func NewScriptStage(def *dbproto.ScriptStage) (*ScriptStage, error) {
+	st := &ScriptStage{
+		Name:     def.Name,
+		maxSteps: def.MaxSteps,
+		timeout:  time.Duration(def.Timeout) * time.Millisecond,
+	}
+	if st.maxSteps == 0 {
+		st.maxSteps = defaultScriptMaxSteps
+	}
+	if st.timeout == 0 {
+		st.timeout = defaultScriptTimeout
+	}
+
+	thread, done := st.thread()
+	defer done()
+	globals, err := starlark.ExecFile(thread, st.Name, def.Source, nil)
+	if err != nil {
+		return nil, err
+	}
+	process, ok := globals["process"].(starlark.Callable)
+	if !ok {
+		return nil, errors.New("Script does not define process(entry).")
+	}
+	st.process = process
+	return st, nil
+}
+
+// A thread with the script's limits. Call done when finished with it.
+func (st *ScriptStage) thread() (*starlark.Thread, func()) {
+	thread := &starlark.Thread{
+		Name: st.Name,
+		Print: func(_ *starlark.Thread, msg string) {
+			glog.Infof("%s: %s", st.Name, msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(st.maxSteps)
+	timer := time.AfterFunc(st.timeout, func() {
+		thread.Cancel("timed out")
+	})
+	return thread, func() { timer.Stop() }
+}
+
+func (st *ScriptStage) Process(s *Stream, entry *stream.StreamEntry) ([]*stream.StreamEntry, error) {
+	input, err := entryToStarlark(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	thread, done := st.thread()
+	defer done()
+	result, err := starlark.Call(thread, st.process, starlark.Tuple{input}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Script %s failed: %v", st.Name, err)
+	}
+
+	var outputs []starlark.Value
+	switch res := result.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case *starlark.List:
+		for i := 0; i < res.Len(); i++ {
+			outputs = append(outputs, res.Index(i))
+		}
+	case starlark.Tuple:
+		outputs = res
+	default:
+		outputs = []starlark.Value{res}
+	}
+
+	entries := make([]*stream.StreamEntry, 0, len(outputs))
+	for _, output := range outputs {
+		out, err := entryFromStarlark(output, entry)
+		if err != nil {
+			return nil, fmt.Errorf("Script %s: %v", st.Name, err)
+		}
+		entries = append(entries, out)
+	}
+	return entries, nil
+}
+
+// Load the scripts of a stream. Scripts that fail to load are left out, and
+// reported in the returned error.
+func compileScriptStages(data *dbproto.Stream) ([]Stage, error) {
+	var res []Stage
+	var problems []string
+	for i, def := range data.Scripts {
+		if def.Name == "" {
+			def = &dbproto.ScriptStage{
+				Name:     fmt.Sprintf("%s_script_%d", data.Id, i),
+				Source:   def.Source,
+				MaxSteps: def.MaxSteps,
+				Timeout:  def.Timeout,
+			}
+		}
+		st, err := NewScriptStage(def)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", def.Name, err))
+			continue
+		}
+		res = append(res, st)
+	}
+	if len(problems) > 0 {
+		return res, fmt.Errorf("Invalid scripts in %s: %s", data.Id, strings.Join(problems, "; "))
+	}
+	return res, nil
+}
+
+var entryTypeNames = map[stream.StreamEntryType]string{
+	stream.StreamEntrySnapshot: "snapshot",
+	stream.StreamEntryMutation: "mutation",
+}
+
+func entryToStarlark(entry *stream.StreamEntry) (starlark.Value, error) {
+	data, err := toStarlark(map[string]interface{}(entry.Data))
+	if err != nil {
+		return nil, err
+	}
+	res := starlark.NewDict(3)
+	res.SetKey(starlark.String("type"), starlark.String(entryTypeNames[entry.Type]))
+	res.SetKey(starlark.String("timestamp"), starlark.Float(entry.Timestamp.UnixNano()/int64(time.Millisecond)))
+	res.SetKey(starlark.String("data"), data)
+	return res, nil
+}
+
+func entryFromStarlark(val starlark.Value, original *stream.StreamEntry) (*stream.StreamEntry, error) {
+	dict, ok := val.(*starlark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("Returned a %s instead of an entry dict.", val.Type())
+	}
+	res := &stream.StreamEntry{
+		Type:      original.Type,
+		Timestamp: original.Timestamp,
+		Data:      original.Data,
+	}
+
+	if typ, found, _ := dict.Get(starlark.String("type")); found {
+		name, _ := starlark.AsString(typ)
+		switch name {
+		case "snapshot":
+			res.Type = stream.StreamEntrySnapshot
+		case "mutation":
+			res.Type = stream.StreamEntryMutation
+		default:
+			return nil, fmt.Errorf("Unknown entry type %s.", typ)
+		}
+	}
+	if ts, found, _ := dict.Get(starlark.String("timestamp")); found {
+		ms, ok := starlark.AsFloat(ts)
+		if !ok {
+			return nil, errors.New("Entry timestamp is not a number.")
+		}
+		res.Timestamp = time.Unix(0, int64(ms*float64(time.Millisecond)))
+	}
+	if data, found, _ := dict.Get(starlark.String("data")); found {
+		conv, err := fromStarlark(data)
+		if err != nil {
+			return nil, err
+		}
+		obj, ok := conv.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("Entry data is not a dict.")
+		}
+		res.Data = stream.StateData(obj)
+	}
+	return res, nil
+}
+
+// Convert JSON data to Starlark values.
+func toStarlark(val interface{}) (starlark.Value, error) {
+	switch v := val.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case []interface{}:
+		elems := make([]starlark.Value, len(v))
+		for i, child := range v {
+			elem, err := toStarlark(child)
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = elem
+		}
+		return starlark.NewList(elems), nil
+	case stream.StateData:
+		return toStarlark(map[string]interface{}(v))
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(v))
+		for key, child := range v {
+			elem, err := toStarlark(child)
+			if err != nil {
+				return nil, err
+			}
+			dict.SetKey(starlark.String(key), elem)
+		}
+		return dict, nil
+	}
+	if num, ok := toFloat(val); ok {
+		return starlark.Float(num), nil
+	}
+	return nil, fmt.Errorf("Cannot pass %T to a script.", val)
+}
+
+// Convert Starlark values to JSON data.
+func fromStarlark(val starlark.Value) (interface{}, error) {
+	switch v := val.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Float:
+		num := float64(v)
+		if math.IsNaN(num) || math.IsInf(num, 0) {
+			return nil, fmt.Errorf("%v is not a JSON number.", v)
+		}
+		return num, nil
+	case starlark.Int:
+		num, _ := starlark.AsFloat(v)
+		return num, nil
+	case starlark.Indexable:
+		res := make([]interface{}, v.Len())
+		for i := range res {
+			elem, err := fromStarlark(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			res[i] = elem
+		}
+		return res, nil
+	case *starlark.Dict:
+		res := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, errors.New("Dict keys must be strings.")
+			}
+			elem, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			res[key] = elem
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("Cannot convert %s to JSON.", val.Type())
+}
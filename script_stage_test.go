@@ -0,0 +1,153 @@
+package historian
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+)
+
+func TestScriptStage(t *testing.T) {
+	input := func() *stream.StreamEntry {
+		return &stream.StreamEntry{
+			Type: stream.StreamEntryMutation,
+			Data: stream.StateData{
+				"altitude": 10.0,
+				"gps":      map[string]interface{}{"sats": 7, "fix": true},
+				"tags":     []interface{}{"a", nil},
+			},
+			Timestamp: time.Unix(100, 0),
+		}
+	}
+
+	tests := []struct {
+		name     string
+		source   string
+		expected []*stream.StreamEntry
+	}{
+		{
+			name:   "unchanged",
+			source: "def process(entry):\n  return entry",
+			expected: []*stream.StreamEntry{{
+				Type: stream.StreamEntryMutation,
+				Data: stream.StateData{
+					"altitude": 10.0,
+					"gps":      map[string]interface{}{"sats": 7.0, "fix": true},
+					"tags":     []interface{}{"a", nil},
+				},
+				Timestamp: time.Unix(100, 0),
+			}},
+		},
+		{
+			name:     "dropped",
+			source:   "def process(entry):\n  return None",
+			expected: []*stream.StreamEntry{},
+		},
+		{
+			name: "converted",
+			source: `def process(entry):
+  d = entry["data"]
+  return {"data": {
+    "feet": d["altitude"] * 3.28,
+    "sats": d["gps"]["sats"] + 1,
+    "fix": d["gps"]["fix"],
+    "tags": d["tags"] + ["b"],
+    "pair": (1, "x"),
+    "type": entry["type"],
+    "ms": entry["timestamp"],
+  }}`,
+			expected: []*stream.StreamEntry{{
+				Type: stream.StreamEntryMutation,
+				Data: stream.StateData{
+					"feet": 32.8,
+					"sats": 8.0,
+					"fix":  true,
+					"tags": []interface{}{"a", nil, "b"},
+					"pair": []interface{}{1.0, "x"},
+					"type": "mutation",
+					"ms":   100000.0,
+				},
+				Timestamp: time.Unix(100, 0),
+			}},
+		},
+		{
+			name: "split",
+			source: `def process(entry):
+  return [
+    {"type": "snapshot", "data": {"a": 1}},
+    {"timestamp": entry["timestamp"] + 500, "data": {"b": 2}},
+  ]`,
+			expected: []*stream.StreamEntry{
+				{Type: stream.StreamEntrySnapshot, Data: stream.StateData{"a": 1.0}, Timestamp: time.Unix(100, 0)},
+				{Type: stream.StreamEntryMutation, Data: stream.StateData{"b": 2.0}, Timestamp: time.Unix(100, 500*int64(time.Millisecond))},
+			},
+		},
+	}
+	for _, test := range tests {
+		st, err := NewScriptStage(&dbproto.ScriptStage{Name: test.name, Source: test.source})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		res, err := st.Process(nil, input())
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(res) != len(test.expected) {
+			t.Fatalf("%s: expected %d entries, got %d", test.name, len(test.expected), len(res))
+		}
+		for i, entry := range res {
+			expected := test.expected[i]
+			if entry.Type != expected.Type || !entry.Timestamp.Equal(expected.Timestamp) {
+				t.Fatalf("%s: expected %v at %v, got %v at %v", test.name, expected.Type, expected.Timestamp, entry.Type, entry.Timestamp)
+			}
+			if !reflect.DeepEqual(map[string]interface{}(entry.Data), map[string]interface{}(expected.Data)) {
+				t.Fatalf("%s: expected %v, got %v", test.name, expected.Data, entry.Data)
+			}
+		}
+	}
+}
+
+func TestScriptStageErrors(t *testing.T) {
+	entry := &stream.StreamEntry{Type: stream.StreamEntrySnapshot, Data: stream.StateData{}, Timestamp: time.Unix(100, 0)}
+
+	load := []struct {
+		name   string
+		source string
+	}{
+		{"syntax", "def process(entry)\n  return entry"},
+		{"no process", "x = 1"},
+		{"top level loop", "def f():\n  for i in range(100000000):\n    pass\nf()\ndef process(entry):\n  return entry"},
+	}
+	for _, test := range load {
+		if _, err := NewScriptStage(&dbproto.ScriptStage{Name: test.name, Source: test.source, MaxSteps: 1000}); err == nil {
+			t.Fatalf("%s: expected a load error", test.name)
+		}
+	}
+
+	process := []struct {
+		name string
+		def  *dbproto.ScriptStage
+	}{
+		{"fails", &dbproto.ScriptStage{Source: "def process(entry):\n  return 1 // 0"}},
+		{"step limit", &dbproto.ScriptStage{MaxSteps: 1000, Source: "def process(entry):\n  for i in range(100000000):\n    pass"}},
+		{"timeout", &dbproto.ScriptStage{MaxSteps: 1 << 40, Timeout: 10, Source: "def process(entry):\n  for i in range(1000000000):\n    pass"}},
+		{"not a dict", &dbproto.ScriptStage{Source: "def process(entry):\n  return 1"}},
+		{"unknown type", &dbproto.ScriptStage{Source: "def process(entry):\n  return {\"type\": \"other\"}"}},
+		{"timestamp", &dbproto.ScriptStage{Source: "def process(entry):\n  return {\"timestamp\": \"now\"}"}},
+		{"data", &dbproto.ScriptStage{Source: "def process(entry):\n  return {\"data\": [1]}"}},
+		{"key", &dbproto.ScriptStage{Source: "def process(entry):\n  return {\"data\": {1: 2}}"}},
+		{"nan", &dbproto.ScriptStage{Source: "def process(entry):\n  return {\"data\": {\"x\": float(\"nan\")}}"}},
+	}
+	for _, test := range process {
+		test.def.Name = test.name
+		st, err := NewScriptStage(test.def)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if res, err := st.Process(nil, entry); err == nil {
+			t.Fatalf("%s: expected an error, got %v", test.name, res)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package historian
+
+import (
+	"github.com/fuserobotics/statestream"
+)
+
+// Ingest an entry with a sequence number assigned by the reporter, which
+// increases with every entry pushed to the stream. The backend commits the
+// sequence number with the entry, see EntryMeta, so entries at or below the
+// committed one were already stored by some historian and are skipped,
+// returning IngestDuplicate. A sequence number of 0 ingests the entry without
+// deduplication.
+func (s *Stream) IngestSequenced(entry *stream.StreamEntry, seq uint64) (IngestResult, error) {
+	if seq == 0 {
+		return s.Ingest(entry)
+	}
+
+	// Saves running the pipeline for retries, the write makes the final check.
+	committed, err := s.storage.GetSequence()
+	if err != nil {
+		return IngestWritten, err
+	}
+	if seq <= committed {
+		return IngestDuplicate, nil
+	}
+	return s.ingest(entry, seq)
+}
@@ -0,0 +1,131 @@
+package historian_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/memory"
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/statestream"
+)
+
+func TestIngestSequenced(t *testing.T) {
+	b := memory.NewBackend()
+	data := &dbproto.Stream{Id: "plane_1_fc_state", DeviceHostname: "plane_1", ComponentName: "fc", StateName: "state"}
+	b.PutStream(data)
+
+	// Two historians sharing the backend.
+	streams := make([]*historian.Stream, 2)
+	for i := range streams {
+		h := historian.NewHistorian(b)
+		if err := h.Init(); err != nil {
+			t.Fatal(err)
+		}
+		defer h.Dispose()
+		waitFor(t, "the stream to load", func() bool { return knownStream(h, data.Id) != nil })
+		str, err := h.GetStream(data.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams[i] = str
+	}
+
+	tests := []struct {
+		name     string
+		instance int
+		spec     string
+		seq      uint64
+		expected historian.IngestResult
+	}{
+		{"first", 0, "S1", 1, historian.IngestWritten},
+		{"retry on another instance", 1, "S2", 1, historian.IngestDuplicate},
+		{"unsequenced", 1, "S3", 0, historian.IngestWritten},
+		{"retry after an unsequenced entry", 0, "m4", 1, historian.IngestDuplicate},
+		{"next", 1, "m5", 2, historian.IngestWritten},
+		{"beyond float precision", 0, "m6", 1<<53 + 1, historian.IngestWritten},
+		{"retry beyond float precision", 1, "m7", 1<<53 + 1, historian.IngestDuplicate},
+	}
+	for _, test := range tests {
+		res, err := streams[test.instance].IngestSequenced(parseEntry(test.spec), test.seq)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if res != test.expected {
+			t.Fatalf("%s: expected result %d, got %d", test.name, test.expected, res)
+		}
+	}
+
+	storage, err := b.OpenStream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSpecs(t, "stored", historySpecs(t, storage), []string{"S1", "S3", "m5", "m6"})
+}
+
+// Memory backend failing the next sequenced write.
+type failSequenceBackend struct {
+	*memory.Backend
+
+	mtx  sync.Mutex
+	fail bool
+}
+
+func (b *failSequenceBackend) SaveEntries(writes []*historian.EntryWrite) []error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.fail && len(writes) == 1 && writes[0].Meta != nil && writes[0].Meta.Sequence != 0 {
+		b.fail = false
+		return []error{errors.New("Write failed.")}
+	}
+	return b.Backend.SaveEntries(writes)
+}
+
+// Splits an entry into itself and a copy a second later.
+type splitStage struct{}
+
+func (splitStage) Process(s *historian.Stream, entry *stream.StreamEntry) ([]*stream.StreamEntry, error) {
+	next := *entry
+	next.Timestamp = entry.Timestamp.Add(time.Second)
+	return []*stream.StreamEntry{entry, &next}, nil
+}
+
+func TestIngestSequencedSplitRetry(t *testing.T) {
+	b := &failSequenceBackend{Backend: memory.NewBackend(), fail: true}
+	data := &dbproto.Stream{Id: "plane_1_fc_state", DeviceHostname: "plane_1", ComponentName: "fc", StateName: "state", LatenessWindow: 60000}
+	b.PutStream(data)
+	h := historian.NewHistorian(b)
+	h.Stages = []historian.Stage{splitStage{}}
+	if err := h.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Dispose()
+	waitFor(t, "the stream to load", func() bool { return knownStream(h, data.Id) != nil })
+	str, err := h.GetStream(data.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first split entry is stored, the second fails with the sequence.
+	if _, err := str.IngestSequenced(parseEntry("S1"), 1); err == nil {
+		t.Fatal("expected the write error")
+	}
+	res, err := str.IngestSequenced(parseEntry("S1"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != historian.IngestWritten {
+		t.Fatalf("expected the retry to be written, got %d", res)
+	}
+	if res, err := str.IngestSequenced(parseEntry("S1"), 1); err != nil || res != historian.IngestDuplicate {
+		t.Fatalf("expected a duplicate, got %d and %v", res, err)
+	}
+
+	storage, err := b.OpenStream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSpecs(t, "stored", historySpecs(t, storage), []string{"S1", "S2"})
+}
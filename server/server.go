@@ -1,39 +1,84 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/bolt"
+	"github.com/fuserobotics/historian/backend/postgres"
+	"github.com/fuserobotics/historian/backend/rethink"
+	"github.com/fuserobotics/historian/dbproto"
 	"github.com/fuserobotics/historian/service"
 	"github.com/fuserobotics/reporter/remote"
 	"github.com/fuserobotics/reporter/view"
 
 	"github.com/golang/glog"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	bbolt "go.etcd.io/bbolt"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	r "gopkg.in/dancannon/gorethink.v2"
 )
 
+var teardownPolicies = map[string]historian.TeardownPolicy{
+	"keep":    historian.TeardownKeep,
+	"drop":    historian.TeardownDrop,
+	"archive": historian.TeardownArchive,
+}
+
 var RuntimeArgs struct {
 	GrpcPort  int
 	HttpPort  int
+	Backend   string
 	RethinkIp string
 	DbName    string
+	BoltPath  string
+	PgSource  string
+	Timescale bool
+	Teardown  string
+	Instance  string
+
+	AutoRegister         string
+	AutoRegisterTemplate string
+
+	BatchSize    int
+	BatchLatency time.Duration
+
+	PruneInterval   time.Duration
+	CompactInterval time.Duration
+
+	RollupQueryBuckets int
 }
 
 func bindFlags() {
 	flag.IntVar(&RuntimeArgs.GrpcPort, "grpcport", 6000, "GRPC port to bind")
 	flag.IntVar(&RuntimeArgs.HttpPort, "httpport", 9085, "HTTP port to bind")
+	flag.StringVar(&RuntimeArgs.Backend, "backend", "rethink", "Storage backend: rethink, bolt or postgres")
 	flag.StringVar(&RuntimeArgs.DbName, "db", "", "Database name")
 	flag.StringVar(&RuntimeArgs.RethinkIp, "r", "", "rethink ip, for example rethinkdb.rethinkdb.svc.cluster.local")
+	flag.StringVar(&RuntimeArgs.BoltPath, "boltpath", "historian.db", "bolt database file, for the bolt backend")
+	flag.StringVar(&RuntimeArgs.PgSource, "pg", "", "postgres connection string, for example postgres://historian@localhost/historian")
+	flag.StringVar(&RuntimeArgs.Instance, "instance", "", "ID tagged on entries this instance writes, defaults to the hostname and a random suffix")
+	flag.StringVar(&RuntimeArgs.Teardown, "teardown", "keep", "what to do with the entries of deleted streams: keep, drop or archive")
+	flag.IntVar(&RuntimeArgs.BatchSize, "batchsize", 64, "maximum entries per batched write, 1 to write entries one at a time")
+	flag.DurationVar(&RuntimeArgs.BatchLatency, "batchlatency", 2*time.Millisecond, "maximum time an entry waits for a batched write to fill")
+	flag.DurationVar(&RuntimeArgs.PruneInterval, "pruneinterval", 10*time.Minute, "how often to prune streams with a retention policy, 0 to never")
+	flag.DurationVar(&RuntimeArgs.CompactInterval, "compactinterval", time.Hour, "how often to compact streams with a compaction schedule, 0 to never")
+	flag.IntVar(&RuntimeArgs.RollupQueryBuckets, "rollupquerybuckets", 0, "serve history requests spanning at least this many buckets of a rollup tier from the coarsest such tier, 0 to always replay entries")
+	flag.StringVar(&RuntimeArgs.AutoRegister, "autoregister", "", "comma separated hostname patterns of devices whose unknown streams are registered on first push, for example plane-*")
+	flag.StringVar(&RuntimeArgs.AutoRegisterTemplate, "autoregistertemplate", "{}", "JSON stream definition auto-registered streams start from, for example {\"config\":{\"record_rate\":{\"keyframe_frequency\":60000}}}")
+	flag.BoolVar(&RuntimeArgs.Timescale, "timescale", false, "store postgres stream tables as TimescaleDB hypertables")
 	flag.CommandLine.Usage = func() {
 		fmt.Println(`historian
 Starts the API at the ports specified.
@@ -69,6 +114,26 @@ func verifyPort(port int) error {
 	return nil
 }
 
+// The auto-registration policy from the flags, nil if disabled.
+func autoRegisterPolicy() (*historian.AutoRegisterPolicy, error) {
+	if RuntimeArgs.AutoRegister == "" {
+		return nil, nil
+	}
+	policy := &historian.AutoRegisterPolicy{
+		Hostnames: strings.Split(RuntimeArgs.AutoRegister, ","),
+		Template:  &dbproto.Stream{},
+	}
+	for _, pattern := range policy.Hostnames {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid auto-register hostname pattern %s", pattern)
+		}
+	}
+	if err := json.Unmarshal([]byte(RuntimeArgs.AutoRegisterTemplate), policy.Template); err != nil {
+		return nil, fmt.Errorf("Invalid auto-register template: %v", err)
+	}
+	return policy, nil
+}
+
 func verifyArgs() error {
 	if err := verifyPort(RuntimeArgs.GrpcPort); err != nil {
 		return fmt.Errorf("GRPC port invalid: %v", err)
@@ -76,8 +141,28 @@ func verifyArgs() error {
 	if err := verifyPort(RuntimeArgs.HttpPort); err != nil {
 		return fmt.Errorf("HTTP port invalid: %v", err)
 	}
-	if RuntimeArgs.DbName == "" {
-		return fmt.Errorf("Please specify db with --db")
+	if _, ok := teardownPolicies[RuntimeArgs.Teardown]; !ok {
+		return fmt.Errorf("Unknown teardown policy %s", RuntimeArgs.Teardown)
+	}
+	if _, err := autoRegisterPolicy(); err != nil {
+		return err
+	}
+
+	switch RuntimeArgs.Backend {
+	case "rethink":
+		if RuntimeArgs.DbName == "" {
+			return fmt.Errorf("Please specify db with --db")
+		}
+	case "bolt":
+		if RuntimeArgs.BoltPath == "" {
+			return fmt.Errorf("Please specify bolt database file with --boltpath")
+		}
+	case "postgres":
+		if RuntimeArgs.PgSource == "" {
+			return fmt.Errorf("Please specify postgres connection string with --pg")
+		}
+	default:
+		return fmt.Errorf("Unknown backend %s", RuntimeArgs.Backend)
 	}
 
 	return nil
@@ -108,6 +193,42 @@ func setupRethink() (*r.Session, error) {
 	})
 }
 
+// Connect to the selected backend. Call the returned func to close it.
+func setupBackend() (historian.Backend, func(), error) {
+	switch RuntimeArgs.Backend {
+	case "bolt":
+		db, err := bbolt.Open(RuntimeArgs.BoltPath, 0600, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		backend, err := bolt.NewBackend(db)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return backend, func() { db.Close() }, nil
+	case "postgres":
+		backend, err := postgres.Open(RuntimeArgs.PgSource)
+		if err != nil {
+			return nil, nil, err
+		}
+		backend.Hypertables = RuntimeArgs.Timescale
+		return backend, func() { backend.Close() }, nil
+	default:
+		rctx, err := setupRethink()
+		if err != nil {
+			return nil, nil, err
+		}
+		backend := rethink.NewBackend(rctx)
+		glog.Info("Migrating database...")
+		if err := backend.Migrate(); err != nil {
+			rctx.Close()
+			return nil, nil, err
+		}
+		return backend, func() { rctx.Close() }, nil
+	}
+}
+
 func main() {
 	// Log to stdout
 	flag.Lookup("logtostderr").Value.Set("true")
@@ -124,21 +245,34 @@ func main() {
 	}
 
 	glog.Info("Connecting to database...")
-	rctx, err := setupRethink()
+	backend, closeBackend, err := setupBackend()
 	if err != nil {
-		glog.Fatalf("Error setting up rethink %v\n", err)
+		glog.Fatalf("Error setting up %s backend %v\n", RuntimeArgs.Backend, err)
 	}
-	defer rctx.Close()
+	defer closeBackend()
 
 	glog.Info("Registering services...")
 
-	historianInstance := historian.NewHistorian(rctx)
+	historianInstance := historian.NewHistorian(backend)
+	historianInstance.TeardownPolicy = teardownPolicies[RuntimeArgs.Teardown]
+	historianInstance.AutoRegister, _ = autoRegisterPolicy()
+	if RuntimeArgs.Instance != "" {
+		historianInstance.InstanceId = RuntimeArgs.Instance
+	}
+	if pg, ok := backend.(*postgres.Backend); ok {
+		pg.InstanceId = historianInstance.InstanceId
+	}
+	historianInstance.WriteBatchSize = RuntimeArgs.BatchSize
+	historianInstance.WriteBatchLatency = RuntimeArgs.BatchLatency
+	historianInstance.RetentionInterval = RuntimeArgs.PruneInterval
+	historianInstance.CompactionInterval = RuntimeArgs.CompactInterval
+	historianInstance.RollupQueryBuckets = RuntimeArgs.RollupQueryBuckets
 	if err := historianInstance.Init(); err != nil {
 		glog.Fatalf("Error initializing historian: %v", err)
 	}
 
 	grpcServer := grpc.NewServer()
-	service.RegisterServer(grpcServer, rctx, historianInstance)
+	service.RegisterServer(grpcServer, historianInstance)
 
 	glog.Info("Starting up services...")
 	httpEndpoint := fmt.Sprintf("0.0.0.0:%d", RuntimeArgs.HttpPort)
@@ -2,20 +2,20 @@ package service
 
 import (
 	"google.golang.org/grpc"
-	r "gopkg.in/dancannon/gorethink.v2"
 
 	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/pushproto"
 	"github.com/fuserobotics/reporter/remote"
 	"github.com/fuserobotics/reporter/view"
 )
 
-func RegisterServer(server *grpc.Server, rctx *r.Session, historianInstance *historian.Historian) {
-	remote.RegisterReporterRemoteServiceServer(server, &HistorianRemoteService{
-		Session:   rctx,
+func RegisterServer(server *grpc.Server, historianInstance *historian.Historian) {
+	remoteService := &HistorianRemoteService{
 		Historian: historianInstance,
-	})
+	}
+	remote.RegisterReporterRemoteServiceServer(server, remoteService)
+	pushproto.RegisterHistorianRemoteServiceServer(server, remoteService)
 	view.RegisterReporterServiceServer(server, &HistorianViewService{
-		Session:   rctx,
 		Historian: historianInstance,
 	})
 }
@@ -3,19 +3,36 @@ package service
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
 
 	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/historian/pushproto"
 	"github.com/fuserobotics/reporter/remote"
 	"github.com/fuserobotics/reporter/util"
 	"github.com/fuserobotics/statestream"
+	"github.com/golang/glog"
 
 	"golang.org/x/net/context"
-	r "gopkg.in/dancannon/gorethink.v2"
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata key carrying the sequence number of an entry pushed with
+// PushStreamEntry, see historian.Stream.IngestSequenced.
+const SequenceMetadataKey = "historian-sequence"
+
+const (
+	// Most entries in one PushStreamEntries batch.
+	maxPushBatch = 1000
+	// Most streams of a batch written at once.
+	pushWorkers = 8
 )
 
 type HistorianRemoteService struct {
 	Historian *historian.Historian
-	Session   *r.Session
 }
 
 func (s *HistorianRemoteService) GetRemoteConfig(c context.Context, req *remote.GetRemoteConfigRequest) (*remote.GetRemoteConfigResponse, error) {
@@ -37,36 +54,170 @@ func (s *HistorianRemoteService) PushStreamEntry(c context.Context, req *remote.
 		return nil, err
 	}
 	// todo: more checking here.
-	if req.Entry == nil {
-		return nil, errors.New("Entry must be specified.")
+	seq, err := requestSequence(c)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.pushEntry(req.Context.HostIdentifier, req.Context.ComponentId, req.Context.StateId, req.Entry, seq); err != nil {
+		return nil, err
+	}
+
+	// check remote config
+	res := &remote.PushStreamEntryResponse{}
+	conf, err := s.Historian.BuildRemoteStreamConfig(req.Context.HostIdentifier)
+	if err == nil {
+		if conf.Crc32 != req.ConfigCrc32 {
+			res.Config = conf
+		}
+	}
+	return res, nil
+}
+
+// Sequence number of a single pushed entry, from the request metadata.
+// 0 if not given.
+func requestSequence(c context.Context) (uint64, error) {
+	md, ok := metadata.FromContext(c)
+	if !ok || len(md[SequenceMetadataKey]) == 0 {
+		return 0, nil
+	}
+	seq, err := strconv.ParseUint(md[SequenceMetadataKey][0], 10, 64)
+	if err != nil {
+		return 0, errors.New("Invalid sequence number.")
+	}
+	return seq, nil
+}
+
+// Ingest one pushed entry.
+func (s *HistorianRemoteService) pushEntry(hostId, componentId, stateId string, entry *remote.RemoteStreamEntry, seq uint64) (historian.IngestResult, error) {
+	if entry == nil {
+		return historian.IngestWritten, errors.New("Entry must be specified.")
 	}
 
 	var jsonData map[string]interface{}
-	if err := json.Unmarshal([]byte(req.Entry.JsonData), &jsonData); err != nil {
-		return nil, err
+	if err := json.Unmarshal([]byte(entry.JsonData), &jsonData); err != nil {
+		return historian.IngestWritten, err
 	}
 
-	stateId := historian.StreamTableName(req.Context.HostIdentifier, req.Context.ComponentId, req.Context.StateId)
-	state, err := s.Historian.GetStream(stateId)
+	state, err := s.Historian.GetPushStream(hostId, componentId, stateId)
 	if err != nil {
-		return nil, err
+		return historian.IngestWritten, err
 	}
-	err = state.StateStream.WriteEntry(&stream.StreamEntry{
-		Timestamp: util.NumberToTime(req.Entry.Timestamp),
+	if state.Data.Source == dbproto.Stream_AGGREGATE {
+		return historian.IngestWritten, errors.New("Cannot push entries to an aggregate stream.")
+	}
+	return state.IngestSequenced(&stream.StreamEntry{
+		Timestamp: util.NumberToTime(entry.Timestamp),
 		Data:      stream.StateData(jsonData),
-		Type:      stream.StreamEntryType(req.Entry.EntryType),
-	})
-	if err != nil {
-		return nil, err
+		Type:      stream.StreamEntryType(entry.EntryType),
+	}, seq)
+}
+
+func (s *HistorianRemoteService) PushStreamEntries(c context.Context, req *pushproto.PushStreamEntriesRequest) (*pushproto.PushStreamEntriesResponse, error) {
+	if req.HostIdentifier == "" {
+		return nil, errors.New("Host identifier must be specified.")
+	}
+	if len(req.Entries) > maxPushBatch {
+		return nil, fmt.Errorf("Batch of %d entries exceeds the limit of %d.", len(req.Entries), maxPushBatch)
 	}
 
-	// check remote config
-	res := &remote.PushStreamEntryResponse{}
-	conf, err := s.Historian.BuildRemoteStreamConfig(req.Context.HostIdentifier)
+	// Streams are written in parallel, the entries of each in order.
+	byStream := make(map[string][]int)
+	var order []string
+	for i, entry := range req.Entries {
+		streamId := historian.StreamTableName(req.HostIdentifier, entry.ComponentId, entry.StateId)
+		if _, ok := byStream[streamId]; !ok {
+			order = append(order, streamId)
+		}
+		byStream[streamId] = append(byStream[streamId], i)
+	}
+
+	acks := make([]*pushproto.PushStreamEntryAck, len(req.Entries))
+	queue := make(chan []int, len(order))
+	for _, streamId := range order {
+		queue <- byStream[streamId]
+	}
+	close(queue)
+
+	workers := pushWorkers
+	if len(order) < workers {
+		workers = len(order)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for indexes := range queue {
+				s.pushStreamEntries(req, indexes, acks)
+			}
+		}()
+	}
+	wg.Wait()
+
+	res := &pushproto.PushStreamEntriesResponse{Acks: acks}
+	conf, err := s.Historian.BuildRemoteStreamConfig(req.HostIdentifier)
 	if err == nil {
+		res.ConfigCrc32 = conf.Crc32
 		if conf.Crc32 != req.ConfigCrc32 {
 			res.Config = conf
 		}
 	}
 	return res, nil
 }
+
+// Push the entries at indexes, all for the same stream, in order. Once one
+// fails the rest are skipped.
+func (s *HistorianRemoteService) pushStreamEntries(req *pushproto.PushStreamEntriesRequest, indexes []int, acks []*pushproto.PushStreamEntryAck) {
+	// Late entries of a backlog are applied together, see
+	// historian.Stream.BeginBatch. Errors getting the stream are reported by
+	// pushEntry.
+	first := req.Entries[indexes[0]]
+	if state, err := s.Historian.GetPushStream(req.HostIdentifier, first.ComponentId, first.StateId); err == nil {
+		end := state.BeginBatch()
+		defer func() {
+			// The entries are stored, only the derived data is behind.
+			if err := end(); err != nil {
+				glog.Warningf("Unable to apply late entries to %s, %v", state.Data.Id, err)
+			}
+		}()
+	}
+
+	var failed error
+	for _, i := range indexes {
+		if failed != nil {
+			acks[i] = &pushproto.PushStreamEntryAck{Error: "Skipped after an earlier entry failed: " + failed.Error()}
+			continue
+		}
+		entry := req.Entries[i]
+		result, err := s.pushEntry(req.HostIdentifier, entry.ComponentId, entry.StateId, entry.Entry, entry.Sequence)
+		if err != nil {
+			failed = err
+			acks[i] = &pushproto.PushStreamEntryAck{Error: err.Error()}
+			continue
+		}
+		acks[i] = &pushproto.PushStreamEntryAck{
+			Ok:        true,
+			Duplicate: result == historian.IngestDuplicate,
+			Dropped:   result == historian.IngestDropped,
+		}
+	}
+}
+
+func (s *HistorianRemoteService) StreamPushEntries(srv pushproto.HistorianRemoteService_StreamPushEntriesServer) error {
+	for {
+		req, err := srv.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		res, err := s.PushStreamEntries(srv.Context(), req)
+		if err != nil {
+			return err
+		}
+		if err := srv.Send(res); err != nil {
+			return err
+		}
+	}
+}
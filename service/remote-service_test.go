@@ -0,0 +1,70 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/memory"
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/fuserobotics/historian/pushproto"
+	"github.com/fuserobotics/reporter/remote"
+	"github.com/fuserobotics/statestream"
+	"golang.org/x/net/context"
+)
+
+// Drops entries flagged to be dropped.
+func dropFlagged(s *historian.Stream, entry *stream.StreamEntry) (bool, error) {
+	_, drop := entry.Data["drop"]
+	return !drop, nil
+}
+
+func TestPushStreamEntries(t *testing.T) {
+	const streams = 3 * pushWorkers
+	b := memory.NewBackend()
+	for i := 0; i < streams; i++ {
+		b.PutStream(&dbproto.Stream{DeviceHostname: "plane_1", ComponentName: "fc", StateName: fmt.Sprintf("state_%d", i)})
+	}
+	h := historian.NewHistorian(b)
+	h.Stages = []historian.Stage{historian.FilterStage(dropFlagged)}
+	if err := h.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Dispose()
+	s := &HistorianRemoteService{Historian: h}
+
+	req := &pushproto.PushStreamEntriesRequest{HostIdentifier: "plane_1"}
+	for i := 0; i < streams; i++ {
+		data := `{"altitude": 1}`
+		if i%2 == 1 {
+			data = `{"drop": true}`
+		}
+		req.Entries = append(req.Entries, &pushproto.PushStreamEntry{
+			ComponentId: "fc",
+			StateId:     fmt.Sprintf("state_%d", i),
+			Entry: &remote.RemoteStreamEntry{
+				JsonData:  data,
+				Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+				EntryType: int32(stream.StreamEntrySnapshot),
+			},
+		})
+	}
+	res, err := s.PushStreamEntries(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Acks) != streams {
+		t.Fatalf("expected %d acks, got %d", streams, len(res.Acks))
+	}
+	for i, ack := range res.Acks {
+		if !ack.Ok || ack.Dropped != (i%2 == 1) || ack.Duplicate {
+			t.Fatalf("entry %d: unexpected ack %v", i, ack)
+		}
+	}
+
+	req.Entries = make([]*pushproto.PushStreamEntry, maxPushBatch+1)
+	if _, err := s.PushStreamEntries(context.Background(), req); err == nil {
+		t.Fatal("expected an oversized batch to be rejected")
+	}
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"bytes"
 	"encoding/json"
+	"time"
 
 	"github.com/fuserobotics/historian"
 	"github.com/fuserobotics/historian/dbproto"
@@ -12,12 +13,10 @@ import (
 	"github.com/fuserobotics/statestream"
 
 	"golang.org/x/net/context"
-	r "gopkg.in/dancannon/gorethink.v2"
 )
 
 type HistorianViewService struct {
 	Historian *historian.Historian
-	Session   *r.Session
 }
 
 func (h *HistorianViewService) GetState(c context.Context, req *view.GetStateRequest) (*view.GetStateResponse, error) {
@@ -84,7 +83,7 @@ func (h *HistorianViewService) ListStates(c context.Context, req *view.ListState
 		},
 	}
 	components := make(map[string]*view.StateListComponent)
-	for _, stream := range h.Historian.KnownStreams {
+	for _, stream := range h.Historian.GetKnownStreams() {
 		// create a string id for this
 		cmpId := componentStringId(stream)
 		comp, ok := components[cmpId]
@@ -124,5 +123,14 @@ func (h *HistorianViewService) GetStateHistory(req *view.StateHistoryRequest, sr
 		return err
 	}
 
-	return history.HandleStateHistoryRequest(req, srvstream, streame.StateStream)
+	// Long ranges are served from the rollups.
+	end := time.Now()
+	if req.Query.EndTime > 0 {
+		end = util.NumberToTime(req.Query.EndTime)
+	}
+	str := streame.StateStream
+	if tier := streame.RollupFor(util.NumberToTime(req.Query.BeginTime), end); tier != nil {
+		str = tier.StateStream
+	}
+	return history.HandleStateHistoryRequest(req, srvstream, str)
 }
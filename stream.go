@@ -3,12 +3,12 @@ package historian
 import (
 	"bytes"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/fuserobotics/historian/dbproto"
 	"github.com/fuserobotics/statestream"
 	"github.com/golang/glog"
-	r "gopkg.in/dancannon/gorethink.v2"
 )
 
 var changeUnnecessaryError error = errors.New("change applied locally already")
@@ -17,7 +17,42 @@ type Stream struct {
 	dispose chan bool
 	h       *Historian
 
-	dataTable r.Term
+	storage StreamBackend
+
+	// Serializes pruning and compaction
+	maintenanceMtx sync.Mutex
+	// End of the range compacted by the last scheduled compaction, for
+	// streams without mutations, see compactionHead
+	compactedUntil time.Time
+
+	handlersMtx   sync.Mutex
+	stateHandlers map[int]StateChangeHandler
+	nextHandlerId int
+
+	rollups []*RollupTier
+
+	// Last recorded values of fields with deadbands, and the values of
+	// entries in the pipeline to record once they are written
+	deadbandMtx     sync.Mutex
+	deadbandValues  map[string]*recordedValue
+	deadbandPending map[*stream.StreamEntry]map[string]*recordedValue
+
+	computed []*computedField
+	scripts  []Stage
+	pipeline []Stage
+
+	// Work owed by late entries, deferred while batches are open
+	lateMtx     sync.Mutex
+	lateBatches int
+	late        lateWork
+
+	// Serializes writes to the live state, and holds the metadata to store
+	// with the entry being written
+	writeMtx  sync.Mutex
+	writeMeta *EntryMeta
+
+	// Set once the watch thread has caught up for the first time
+	watched bool
 
 	Data        *dbproto.Stream
 	StateStream *stream.Stream
@@ -25,11 +60,27 @@ type Stream struct {
 
 // Instantiate a new stream and start watch thread.
 func (h *Historian) NewStream(data *dbproto.Stream) (*Stream, error) {
+	storage, err := h.backend.OpenStream(data)
+	if err != nil {
+		return nil, err
+	}
+	h.mtx.Lock()
+	computed := h.computedFields[data.Id]
+	scripts := h.scriptStages[data.Id]
+	h.mtx.Unlock()
+
 	str := &Stream{
-		h:         h,
-		dispose:   make(chan bool, 1),
-		dataTable: r.Table(DbStreamTableName(data)),
-		Data:      data,
+		h:             h,
+		dispose:       make(chan bool, 1),
+		storage:       storage,
+		stateHandlers: make(map[int]StateChangeHandler),
+		computed:      computed,
+		scripts:       scripts,
+		Data:          data,
+	}
+	str.pipeline = str.buildPipeline()
+	if err := str.openRollups(); err != nil {
+		return nil, err
 	}
 	sstr, err := stream.NewStream(str, data.Config)
 	if err != nil {
@@ -89,25 +140,20 @@ func (s *Stream) watchThread() (watchError error) {
 		}
 	}()
 
-	// force a db hit
-	s.StateStream.ResetWriter()
-	writeCursor, err := s.StateStream.WriteCursor()
+	// Open the feed before catching up, so entries written in between are
+	// not missed.
+	feed, err := s.storage.WatchEntries()
 	if err != nil {
-		return nil
+		return err
 	}
+	defer feed.Close()
 
-	// Start cursor to watch for new entries
-	// this isn't possible since they might come in unordered:
-	// query := s.dataTable.Filter(r.Row.Field("timestamp").Gt(writeCursor.ComputedTimestamp()))
-	// instead we will just accept we may drop entries in the 1ms between the last read and now.
-	cursor, err := s.dataTable.Changes().Run(s.h.rctx)
+	caught, err := s.catchUp()
 	if err != nil {
 		return err
 	}
-	defer cursor.Close()
 
-	changesChan := make(chan streamEntryChange)
-	cursor.Listen(changesChan)
+	changesChan := feed.Changes()
 	glog.Infof("Watching for changes to %s.", s.Data.Id)
 
 	for {
@@ -117,36 +163,142 @@ func (s *Stream) watchThread() (watchError error) {
 			return
 		case change, ok := <-changesChan:
 			if !ok {
-				return errors.New("RethinkDB closed the change channel.")
+				if err := feed.Err(); err != nil {
+					return err
+				}
+				return errors.New("Backend closed the change channel.")
+			}
+			if change.NewValue != nil && caught.repeated(change.NewValue) {
+				continue
 			}
-			if err := s.handleChange(&change, writeCursor); err != nil {
+			if err := s.handleChange(change); err != nil {
 				return err
 			}
 		}
 	}
 }
 
-func (s *Stream) handleChange(cha *streamEntryChange, writeCursor *stream.Cursor) error {
-	// nothing we can do about this
-	if cha.NewValue == nil || cha.OldValue != nil {
+// Entries handled while catching up, which the feed may repeat until it
+// passes the last of them.
+type caughtUp struct {
+	handled map[int64]bool
+	head    time.Time
+}
+
+func (c *caughtUp) add(entry *stream.StreamEntry) {
+	if c.handled == nil {
+		c.handled = make(map[int64]bool)
+	}
+	c.handled[entry.Timestamp.UnixNano()] = true
+	c.head = entry.Timestamp
+}
+
+// Whether the feed repeats an entry handled while catching up. Forgets them
+// all once the feed passes the last one.
+func (c *caughtUp) repeated(entry *stream.StreamEntry) bool {
+	if c.handled == nil {
+		return false
+	}
+	if entry.Timestamp.After(c.head) {
+		c.handled = nil
+		return false
+	}
+	key := entry.Timestamp.UnixNano()
+	if !c.handled[key] {
+		return false
+	}
+	delete(c.handled, key)
+	return true
+}
+
+// Handle the entries stored after the live state with an ordered range
+// query. Returns the entries handled.
+func (s *Stream) catchUp() (*caughtUp, error) {
+	live, err := s.liveTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	// Late entries may have been inserted while we weren't watching.
+	if s.watched && s.Data.LatenessWindow > 0 {
+		if err := s.reloadLiveState(live, false); err != nil {
+			return nil, err
+		}
+	}
+	s.watched = true
+
+	caught := &caughtUp{}
+	it := newEntryIterator(s.storage, live, endOfTime, stream.StreamEntryAny)
+	it.after = live
+	for it.Next() {
+		entry := it.Entry()
+		if err := s.handleChange(&StreamEntryChange{NewValue: entry}); err != nil {
+			return nil, err
+		}
+		caught.add(entry)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	if len(caught.handled) != 0 {
+		glog.Infof("Caught up on %d entries of %s.", len(caught.handled), s.Data.Id)
+	}
+	return caught, nil
+}
+
+func (s *Stream) handleChange(cha *StreamEntryChange) error {
+	// nothing we can do about deletions
+	if cha.NewValue == nil {
+		return nil
+	}
+	// we wrote it, so it's applied locally already
+	writer := cha.Writer
+	if writer == s.h.InstanceId {
 		return nil
 	}
+	if writer == "" {
+		writer = "an unknown writer"
+	}
 
+	// the write cursor is replaced when late entries reload the state
+	writeCursor, err := s.StateStream.WriteCursor()
+	if err != nil {
+		return err
+	}
 	var wcts time.Time
 	// wait until all local writes are done
 	writeCursor.WriteGuard(func() error {
 		wcts = writeCursor.ComputedTimestamp()
 		return nil
 	})
-	if cha.NewValue.Timestamp.Before(wcts) || cha.NewValue.Timestamp.Equal(wcts) {
-		// glog.Infof("Ignoring stream entry change as it's before the latest computed timestamp.")
+	// the entry at wcts is the one the live state was computed from, so it
+	// only changes the state if rewritten
+	rewritten := cha.OldValue != nil && cha.NewValue.Timestamp.Equal(wcts)
+	if cha.NewValue.Timestamp.Before(wcts) || rewritten {
+		if !s.withinLatenessWindow(cha.NewValue.Timestamp, wcts) {
+			// glog.Infof("Ignoring stream entry change as it's before the latest computed timestamp.")
+			return nil
+		}
+		return s.handleLateChange(cha.NewValue, writer)
+	}
+	if cha.OldValue != nil || !cha.NewValue.Timestamp.After(wcts) {
 		return nil
 	}
-	glog.Infof("Handling stream entry for %s someone else wrote at ts %v local ts %v.", s.Data.Id, cha.NewValue.Timestamp, wcts)
+	glog.Infof("Handling stream entry for %s written by %s at ts %v local ts %v.", s.Data.Id, writer, cha.NewValue.Timestamp, wcts)
 	if err := writeCursor.HandleEntry(cha.NewValue); err != nil {
 		return err
 	}
-	return nil
+	return s.notifyStateChange(writeCursor, &StateChange{Timestamp: cha.NewValue.Timestamp})
+}
+
+// Reload the live state if a late entry someone else inserted, or a snapshot
+// they rewrote after one, changed it.
+func (s *Stream) handleLateChange(entry *stream.StreamEntry, writer string) error {
+	next, err := s.storage.GetEntryAfter(entry.Timestamp, stream.StreamEntrySnapshot)
+	if err != nil || next != nil {
+		return err
+	}
+	glog.Infof("Handling late stream entry for %s inserted by %s at ts %v.", s.Data.Id, writer, entry.Timestamp)
+	return s.reloadLiveState(entry.Timestamp, false)
 }
 
 func (s *Stream) dataTableName() string {
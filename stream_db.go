@@ -4,59 +4,35 @@ import (
 	"time"
 
 	"github.com/fuserobotics/statestream"
-	r "gopkg.in/dancannon/gorethink.v2"
 )
 
 // Retrieve the first snapshot before timestamp. Return nil for no data.
 func (s *Stream) GetSnapshotBefore(timestamp time.Time) (*stream.StreamEntry, error) {
-	entry := &stream.StreamEntry{}
-	query := s.dataTable.Filter(r.Row.Field("type").Eq(int(stream.StreamEntrySnapshot))).Filter(r.Row.Field("timestamp").Lt(timestamp))
-	cursor, err := query.Run(s.h.rctx)
-	defer cursor.Close()
-	if err != nil {
-		return nil, err
-	}
-	if err := cursor.One(entry); err != nil {
-		if err.Error() == r.ErrEmptyResult.Error() {
-			return nil, nil
-		}
-		return nil, err
-	}
-	return entry, nil
+	return s.storage.GetSnapshotBefore(timestamp)
 }
 
 // Retrieve the first entry after timestamp. Return nil for no data.
 func (s *Stream) GetEntryAfter(timestamp time.Time, filterType stream.StreamEntryType) (*stream.StreamEntry, error) {
-	entry := &stream.StreamEntry{}
-	query := s.dataTable
-	if filterType != stream.StreamEntryAny {
-		query = query.Filter(r.Row.Field("type").Eq(int(filterType)))
-	}
-	query = query.Filter(r.Row.Field("timestamp").Gt(timestamp))
-	cursor, err := query.Run(s.h.rctx)
-	defer cursor.Close()
-	if err != nil {
-		return nil, err
-	}
-	if err := cursor.One(entry); err != nil {
-		if err.Error() == r.ErrEmptyResult.Error() {
-			return nil, nil
-		}
-		return nil, err
-	}
-	return entry, nil
+	return s.storage.GetEntryAfter(timestamp, filterType)
 }
 
-// Store a stream entry.
+// Store an entry written to the state stream, with the metadata passed to
+// writeEntry. Only the state stream calls this, within writeEntry.
 func (s *Stream) SaveEntry(entry *stream.StreamEntry) error {
-	if _, err := s.dataTable.Insert(entry).RunWrite(s.h.rctx); err != nil {
-		return err
-	}
-	return nil
+	meta := s.writeMeta
+	// Only the first entry of a write carries it.
+	s.writeMeta = nil
+	return s.saveEntry(entry, meta)
 }
 
-// Amend an old entry
+// Store a stream entry written by this instance, through the write pipeline
+// if enabled.
+func (s *Stream) saveEntry(entry *stream.StreamEntry, meta *EntryMeta) error {
+	return s.h.saveEntry(s.storage, entry, s.h.entryMeta(meta))
+}
+
+// Amend an old entry as written by this instance, through the write pipeline
+// if enabled.
 func (s *Stream) AmendEntry(entry *stream.StreamEntry, oldTimestamp time.Time) error {
-	_, err := s.dataTable.Get(oldTimestamp).Replace(entry).RunWrite(s.h.rctx)
-	return err
+	return s.h.amendEntry(s.storage, entry, oldTimestamp, s.h.entryMeta(nil))
 }
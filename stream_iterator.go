@@ -0,0 +1,151 @@
+package historian
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/fuserobotics/statestream"
+)
+
+// Number of entries fetched from the backend at a time.
+const defaultEntryPageSize = 100
+
+// Iterates over stored entries of a stream in timestamp order.
+//
+//	it := str.Entries(from, to, stream.StreamEntryAny)
+//	it.Limit = 500
+//	for it.Next() {
+//		entry := it.Entry()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//	nextPage := it.Token()
+type EntryIterator struct {
+	// Stop after this many entries, 0 for no limit.
+	Limit int
+	// Entries to fetch from the backend at a time.
+	PageSize int
+
+	storage    StreamBackend
+	after      time.Time
+	to         time.Time
+	filterType stream.StreamEntryType
+
+	page     []*stream.StreamEntry
+	lastPage bool
+	entry    *stream.StreamEntry
+	count    int
+	done     bool
+	err      error
+}
+
+// Iterate over the entries with timestamps in [from, to].
+func (s *Stream) Entries(from, to time.Time, filterType stream.StreamEntryType) *EntryIterator {
+	return newEntryIterator(s.storage, from, to, filterType)
+}
+
+func newEntryIterator(storage StreamBackend, from, to time.Time, filterType stream.StreamEntryType) *EntryIterator {
+	return &EntryIterator{
+		PageSize:   defaultEntryPageSize,
+		storage:    storage,
+		after:      from.Add(-time.Nanosecond),
+		to:         to,
+		filterType: filterType,
+	}
+}
+
+// Continue iterating after the entry a token from EntryIterator.Token refers to.
+func (s *Stream) EntriesAfterToken(token string, to time.Time, filterType stream.StreamEntryType) (*EntryIterator, error) {
+	after, err := parseEntryToken(token)
+	if err != nil {
+		return nil, err
+	}
+	it := s.Entries(after, to, filterType)
+	it.after = after
+	return it, nil
+}
+
+// Advance to the next entry. Returns false when done or on error.
+func (it *EntryIterator) Next() bool {
+	if it.done || it.err != nil {
+		return false
+	}
+	if it.Limit > 0 && it.count >= it.Limit {
+		it.done = true
+		return false
+	}
+
+	if len(it.page) == 0 {
+		if it.lastPage {
+			it.done = true
+			return false
+		}
+		pageSize := it.PageSize
+		if pageSize <= 0 {
+			pageSize = defaultEntryPageSize
+		}
+		if it.Limit > 0 && it.Limit-it.count < pageSize {
+			pageSize = it.Limit - it.count
+		}
+		page, err := it.storage.GetEntriesAfter(it.after, it.to, it.filterType, pageSize)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page = page
+		it.lastPage = len(page) < pageSize
+		if len(page) == 0 {
+			it.done = true
+			return false
+		}
+	}
+
+	it.entry = it.page[0]
+	it.page = it.page[1:]
+	it.after = it.entry.Timestamp
+	it.count++
+	return true
+}
+
+// The current entry.
+func (it *EntryIterator) Entry() *stream.StreamEntry {
+	return it.entry
+}
+
+// The error that stopped iteration, if any.
+func (it *EntryIterator) Err() error {
+	return it.err
+}
+
+// Opaque token to resume iterating after the current entry, see
+// Stream.EntriesAfterToken. Empty once the iterator has found no entries
+// left in range.
+func (it *EntryIterator) Token() string {
+	if it.exhausted() {
+		return ""
+	}
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint64(buf, uint64(it.after.Unix()))
+	binary.BigEndian.PutUint32(buf[8:], uint32(it.after.Nanosecond()))
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// Whether the backend has no entries in range left.
+func (it *EntryIterator) exhausted() bool {
+	return it.err == nil && it.lastPage && len(it.page) == 0
+}
+
+func parseEntryToken(token string) (time.Time, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) != 12 {
+		return time.Time{}, errors.New("Invalid entry token.")
+	}
+	nanos := binary.BigEndian.Uint32(buf[8:])
+	if nanos >= uint32(time.Second) {
+		return time.Time{}, errors.New("Invalid entry token.")
+	}
+	return time.Unix(int64(binary.BigEndian.Uint64(buf)), int64(nanos)), nil
+}
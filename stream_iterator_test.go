@@ -0,0 +1,137 @@
+package historian
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/statestream"
+)
+
+// Entry storage over a sorted slice, for iterators.
+type sliceStorage struct {
+	StreamBackend
+	entries []*stream.StreamEntry
+}
+
+func (s *sliceStorage) GetEntriesAfter(timestamp, until time.Time, filterType stream.StreamEntryType, limit int) ([]*stream.StreamEntry, error) {
+	var res []*stream.StreamEntry
+	for _, entry := range s.entries {
+		if len(res) == limit {
+			break
+		}
+		if !entry.Timestamp.After(timestamp) || entry.Timestamp.After(until) {
+			continue
+		}
+		if filterType != stream.StreamEntryAny && entry.Type != filterType {
+			continue
+		}
+		res = append(res, entry)
+	}
+	return res, nil
+}
+
+func (s *sliceStorage) GetSnapshotBefore(timestamp time.Time) (*stream.StreamEntry, error) {
+	var res *stream.StreamEntry
+	for _, entry := range s.entries {
+		if !entry.Timestamp.Before(timestamp) {
+			break
+		}
+		if entry.Type == stream.StreamEntrySnapshot {
+			res = entry
+		}
+	}
+	return res, nil
+}
+
+func (s *sliceStorage) GetEntryAfter(timestamp time.Time, filterType stream.StreamEntryType) (*stream.StreamEntry, error) {
+	res, err := s.GetEntriesAfter(timestamp, endOfTime, filterType, 1)
+	if err != nil || len(res) == 0 {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+func TestEntryTokenRoundTrip(t *testing.T) {
+	times := []time.Time{
+		time.Unix(0, 0),
+		time.Unix(1500000000, 123456789),
+		// Outside the range of UnixNano.
+		time.Date(1, 1, 1, 0, 0, 0, 1, time.UTC),
+		time.Date(9000, 12, 31, 23, 59, 59, 999999999, time.UTC),
+	}
+	for _, ts := range times {
+		it := &EntryIterator{after: ts}
+		parsed, err := parseEntryToken(it.Token())
+		if err != nil {
+			t.Fatalf("%v: %v", ts, err)
+		}
+		if !parsed.Equal(ts) {
+			t.Fatalf("expected %v, got %v", ts, parsed)
+		}
+	}
+
+	// Empty, malformed, short, and with too many nanoseconds.
+	for _, token := range []string{"", "not base64!", "AAAA", "AAAAAAAAAAD_____"} {
+		if _, err := parseEntryToken(token); err == nil {
+			t.Fatalf("expected %q to be rejected", token)
+		}
+	}
+}
+
+func TestEntryIteratorPages(t *testing.T) {
+	storage := &sliceStorage{}
+	for i := int64(1); i <= 5; i++ {
+		storage.entries = append(storage.entries, &stream.StreamEntry{
+			Type:      stream.StreamEntryMutation,
+			Timestamp: time.Unix(i, 0),
+		})
+	}
+	s := &Stream{storage: storage}
+
+	tests := []struct {
+		name      string
+		limit     int
+		pageSize  int
+		expected  []int64
+		exhausted bool
+	}{
+		{"all", 0, 2, []int64{1, 2, 3, 4, 5}, true},
+		{"limited", 3, 2, []int64{1, 2, 3}, false},
+		{"limit past the end", 6, 10, []int64{1, 2, 3, 4, 5}, true},
+	}
+	for _, test := range tests {
+		it := s.Entries(time.Unix(0, 0), endOfTime, stream.StreamEntryAny)
+		it.Limit = test.limit
+		it.PageSize = test.pageSize
+		var got []int64
+		for it.Next() {
+			got = append(got, it.Entry().Timestamp.Unix())
+		}
+		if it.Err() != nil {
+			t.Fatalf("%s: %v", test.name, it.Err())
+		}
+		if len(got) != len(test.expected) {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Fatalf("%s: expected %v, got %v", test.name, test.expected, got)
+			}
+		}
+
+		token := it.Token()
+		if test.exhausted {
+			if token != "" {
+				t.Fatalf("%s: expected no token once exhausted, got %q", test.name, token)
+			}
+			continue
+		}
+		rest, err := s.EntriesAfterToken(token, endOfTime, stream.StreamEntryAny)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !rest.Next() || rest.Entry().Timestamp.Unix() != test.expected[len(test.expected)-1]+1 {
+			t.Fatalf("%s: expected the token to resume after the last entry", test.name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package historian
+
+import (
+	"time"
+
+	"github.com/fuserobotics/statestream"
+)
+
+// A change to the live state of a stream.
+type StateChange struct {
+	Stream    *Stream
+	Timestamp time.Time
+	// The full state after the change. Must not be modified.
+	State stream.StateData
+	// The entry written, for local changes. Must not be modified.
+	Entry *stream.StreamEntry
+	// False if the entry was written by someone else.
+	Local bool
+	// True if a late entry changed the state after the fact. Timestamp is
+	// that of the late entry.
+	Late bool
+}
+
+// Called synchronously with each change to the live state of a stream.
+type StateChangeHandler func(change *StateChange)
+
+// Call handler with every later change to the live state of the stream.
+// Call the returned function to stop.
+func (s *Stream) OnStateChange(handler StateChangeHandler) (cancel func()) {
+	s.handlersMtx.Lock()
+	defer s.handlersMtx.Unlock()
+
+	id := s.nextHandlerId
+	s.nextHandlerId++
+	s.stateHandlers[id] = handler
+	return func() {
+		s.handlersMtx.Lock()
+		delete(s.stateHandlers, id)
+		s.handlersMtx.Unlock()
+	}
+}
+
+// Write an entry to the live state of the stream.
+func (s *Stream) WriteEntry(entry *stream.StreamEntry) error {
+	return s.writeEntry(entry, nil)
+}
+
+// Write an entry to the live state, storing meta with it if set.
+func (s *Stream) writeEntry(entry *stream.StreamEntry, meta *EntryMeta) error {
+	s.writeMtx.Lock()
+	s.writeMeta = meta
+	err := s.StateStream.WriteEntry(entry)
+	s.writeMeta = nil
+	s.writeMtx.Unlock()
+	if err != nil {
+		return err
+	}
+	writeCursor, err := s.StateStream.WriteCursor()
+	if err != nil {
+		return err
+	}
+	return s.notifyStateChange(writeCursor, &StateChange{Timestamp: entry.Timestamp, Entry: entry, Local: true})
+}
+
+// Fill in the stream and state of a change and pass it to the handlers.
+func (s *Stream) notifyStateChange(writeCursor *stream.Cursor, change *StateChange) error {
+	s.handlersMtx.Lock()
+	handlers := make([]StateChangeHandler, 0, len(s.stateHandlers))
+	for _, handler := range s.stateHandlers {
+		handlers = append(handlers, handler)
+	}
+	s.handlersMtx.Unlock()
+	if len(handlers) == 0 {
+		return nil
+	}
+
+	var state stream.StateData
+	err := writeCursor.WriteGuard(func() (err error) {
+		state, err = writeCursor.State()
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	change.Stream = s
+	change.State = state
+	for _, handler := range handlers {
+		handler(change)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package historian
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/statestream"
+)
+
+func TestCaughtUpRepeats(t *testing.T) {
+	entry := func(sec int64) *stream.StreamEntry {
+		return &stream.StreamEntry{Timestamp: time.Unix(sec, 0)}
+	}
+	caught := &caughtUp{}
+	for _, sec := range []int64{2, 3, 4} {
+		caught.add(entry(sec))
+	}
+
+	// The feed repeats 3, announces a late entry at 1, passes the head at 5
+	// and then announces a late entry at 2.
+	steps := []struct {
+		sec      int64
+		repeated bool
+	}{
+		{3, true},
+		{3, false},
+		{1, false},
+		{5, false},
+		{2, false},
+	}
+	for i, step := range steps {
+		if got := caught.repeated(entry(step.sec)); got != step.repeated {
+			t.Fatalf("step %d: expected repeated %v for %d, got %v", i, step.repeated, step.sec, got)
+		}
+	}
+	if caught.handled != nil {
+		t.Fatalf("expected the caught up entries to be forgotten, got %v", caught.handled)
+	}
+
+	if (&caughtUp{}).repeated(entry(1)) {
+		t.Fatal("expected nothing repeated without caught up entries")
+	}
+}
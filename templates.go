@@ -0,0 +1,232 @@
+package historian
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fuserobotics/historian/dbproto"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+)
+
+// Follow the stream templates and device records, like backgroundSync.
+func (h *Historian) deviceSync(initChan chan error) (disposed bool) {
+	initDone := false
+	defer func() {
+		if !disposed && (initDone || initChan == nil) {
+			glog.Warningf("Lost connection to template and device changes, retrying...")
+			time.Sleep(time.Duration(3) * time.Second)
+			go h.deviceSync(nil)
+		}
+	}()
+	feed, err := h.loadDevices()
+	if err != nil {
+		glog.Warningf("Error loading templates and devices from db: %v\n", err)
+		if initChan != nil {
+			initChan <- err
+		}
+		return
+	}
+
+	if initChan != nil {
+		close(initChan)
+	}
+	initDone = true
+
+	defer feed.Close()
+
+	changesChan := feed.Changes()
+	for {
+		select {
+		case <-h.dispose:
+			return true
+		case cha, ok := <-changesChan:
+			if !ok {
+				glog.Warningf("Error listening to template and device changes, %v", feed.Err())
+				return
+			}
+			h.handleDeviceChange(cha)
+		}
+	}
+}
+
+// Full reload of the templates and devices.
+func (h *Historian) loadDevices() (DeviceChangeFeed, error) {
+	templates, devices, feed, err := h.backend.WatchDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	h.mtx.Lock()
+	h.templates = make(map[string]*dbproto.StreamTemplate)
+	for _, tmpl := range templates {
+		h.templates[tmpl.Id] = tmpl
+	}
+	h.devices = make(map[string]*dbproto.Device)
+	for _, dev := range devices {
+		h.devices[dev.Id] = dev
+	}
+	ids := h.expandDevices()
+	h.mtx.Unlock()
+
+	h.syncKnownStreams(ids)
+	return feed, nil
+}
+
+func (h *Historian) handleDeviceChange(cha *DeviceChange) {
+	h.mtx.Lock()
+
+	if cha.OldTemplate != nil {
+		delete(h.templates, cha.OldTemplate.Id)
+	}
+	if cha.NewTemplate != nil {
+		glog.Infof("Updating stream template %s", cha.NewTemplate.Id)
+		h.templates[cha.NewTemplate.Id] = cha.NewTemplate
+	}
+	if cha.OldDevice != nil {
+		delete(h.devices, cha.OldDevice.Id)
+	}
+	if cha.NewDevice != nil {
+		glog.Infof("Updating device %s", cha.NewDevice.Id)
+		h.devices[cha.NewDevice.Id] = cha.NewDevice
+	}
+	ids := h.expandDevices()
+	h.mtx.Unlock()
+
+	h.syncKnownStreams(ids)
+}
+
+// Recompute the streams of every device with a template. Returns the IDs
+// of the expanded streams that changed, for syncKnownStreams. Call with mtx
+// held.
+func (h *Historian) expandDevices() (changed []string) {
+	expanded := make(map[string]*dbproto.Stream)
+	patterns := make(map[string][]*dbproto.Stream)
+	for _, dev := range h.devices {
+		tmpl, ok := h.templates[dev.Template]
+		if !ok && dev.Template != "" {
+			glog.Warningf("Device %s uses unknown template %s", dev.Id, dev.Template)
+		}
+		for _, data := range expandDevice(dev, tmpl) {
+			if isStreamPattern(data) {
+				patterns[dev.Id] = append(patterns[dev.Id], data)
+				continue
+			}
+			expanded[data.Id] = data
+		}
+	}
+
+	old := h.expandedStreams
+	h.expandedStreams = expanded
+	h.streamPatterns = patterns
+	for id, data := range old {
+		if exp, ok := expanded[id]; !ok || !proto.Equal(exp, data) {
+			changed = append(changed, id)
+		}
+	}
+	for id := range expanded {
+		if _, ok := old[id]; !ok {
+			changed = append(changed, id)
+		}
+	}
+	return changed
+}
+
+// The streams of a device: those of its template, replaced or extended by
+// its overrides, and those it registered, expanded from the first pattern
+// they match.
+func expandDevice(dev *dbproto.Device, tmpl *dbproto.StreamTemplate) []*dbproto.Stream {
+	var defs []*dbproto.Stream
+	if tmpl != nil {
+		defs = append(defs, tmpl.Streams...)
+	}
+	defs = append(defs, dev.Overrides...)
+
+	res := make([]*dbproto.Stream, 0, len(defs))
+	index := make(map[string]int)
+	for _, def := range defs {
+		data := proto.Clone(def).(*dbproto.Stream)
+		data.DeviceHostname = dev.Id
+		data.Id = DbStreamTableName(data)
+		if i, ok := index[data.Id]; ok {
+			res[i] = data
+			continue
+		}
+		index[data.Id] = len(res)
+		res = append(res, data)
+	}
+
+	for _, reg := range dev.Registered {
+		pattern := matchPattern(res, reg.ComponentName, reg.StateName)
+		if pattern == nil {
+			// The pattern was removed, the stream goes with it.
+			continue
+		}
+		data := proto.Clone(pattern).(*dbproto.Stream)
+		data.ComponentName = reg.ComponentName
+		data.StateName = reg.StateName
+		data.Source = dbproto.Stream_PUSH
+		data.Id = DbStreamTableName(data)
+		// Concrete template and override streams take precedence.
+		if _, ok := index[data.Id]; ok {
+			continue
+		}
+		index[data.Id] = len(res)
+		res = append(res, data)
+	}
+	return res
+}
+
+// Whether a device registered a stream.
+func HasDeviceStream(dev *dbproto.Device, stream *dbproto.DeviceStream) bool {
+	for _, reg := range dev.Registered {
+		if reg.ComponentName == stream.ComponentName && reg.StateName == stream.StateName {
+			return true
+		}
+	}
+	return false
+}
+
+// Record a stream registered on a device before the backend announces it.
+func (h *Historian) registerDeviceStream(hostname string, stream *dbproto.DeviceStream) {
+	h.mtx.Lock()
+	dev, ok := h.devices[hostname]
+	if !ok || HasDeviceStream(dev, stream) {
+		h.mtx.Unlock()
+		return
+	}
+	dev = proto.Clone(dev).(*dbproto.Device)
+	dev.Registered = append(dev.Registered, stream)
+	h.devices[hostname] = dev
+	ids := h.expandDevices()
+	h.mtx.Unlock()
+
+	h.syncKnownStreams(ids)
+}
+
+// Whether a template stream names its component or state with a pattern.
+func isStreamPattern(data *dbproto.Stream) bool {
+	return strings.ContainsAny(data.ComponentName+data.StateName, "*?[")
+}
+
+// The template stream of a device whose patterns match a component and
+// state, nil if none. Call with mtx held.
+func (h *Historian) matchStreamPattern(hostname, componentName, stateName string) *dbproto.Stream {
+	return matchPattern(h.streamPatterns[hostname], componentName, stateName)
+}
+
+// The first pattern stream matching a component and state, nil if none.
+func matchPattern(streams []*dbproto.Stream, componentName, stateName string) *dbproto.Stream {
+	for _, data := range streams {
+		if !isStreamPattern(data) {
+			continue
+		}
+		componentMatch, _ := path.Match(data.ComponentName, componentName)
+		stateMatch, _ := path.Match(data.StateName, stateName)
+		if componentMatch && stateMatch {
+			return data
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package historian_test
+
+import (
+	"testing"
+
+	"github.com/fuserobotics/historian"
+	"github.com/fuserobotics/historian/backend/memory"
+	"github.com/fuserobotics/historian/dbproto"
+)
+
+func fixedWing(latenessWindow uint64) *dbproto.StreamTemplate {
+	return &dbproto.StreamTemplate{
+		Id: "fixed-wing",
+		Streams: []*dbproto.Stream{
+			{ComponentName: "fc", StateName: "state"},
+			{ComponentName: "sensor_rx", StateName: "*", LatenessWindow: latenessWindow},
+		},
+	}
+}
+
+func TestTemplatePatternRegistration(t *testing.T) {
+	b := memory.NewBackend()
+	b.PutTemplate(fixedWing(1000))
+	b.PutDevice(&dbproto.Device{Id: "plane_1", Template: "fixed-wing"})
+	h := historian.NewHistorian(b)
+	if err := h.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Dispose()
+
+	for i := 0; i < 2; i++ {
+		if _, err := h.GetPushStream("plane_1", "sensor_rx", "sensor_233"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id := "plane_1_sensor_rx_sensor_233"
+	data := knownStream(h, id)
+	if data == nil || data.LatenessWindow != 1000 || data.Source != dbproto.Stream_PUSH {
+		t.Fatalf("expected the stream expanded from the pattern, got %v", data)
+	}
+
+	// Recorded once on the device, not in the streams table.
+	streams, feed, err := b.WatchStreams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.Close()
+	if len(streams) != 0 {
+		t.Fatalf("expected no stream rows, got %v", streams)
+	}
+	_, devices, dfeed, err := b.WatchDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dfeed.Close()
+	if len(devices) != 1 || len(devices[0].Registered) != 1 {
+		t.Fatalf("expected one registered stream, got %v", devices)
+	}
+
+	// Only concrete streams reach the reporter.
+	config, err := h.BuildRemoteStreamConfig("plane_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Streams) != 2 {
+		t.Fatalf("expected the two concrete streams, got %v", config.Streams)
+	}
+	for _, str := range config.Streams {
+		if str.StateId == "*" {
+			t.Fatal("expected the pattern to be left out")
+		}
+	}
+
+	// Template changes reach registered streams.
+	b.PutTemplate(fixedWing(2000))
+	waitFor(t, "the template change", func() bool {
+		data := knownStream(h, id)
+		return data != nil && data.LatenessWindow == 2000
+	})
+
+	// Removing the pattern removes the stream.
+	b.PutTemplate(&dbproto.StreamTemplate{Id: "fixed-wing", Streams: fixedWing(0).Streams[:1]})
+	waitFor(t, "the stream to be removed", func() bool { return knownStream(h, id) == nil })
+}
@@ -0,0 +1,174 @@
+package historian
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fuserobotics/statestream"
+)
+
+var errPipelineClosed = errors.New("Write pipeline closed.")
+
+type writeRequest struct {
+	write *EntryWrite
+	// Timestamp of the entry to amend, nil to store a new one.
+	amend  *time.Time
+	result chan error
+}
+
+// Coalesces entry writes from all streams into batched backend writes.
+// Each stream writes one entry at a time, so batches are formed across
+// streams and per-stream order is preserved. Amendments are applied in
+// order with the batches, one at a time.
+type writePipeline struct {
+	backend    Backend
+	maxBatch   int
+	maxLatency time.Duration
+	requests   chan *writeRequest
+
+	// Closed to stop the pipeline, and once it has stopped.
+	stop     chan bool
+	stopOnce sync.Once
+	done     chan bool
+}
+
+func newWritePipeline(backend Backend, maxBatch int, maxLatency time.Duration) *writePipeline {
+	p := &writePipeline{
+		backend:    backend,
+		maxBatch:   maxBatch,
+		maxLatency: maxLatency,
+		requests:   make(chan *writeRequest, maxBatch),
+		stop:       make(chan bool),
+		done:       make(chan bool),
+	}
+	go p.run()
+	return p
+}
+
+// Queue an entry and wait for the batch containing it to commit.
+func (p *writePipeline) save(storage StreamBackend, entry *stream.StreamEntry, meta *EntryMeta) error {
+	return p.submit(&writeRequest{
+		write:  &EntryWrite{Storage: storage, Entry: entry, Meta: meta},
+		result: make(chan error, 1),
+	})
+}
+
+// Queue an amendment of the entry at oldTimestamp and wait for it.
+func (p *writePipeline) amend(storage StreamBackend, entry *stream.StreamEntry, oldTimestamp time.Time, meta *EntryMeta) error {
+	return p.submit(&writeRequest{
+		write:  &EntryWrite{Storage: storage, Entry: entry, Meta: meta},
+		amend:  &oldTimestamp,
+		result: make(chan error, 1),
+	})
+}
+
+func (p *writePipeline) submit(req *writeRequest) error {
+	select {
+	case p.requests <- req:
+	case <-p.stop:
+		return errPipelineClosed
+	}
+	select {
+	case err := <-req.result:
+		return err
+	case <-p.done:
+		// The request may have been handled just before stopping.
+		select {
+		case err := <-req.result:
+			return err
+		default:
+			return errPipelineClosed
+		}
+	}
+}
+
+// Stop the pipeline once the batch in progress is written. Queued and later
+// writes fail.
+func (p *writePipeline) close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+	<-p.done
+}
+
+func (p *writePipeline) run() {
+	defer close(p.done)
+
+	for {
+		var req *writeRequest
+		select {
+		case req = <-p.requests:
+		case <-p.stop:
+			return
+		}
+		if req.amend != nil {
+			p.applyAmend(req)
+			continue
+		}
+
+		batch := []*writeRequest{req}
+		// An amendment ends the batch, to keep the order of writes.
+		var amend *writeRequest
+		timer := time.NewTimer(p.maxLatency)
+	CollectLoop:
+		for len(batch) < p.maxBatch {
+			select {
+			case req := <-p.requests:
+				if req.amend != nil {
+					amend = req
+					break CollectLoop
+				}
+				batch = append(batch, req)
+			case <-timer.C:
+				break CollectLoop
+			case <-p.stop:
+				break CollectLoop
+			}
+		}
+		timer.Stop()
+		p.flush(batch)
+		if amend != nil {
+			p.applyAmend(amend)
+		}
+	}
+}
+
+func (p *writePipeline) flush(batch []*writeRequest) {
+	writes := make([]*EntryWrite, len(batch))
+	for i, req := range batch {
+		writes[i] = req.write
+	}
+	errs := p.backend.SaveEntries(writes)
+	for i, req := range batch {
+		req.result <- errs[i]
+	}
+}
+
+func (p *writePipeline) applyAmend(req *writeRequest) {
+	req.result <- p.backend.AmendEntry(req.write, *req.amend)
+}
+
+// Store an entry in a stream with meta if set, through the write pipeline if
+// enabled.
+func (h *Historian) saveEntry(storage StreamBackend, entry *stream.StreamEntry, meta *EntryMeta) error {
+	if h.writes != nil {
+		return h.writes.save(storage, entry, meta)
+	}
+	if meta != nil {
+		return h.backend.SaveEntries([]*EntryWrite{{Storage: storage, Entry: entry, Meta: meta}})[0]
+	}
+	return storage.SaveEntry(entry)
+}
+
+// Amend an entry in a stream with meta if set, through the write pipeline if
+// enabled.
+func (h *Historian) amendEntry(storage StreamBackend, entry *stream.StreamEntry, oldTimestamp time.Time, meta *EntryMeta) error {
+	if h.writes != nil {
+		return h.writes.amend(storage, entry, oldTimestamp, meta)
+	}
+	if meta != nil {
+		return h.backend.AmendEntry(&EntryWrite{Storage: storage, Entry: entry, Meta: meta}, oldTimestamp)
+	}
+	return storage.AmendEntry(entry, oldTimestamp)
+}
@@ -0,0 +1,128 @@
+package historian
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fuserobotics/statestream"
+)
+
+// Backend recording the writes it is asked for.
+type recordingBackend struct {
+	Backend
+
+	mtx     sync.Mutex
+	batches []int
+	ops     []string
+}
+
+func (b *recordingBackend) SaveEntries(writes []*EntryWrite) []error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.batches = append(b.batches, len(writes))
+	for _, write := range writes {
+		b.ops = append(b.ops, fmt.Sprintf("save %d", write.Entry.Timestamp.Unix()))
+	}
+	return make([]error, len(writes))
+}
+
+func (b *recordingBackend) AmendEntry(write *EntryWrite, oldTimestamp time.Time) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.ops = append(b.ops, fmt.Sprintf("amend %d", oldTimestamp.Unix()))
+	return nil
+}
+
+type recordingStorage struct {
+	StreamBackend
+	b *recordingBackend
+}
+
+func testEntry(sec int64) *stream.StreamEntry {
+	return &stream.StreamEntry{
+		Type:      stream.StreamEntrySnapshot,
+		Data:      stream.StateData{},
+		Timestamp: time.Unix(sec, 0),
+	}
+}
+
+func TestWritePipelineBatches(t *testing.T) {
+	b := &recordingBackend{}
+	p := newWritePipeline(b, 4, 20*time.Millisecond)
+	defer p.close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := p.save(&recordingStorage{b: b}, testEntry(int64(i)), nil); err != nil {
+				t.Errorf("save %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, size := range b.batches {
+		if size > 4 {
+			t.Fatalf("batch of %d exceeds the limit", size)
+		}
+		total += size
+	}
+	if total != 10 {
+		t.Fatalf("expected 10 writes, got %d", total)
+	}
+}
+
+func TestWritePipelineOrder(t *testing.T) {
+	b := &recordingBackend{}
+	storage := &recordingStorage{b: b}
+	p := newWritePipeline(b, 4, time.Millisecond)
+	defer p.close()
+
+	steps := []struct {
+		op  string
+		run func() error
+	}{
+		{"save 1", func() error { return p.save(storage, testEntry(1), nil) }},
+		{"amend 1", func() error { return p.amend(storage, testEntry(1), time.Unix(1, 0), nil) }},
+		{"save 2", func() error { return p.save(storage, testEntry(2), nil) }},
+		{"amend 2", func() error { return p.amend(storage, testEntry(2), time.Unix(2, 0), nil) }},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.op, err)
+		}
+	}
+	for i, step := range steps {
+		if b.ops[i] != step.op {
+			t.Fatalf("expected %v, got %v", step.op, b.ops)
+		}
+	}
+}
+
+func TestWritePipelineClose(t *testing.T) {
+	b := &recordingBackend{}
+	p := newWritePipeline(b, 4, time.Millisecond)
+	p.close()
+	// Closing twice is harmless.
+	p.close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.save(&recordingStorage{b: b}, testEntry(1), nil)
+	}()
+	select {
+	case err := <-done:
+		if err != errPipelineClosed {
+			t.Fatalf("expected %v, got %v", errPipelineClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("save blocked on a closed pipeline")
+	}
+}
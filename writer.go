@@ -0,0 +1,27 @@
+package historian
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+)
+
+// A unique ID for this process: the hostname and a random suffix.
+func newInstanceId() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "historian"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// A copy of meta naming this instance as the writer.
+func (h *Historian) entryMeta(meta *EntryMeta) *EntryMeta {
+	res := &EntryMeta{Writer: h.InstanceId}
+	if meta != nil {
+		res.Sequence = meta.Sequence
+	}
+	return res
+}